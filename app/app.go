@@ -2,13 +2,17 @@ package app
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"embed"
+	"encoding/base64"
 	"errors"
 	"html/template"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -18,32 +22,148 @@ import (
 )
 
 var (
-	db        *sql.DB
-	dbDriver  string
-	templates *template.Template
-	log       = logrus.New()
-	auth      AuthConfig
-	assetsFS  embed.FS
+	db         *sql.DB
+	dbDriver   string
+	dbDSN      string
+	templates  *template.Template
+	log        = logrus.New()
+	auth       AuthConfig
+	assetsFS   embed.FS
+	treeLimits = defaultTreeLimits()
+	pageConfig = defaultPageConfig()
+	baseURL    = "http://localhost:9090"
+
+	// authCookie is the login cookie's name, scope, and flags.
+	authCookie = defaultCookieConfig()
+	// originPolicy decides which form submissions count as same-site.
+	originPolicy = defaultOriginConfig()
+	// markdownImages bounds markdown images and sets the remote image policy.
+	markdownImages = defaultImageConfig()
+	// postRenderCache holds recently rendered post HTML; Run sizes it from
+	// JANK_RENDER_CACHE_SIZE.
+	postRenderCache = newRenderCache(defaultRenderCacheSize)
+	// storeRenderedPosts saves each post's rendered HTML alongside its
+	// markdown, so thread views don't render at read time.
+	storeRenderedPosts = true
+	// retentionAge is how long a thread may go without a post before the
+	// janitor deletes it, unless its board sets its own; zero keeps threads.
+	retentionAge time.Duration
+	// showPostEmail renders non-sage post email fields as mailto links.
+	showPostEmail bool
+	// postVotesEnabled turns post voting and scores on.
+	postVotesEnabled = true
+	// maxThreadsShown caps, and is the default for, the cursor API's limit.
+	maxThreadsShown = 50
+	// trendingWindow is how far back post velocity is measured for trending.
+	trendingWindow = 24 * time.Hour
+	// requireAuthRead hides every page and API read from anonymous visitors.
+	requireAuthRead bool
+	// postCooldown is the minimum time between one user's posts. Run sets
+	// it from JANK_POST_COOLDOWN; zero turns flood control off.
+	postCooldown time.Duration
+	// bumpCooldown is how long the thread view counts down after a bump
+	// before suggesting another.
+	bumpCooldown = 3 * time.Minute
+	// necroThreshold is how long a thread can go without a bump before its
+	// page warns against reviving it, unless its board sets its own; zero
+	// turns the warning off.
+	necroThreshold = 30 * 24 * time.Hour
+	// recordPostIPs stores each new post's client address for the
+	// moderator IP lookup.
+	recordPostIPs = true
+	// trustedProxies are the reverse proxies whose X-Forwarded-For hops
+	// clientIP believes; Run sets them from JANK_TRUSTED_PROXIES.
+	trustedProxies []*net.IPNet
+	// minPasswordLength is the shortest password signup and password resets
+	// accept.
+	minPasswordLength = 8
+	// rejectCommonPasswords refuses passwords from the built-in list of the
+	// most guessed ones.
+	rejectCommonPasswords = true
+	// collapsePostLength is the reply length, in characters, past which the
+	// thread view folds a post behind a preview.
+	collapsePostLength = 2000
+	// maxBoards caps how many boards can exist; zero means no limit.
+	maxBoards int
+	// prettyJSON indents API responses by default; JANK_JSON_PRETTY sets it
+	// and ?pretty= overrides it per request.
+	prettyJSON bool
+	// contentSecurityPolicy is sent on every response; JANK_CSP replaces it.
+	// Any {nonce} in it becomes that request's script/style nonce.
+	contentSecurityPolicy = defaultContentSecurityPolicy
 )
 
+// defaultContentSecurityPolicy only runs inline scripts and styles carrying
+// the per-request nonce (securityHeaders fills in {nonce}). It also allows
+// Google Fonts, card art from any https host, and Scryfall lookups. Inline
+// style attributes stay allowed for the templates' CSS variables.
+const defaultContentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' 'nonce-{nonce}'; " +
+	"style-src 'self' 'nonce-{nonce}' https://fonts.googleapis.com; " +
+	"style-src-attr 'unsafe-inline'; " +
+	"img-src 'self' data: https:; " +
+	"font-src 'self' https://fonts.gstatic.com; " +
+	"connect-src 'self' https://api.scryfall.com; " +
+	"object-src 'none'; " +
+	"base-uri 'self'; " +
+	"form-action 'self'; " +
+	"frame-ancestors 'none'"
+
+// defaultRenderCacheSize is how many rendered posts are kept when
+// JANK_RENDER_CACHE_SIZE is unset.
+const defaultRenderCacheSize = 5000
+
+// defaultPostCooldown is the flood-control interval when JANK_POST_COOLDOWN
+// is unset.
+const defaultPostCooldown = 10 * time.Second
+
 func init() {
 	log.SetFormatter(&logrus.JSONFormatter{})
 	log.SetLevel(logrus.InfoLevel)
 }
 
+type cspNonceKey struct{}
+
+// cspNonce returns the nonce securityHeaders generated for this request.
+func cspNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+func newCSPNonce() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
 func securityHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce, err := newCSPNonce()
+		if err != nil {
+			log.Errorf("Failed to generate CSP nonce: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
 		w.Header().Set("X-Frame-Options", "DENY")
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
-		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; img-src 'self' data: https:; font-src 'self'")
-		next.ServeHTTP(w, r)
+		w.Header().Set("Content-Security-Policy", strings.ReplaceAll(contentSecurityPolicy, "{nonce}", nonce))
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, nonce)))
 	})
 }
 
+// maxRequestBytes caps request bodies other than attachment uploads.
+const maxRequestBytes = 1 << 20
+
 func limitBodySize(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1MB
+		limit := int64(maxRequestBytes)
+		if isAttachmentUpload(r) {
+			limit = maxAttachmentRequestBytes
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
 		next.ServeHTTP(w, r)
 	})
 }
@@ -51,7 +171,17 @@ func limitBodySize(next http.Handler) http.Handler {
 func Run(templatesFS embed.FS) error {
 	var err error
 
-	db, err = openDatabase()
+	cfg, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	configureLogger(log, cfg.LogLevel, cfg.LogFormat)
+	for _, problem := range cfg.Problems {
+		log.Warn(capitalize(problem.Error()))
+	}
+	log.WithFields(cfg.logFields()).Info("Loaded configuration")
+
+	db, err = openDatabase(cfg.DBDriver, cfg.DBDSN, cfg.SQLite)
 	if err != nil {
 		return err
 	}
@@ -61,7 +191,7 @@ func Run(templatesFS embed.FS) error {
 		return err
 	}
 
-	if err := seedData(db); err != nil {
+	if err := seedData(db, cfg.Seed); err != nil {
 		log.Printf("Failed to seed data: %v", err)
 	}
 
@@ -71,19 +201,30 @@ func Run(templatesFS embed.FS) error {
 		return err
 	}
 
-	auth = loadAuthConfig()
+	cfg.apply()
+	if cfg.ReadOnly {
+		log.Warn("Starting in read-only mode")
+	}
+	if policy, err := reloadNetworkPolicy(); err != nil {
+		return err
+	} else if len(policy.Blocked) > 0 {
+		log.Infof("Blocking writes from %d network ranges (%d allowed)", len(policy.Blocked), len(policy.Allowed))
+	}
+
+	if attachmentStore, err = newAttachmentStore(cfg.Attachments); err != nil {
+		return err
+	}
 
-	if err := ensureSeedUser(db, auth.Username, auth.Password); err != nil {
+	if err := ensureSeedUser(context.Background(), db, auth.Username, auth.Password); err != nil {
 		return err
 	}
 
 	r := buildRouter()
 	handler := securityHeaders(limitBodySize(r))
-	addr, logURL := serverAddr()
-	log.Infof("Server listening on %s", logURL)
+	log.Infof("Server listening on %s", cfg.listenURL)
 
 	srv := &http.Server{
-		Addr:              addr,
+		Addr:              cfg.Addr,
 		Handler:           handler,
 		ReadTimeout:       10 * time.Second,
 		ReadHeaderTimeout: 5 * time.Second,
@@ -93,10 +234,46 @@ func Run(templatesFS embed.FS) error {
 
 	shutdownCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
+	workers := newWorkerManager()
+	workers.Register(storeGCWorker(ttlStoreGCInterval))
+	if cfg.RetentionInterval > 0 {
+		workers.Register(retentionWorker(cfg.RetentionInterval))
+	}
+	workers.Start(shutdownCtx)
 
+	// SIGHUP reloads the blocked/allowed network lists.
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+	go func() {
+		for range reload {
+			if policy, err := reloadNetworkPolicy(); err != nil {
+				log.Errorf("Failed to reload network lists: %v", err)
+			} else {
+				log.Infof("Reloaded network lists: %d blocked, %d allowed", len(policy.Blocked), len(policy.Allowed))
+			}
+		}
+	}()
+
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		workers.Stop(cfg.ShutdownTimeout)
+		return err
+	}
+	err = serveUntil(shutdownCtx, srv, ln, cfg.ShutdownTimeout)
+	if !workers.Stop(cfg.ShutdownTimeout) {
+		log.Warnf("Background workers still running after %s; exiting anyway", cfg.ShutdownTimeout)
+	}
+	return err
+}
+
+// serveUntil serves on ln until ctx is done, then stops accepting
+// connections and gives in-flight requests and queued webhook deliveries
+// up to grace to finish before cutting them off.
+func serveUntil(ctx context.Context, srv *http.Server, ln net.Listener, grace time.Duration) error {
 	serverErr := make(chan error, 1)
 	go func() {
-		serverErr <- srv.ListenAndServe()
+		serverErr <- srv.Serve(ln)
 	}()
 
 	select {
@@ -105,17 +282,21 @@ func Run(templatesFS embed.FS) error {
 			return err
 		}
 		return nil
-	case <-shutdownCtx.Done():
-		log.Info("Shutdown signal received")
+	case <-ctx.Done():
+		log.Infof("Shutdown signal received; draining for up to %s", grace)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	drainCtx, cancel := context.WithTimeout(context.Background(), grace)
 	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
-		return err
+	if err := srv.Shutdown(drainCtx); err != nil {
+		log.Warnf("Requests still running after %s; closing them: %v", grace, err)
+		srv.Close()
+	}
+	if pending := drainWebhooks(drainCtx); pending > 0 {
+		log.Warnf("Dropped %d webhook deliveries still pending at shutdown", pending)
 	}
 
-	err = <-serverErr
+	err := <-serverErr
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
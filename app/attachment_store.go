@@ -0,0 +1,35 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// createAttachment records an uploaded image whose blobs are already in the
+// attachment store, filling in its ID and creation time.
+func createAttachment(ctx context.Context, db *sql.DB, a *Attachment) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	a.Created = time.Now()
+	if dbDriver == "pgx" {
+		return db.QueryRowContext(ctx, `
+			INSERT INTO attachments (storage_key, content_type, size, width, height, thumb_key, thumb_width, thumb_height, uploaded_by, created)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			RETURNING id`,
+			a.Key, a.ContentType, a.Size, a.Width, a.Height, a.ThumbnailKey, a.ThumbnailWidth, a.ThumbnailHeight, a.UploadedBy, a.Created).Scan(&a.ID)
+	}
+	result, err := db.ExecContext(ctx, `
+		INSERT INTO attachments (storage_key, content_type, size, width, height, thumb_key, thumb_width, thumb_height, uploaded_by, created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		a.Key, a.ContentType, a.Size, a.Width, a.Height, a.ThumbnailKey, a.ThumbnailWidth, a.ThumbnailHeight, a.UploadedBy, a.Created)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	a.ID = int(id)
+	return nil
+}
@@ -0,0 +1,494 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ------------------- Attachments -------------------
+
+// AttachmentStore keeps uploaded files. Handlers only go through this
+// interface, so the backend (local disk or S3) is picked at startup.
+type AttachmentStore interface {
+	// Put stores the contents of r under key, replacing anything there.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Get opens the blob stored under key, or returns errAttachmentNotFound.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes key; deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL is where browsers can fetch key.
+	URL(key string) string
+}
+
+var errAttachmentNotFound = errors.New("attachment not found")
+
+// attachmentStore is the configured backend; nil turns uploads off. Run sets
+// it from Config.Attachments.
+var attachmentStore AttachmentStore
+
+// maxAttachmentBytes caps one upload.
+const maxAttachmentBytes = 1 << 20
+
+// maxAttachmentRequestBytes is limitBodySize's cap for upload requests: a
+// full-size file plus room for the multipart framing around it.
+const maxAttachmentRequestBytes = maxAttachmentBytes + 64<<10
+
+// isAttachmentUpload reports whether r is a POST to the upload endpoint,
+// under /api/v1 or its legacy alias.
+func isAttachmentUpload(r *http.Request) bool {
+	return r.Method == http.MethodPost && (r.URL.Path == "/attachments" || r.URL.Path == apiV1Prefix+"/attachments")
+}
+
+// attachmentTypes maps the image types accepted for upload to the extension
+// their keys get. WebP is refused on purpose: every upload is decoded and
+// re-encoded to drop its metadata, and the standard library has no WebP
+// codec to do that with.
+var attachmentTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// attachmentKeyPattern matches the keys newAttachmentKey makes (with an "s"
+// before the extension for thumbnails), which keeps path separators and
+// dot-dot out of storage paths.
+var attachmentKeyPattern = regexp.MustCompile(`^[0-9a-f]{32}s?\.[a-z]+$`)
+
+func validAttachmentKey(key string) bool {
+	return attachmentKeyPattern.MatchString(key)
+}
+
+func newAttachmentKey(ext string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf) + ext, nil
+}
+
+// AttachmentConfig picks the attachment backend and holds its settings.
+type AttachmentConfig struct {
+	// Store is "fs" or "s3".
+	Store string
+	// Dir is where the fs store keeps files.
+	Dir string
+	S3  S3Config
+}
+
+// S3Config locates the bucket the s3 store writes to and the key pair it
+// signs requests with.
+type S3Config struct {
+	Bucket    string
+	Region    string
+	Endpoint  string
+	PublicURL string
+	AccessKey string
+	SecretKey string
+}
+
+// loadAttachmentConfig reads JANK_ATTACHMENT_STORE and the settings of the
+// store it names: JANK_ATTACHMENT_DIR for fs; JANK_S3_BUCKET,
+// JANK_S3_REGION, JANK_S3_ENDPOINT, JANK_S3_PUBLIC_URL, and the access key
+// pair from JANK_S3_ACCESS_KEY_ID / JANK_S3_SECRET_ACCESS_KEY (or the usual
+// AWS_ variables) for s3. An s3 store without a bucket and key pair falls
+// back to fs.
+func loadAttachmentConfig(l *configLoader) AttachmentConfig {
+	cfg := AttachmentConfig{
+		Store: l.choice("JANK_ATTACHMENT_STORE", "fs", []string{"fs", "s3"}),
+		Dir:   getenvTrim("JANK_ATTACHMENT_DIR"),
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = "attachments"
+	}
+	if cfg.Store != "s3" {
+		return cfg
+	}
+
+	cfg.S3 = S3Config{
+		Bucket:    getenvTrim("JANK_S3_BUCKET"),
+		Region:    getenvTrim("JANK_S3_REGION"),
+		Endpoint:  strings.TrimRight(getenvTrim("JANK_S3_ENDPOINT"), "/"),
+		PublicURL: strings.TrimRight(getenvTrim("JANK_S3_PUBLIC_URL"), "/"),
+		AccessKey: firstEnv("JANK_S3_ACCESS_KEY_ID", "AWS_ACCESS_KEY_ID"),
+		SecretKey: firstEnv("JANK_S3_SECRET_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY"),
+	}
+	if cfg.S3.Region == "" {
+		cfg.S3.Region = "us-east-1"
+	}
+	if cfg.S3.Endpoint == "" {
+		cfg.S3.Endpoint = "https://s3." + cfg.S3.Region + ".amazonaws.com"
+	}
+	if cfg.S3.Bucket == "" || cfg.S3.AccessKey == "" || cfg.S3.SecretKey == "" {
+		l.addf("the s3 attachment store needs JANK_S3_BUCKET and an access key pair; using fs")
+		cfg.Store = "fs"
+		cfg.S3 = S3Config{}
+	}
+	return cfg
+}
+
+// newAttachmentStore builds the backend cfg names.
+func newAttachmentStore(cfg AttachmentConfig) (AttachmentStore, error) {
+	if cfg.Store == "s3" {
+		return newS3AttachmentStore(cfg.S3), nil
+	}
+	return newFSAttachmentStore(cfg.Dir)
+}
+
+// fsAttachmentStore keeps attachments as files in one directory and serves
+// them from /attachments/{key}.
+type fsAttachmentStore struct {
+	dir string
+}
+
+func newFSAttachmentStore(dir string) (*fsAttachmentStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create attachment directory: %w", err)
+	}
+	return &fsAttachmentStore{dir: dir}, nil
+}
+
+func (s *fsAttachmentStore) path(key string) (string, error) {
+	if !validAttachmentKey(key) {
+		return "", errAttachmentNotFound
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+// Put writes to a temporary file first so readers never see a partial blob.
+func (s *fsAttachmentStore) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *fsAttachmentStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errAttachmentNotFound
+	}
+	return f, err
+}
+
+func (s *fsAttachmentStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *fsAttachmentStore) URL(key string) string {
+	return "/attachments/" + key
+}
+
+// s3AttachmentStore keeps attachments in an S3-compatible bucket (AWS, R2,
+// MinIO), addressed path-style and signed with AWS Signature Version 4.
+//
+// The signing is done here because the module doesn't depend on
+// aws-sdk-go-v2 yet. It only covers single-part PUT, GET, and DELETE with
+// static keys; anything past that (multipart uploads, instance roles, SSO)
+// should move this type onto the SDK's s3 client rather than grow the signer.
+type s3AttachmentStore struct {
+	endpoint  string
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	publicURL string
+	client    *http.Client
+}
+
+// newS3AttachmentStore builds the s3 store from settings
+// loadAttachmentConfig has already checked.
+func newS3AttachmentStore(cfg S3Config) *s3AttachmentStore {
+	return &s3AttachmentStore{
+		bucket:    cfg.Bucket,
+		region:    cfg.Region,
+		endpoint:  cfg.Endpoint,
+		publicURL: cfg.PublicURL,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *s3AttachmentStore) objectURL(key string) string {
+	return s.endpoint + "/" + url.PathEscape(s.bucket) + "/" + url.PathEscape(key)
+}
+
+func (s *s3AttachmentStore) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	if !validAttachmentKey(key) {
+		return errAttachmentNotFound
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, body, contentType)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3AttachmentStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !validAttachmentKey(key) {
+		return nil, errAttachmentNotFound
+	}
+	resp, err := s.do(ctx, http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3AttachmentStore) Delete(ctx context.Context, key string) error {
+	if !validAttachmentKey(key) {
+		return errAttachmentNotFound
+	}
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, "")
+	if errors.Is(err, errAttachmentNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// URL points at JANK_S3_PUBLIC_URL (a CDN or public bucket) when set, and
+// otherwise at /attachments/{key}, which jank proxies from the bucket.
+func (s *s3AttachmentStore) URL(key string) string {
+	if s.publicURL != "" {
+		return s.publicURL + "/" + url.PathEscape(key)
+	}
+	return "/attachments/" + key
+}
+
+// do sends a signed request for key and turns non-2xx responses into errors.
+func (s *s3AttachmentStore) do(ctx context.Context, method, key string, body []byte, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, body, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, errAttachmentNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return resp, nil
+}
+
+// sign adds AWS Signature Version 4 headers covering the host, date, and
+// payload hash.
+func (s *s3AttachmentStore) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// attachmentUploadHandler stores an image sent as the multipart "file" field,
+// with metadata stripped and a thumbnail alongside it, and returns the
+// recorded attachment (signed-in users only).
+func attachmentUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAPIAuth(w, r) {
+		return
+	}
+	if attachmentStore == nil {
+		respondJSONError(w, http.StatusNotFound, "uploads are disabled")
+		return
+	}
+	file, header, err := r.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondJSONError(w, http.StatusRequestEntityTooLarge, "attachments are limited to 1MB")
+		return
+	}
+	if err != nil {
+		respondJSONError(w, http.StatusBadRequest, "expected an image in the \"file\" field")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxAttachmentBytes+1))
+	if err != nil {
+		respondJSONError(w, http.StatusBadRequest, "couldn't read the upload")
+		return
+	}
+	if len(data) > maxAttachmentBytes {
+		respondJSONError(w, http.StatusRequestEntityTooLarge, "attachments are limited to 1MB")
+		return
+	}
+	contentType := http.DetectContentType(data)
+	ext, ok := attachmentTypes[contentType]
+	if contentType == "image/webp" {
+		respondJSONError(w, http.StatusUnsupportedMediaType, "WebP images can't be processed yet; upload a JPEG, PNG, or GIF instead")
+		return
+	}
+	if !ok {
+		respondJSONError(w, http.StatusUnsupportedMediaType, "only JPEG, PNG, and GIF images can be uploaded")
+		return
+	}
+	img, err := processImage(data, contentType)
+	if err != nil {
+		if errors.Is(err, errImageInvalid) || errors.Is(err, errImageTooLarge) || errors.Is(err, errAnimationTooLong) {
+			respondJSONError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		log.Errorf("Failed to process image: %v", err)
+		http.Error(w, "Failed to store attachment", http.StatusInternalServerError)
+		return
+	}
+	key, err := newAttachmentKey(ext)
+	if err != nil {
+		log.Errorf("Failed to generate attachment key: %v", err)
+		http.Error(w, "Failed to store attachment", http.StatusInternalServerError)
+		return
+	}
+	username, _ := getBearerUsername(r)
+	attachment := &Attachment{
+		Key:             key,
+		ContentType:     contentType,
+		Size:            len(img.Original),
+		Width:           img.Width,
+		Height:          img.Height,
+		ThumbnailKey:    strings.TrimSuffix(key, ext) + "s" + attachmentTypes[img.ThumbType],
+		ThumbnailWidth:  img.ThumbWidth,
+		ThumbnailHeight: img.ThumbHeight,
+		UploadedBy:      username,
+	}
+	if err := attachmentStore.Put(r.Context(), attachment.Key, bytes.NewReader(img.Original), contentType); err != nil {
+		log.Errorf("Failed to store attachment %s: %v", attachment.Key, err)
+		http.Error(w, "Failed to store attachment", http.StatusInternalServerError)
+		return
+	}
+	err = attachmentStore.Put(r.Context(), attachment.ThumbnailKey, bytes.NewReader(img.Thumb), img.ThumbType)
+	if err == nil {
+		err = createAttachment(r.Context(), db, attachment)
+	}
+	if err != nil {
+		log.Errorf("Failed to store attachment %s: %v", attachment.Key, err)
+		attachmentStore.Delete(r.Context(), attachment.Key)
+		attachmentStore.Delete(r.Context(), attachment.ThumbnailKey)
+		respondStoreError(w, err, "Failed to store attachment")
+		return
+	}
+	attachment.URL = attachmentStore.URL(attachment.Key)
+	attachment.ThumbnailURL = attachmentStore.URL(attachment.ThumbnailKey)
+	log.Infof("Stored attachment %s (%s, %d bytes, from %q) for %s", attachment.Key, contentType, attachment.Size, header.Filename, username)
+	respondJSON(w, r, attachment)
+}
+
+// serveAttachment streams /attachments/{key} from the configured store.
+func serveAttachment(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if attachmentStore == nil || !validAttachmentKey(key) {
+		http.NotFound(w, r)
+		return
+	}
+	blob, err := attachmentStore.Get(r.Context(), key)
+	if errors.Is(err, errAttachmentNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to load attachment %s: %v", key, err)
+		http.Error(w, "Failed to load attachment", http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+	for contentType, ext := range attachmentTypes {
+		if strings.HasSuffix(key, ext) {
+			w.Header().Set("Content-Type", contentType)
+		}
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	io.Copy(w, blob)
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
@@ -16,16 +17,25 @@ import (
 
 func getAuthViewData(r *http.Request) AuthViewData {
 	username, ok := getAuthenticatedUsername(r)
-	klaxon, err := getKlaxon(db)
+	klaxon, err := getKlaxon(r.Context(), db)
 	if err != nil {
 		log.Warnf("Failed to load klaxon: %v", err)
 	}
+	var warnings []*UserWarning
+	if ok {
+		warnings, err = getUnacknowledgedWarnings(r.Context(), db, username)
+		if err != nil {
+			log.Warnf("Failed to load warnings: %v", err)
+		}
+	}
 	return AuthViewData{
 		IsAuthenticated: ok,
 		Username:        username,
 		CurrentPath:     r.URL.RequestURI(),
 		IsModerator:     isModerator(username),
 		Klaxon:          klaxon,
+		CSPNonce:        cspNonce(r.Context()),
+		Warnings:        warnings,
 	}
 }
 
@@ -37,7 +47,7 @@ func isModerator(username string) bool {
 }
 
 func getAuthenticatedUsername(r *http.Request) (string, bool) {
-	cookie, err := r.Cookie(authCookieName)
+	cookie, err := r.Cookie(authCookie.Name)
 	if err != nil {
 		return "", false
 	}
@@ -58,7 +68,7 @@ func getAuthenticatedUsername(r *http.Request) (string, bool) {
 		return "", false
 	}
 
-	if !userExists(db, username) {
+	if !userActive(r.Context(), db, username) {
 		return "", false
 	}
 
@@ -74,23 +84,26 @@ func signAuthCookie(username string) string {
 func setAuthCookie(w http.ResponseWriter, r *http.Request, username string) {
 	value := fmt.Sprintf("%s|%s", username, signAuthCookie(username))
 	http.SetCookie(w, &http.Cookie{
-		Name:     authCookieName,
+		Name:     authCookie.Name,
 		Value:    value,
-		Path:     "/",
+		Domain:   authCookie.Domain,
+		Path:     authCookie.Path,
 		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-		Secure:   getenvTrim("JANK_SECURE_COOKIES") != "false",
+		SameSite: authCookie.sameSite(),
+		Secure:   authCookie.Secure,
 		MaxAge:   60 * 60 * 24 * 7,
 	})
 }
 
 func clearAuthCookie(w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
-		Name:     authCookieName,
+		Name:     authCookie.Name,
 		Value:    "",
-		Path:     "/",
+		Domain:   authCookie.Domain,
+		Path:     authCookie.Path,
 		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
+		SameSite: authCookie.sameSite(),
+		Secure:   authCookie.Secure,
 		MaxAge:   -1,
 	})
 }
@@ -105,6 +118,44 @@ func requireAuth(w http.ResponseWriter, r *http.Request) bool {
 	return false
 }
 
+// authReadExemptPaths stay public when requireAuthRead is on so visitors
+// can still sign in or sign up.
+var authReadExemptPaths = map[string]bool{
+	"/login":                     true,
+	"/signup":                    true,
+	"/logout":                    true,
+	"/favicon.ico":               true,
+	"/favicon.svg":               true,
+	"/auth/token":                true,
+	"/auth/signup":               true,
+	apiV1Prefix + "/auth/token":  true,
+	apiV1Prefix + "/auth/signup": true,
+}
+
+// authReadMiddleware makes the whole site private while requireAuthRead is
+// on: anonymous page views redirect to login and API calls get a 401.
+func authReadMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requireAuthRead || r.Method == http.MethodOptions || authReadExemptPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := getAuthenticatedUsername(r); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := getBearerUsername(r); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if isAPIPath(r.URL.Path) || r.Header.Get("Authorization") != "" {
+			respondJSONError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		requireAuth(w, r)
+	})
+}
+
 func requireAPIAuth(w http.ResponseWriter, r *http.Request) bool {
 	if _, ok := getBearerUsername(r); ok {
 		return true
@@ -125,6 +176,158 @@ func requireAPIModerator(w http.ResponseWriter, r *http.Request) bool {
 	return true
 }
 
+// requestUsername returns the caller from a bearer token or the auth cookie,
+// or "" for anonymous requests.
+func requestUsername(r *http.Request) string {
+	if username, ok := getBearerUsername(r); ok {
+		return username
+	}
+	username, _ := getAuthenticatedUsername(r)
+	return username
+}
+
+// canViewBoard reports whether username may read and post on board.
+// Moderators can always see restricted boards.
+func canViewBoard(ctx context.Context, board *Board, username string) (bool, error) {
+	if board.Visibility != boardVisibilityRestricted || isModerator(username) {
+		return true, nil
+	}
+	if username == "" {
+		return false, nil
+	}
+	return isBoardMember(ctx, db, board.ID, username)
+}
+
+// hiddenBoardIDs returns the restricted boards username can't see, for
+// filtering listings that span boards.
+func hiddenBoardIDs(ctx context.Context, username string) (map[int]bool, error) {
+	if isModerator(username) {
+		return map[int]bool{}, nil
+	}
+	return getHiddenBoardIDs(ctx, db, username)
+}
+
+// Content kinds for not-found responses. A members-only board, and
+// everything on it, answers outsiders exactly as if it didn't exist, so its
+// existence can't be probed for. 403 is kept for actions on content the
+// caller can already see, such as moderator-only tools.
+const (
+	contentBoard  = "board"
+	contentThread = "thread"
+	contentPost   = "post"
+	contentTree   = "tree"
+)
+
+// renderNotFoundPage renders the standard 404 page for a missing board,
+// thread, post, or card tree.
+func renderNotFoundPage(w http.ResponseWriter, r *http.Request, kind string) {
+	switch kind {
+	case contentThread:
+		renderErrorPage(w, r, http.StatusNotFound, "Thread Not Found", "We couldn't find that thread.", "/")
+	case contentPost:
+		renderErrorPage(w, r, http.StatusNotFound, "Post Not Found", "We couldn't find that post.", "/")
+	case contentTree:
+		renderErrorPage(w, r, http.StatusNotFound, "Tree Not Found", "We couldn't find that card tree.", "/")
+	default:
+		renderErrorPage(w, r, http.StatusNotFound, "Board Not Found", "We couldn't find that board.", "/")
+	}
+}
+
+// respondNotFound is renderNotFoundPage for the REST API.
+func respondNotFound(w http.ResponseWriter, kind string) {
+	switch kind {
+	case contentThread:
+		http.Error(w, "Thread not found", http.StatusNotFound)
+	case contentPost:
+		http.Error(w, "Post not found", http.StatusNotFound)
+	case contentTree:
+		http.Error(w, "Tree not found", http.StatusNotFound)
+	default:
+		http.Error(w, "Board not found", http.StatusNotFound)
+	}
+}
+
+// contentBoardOf loads the board a board, thread, post, or card tree ID
+// belongs to, for access checks on routes that only carry the ID.
+func contentBoardOf(ctx context.Context, kind string, id int) (*Board, error) {
+	switch kind {
+	case contentThread:
+		return getThreadBoard(ctx, db, id)
+	case contentPost:
+		return getPostBoard(ctx, db, id)
+	case contentTree:
+		return getCardTreeBoard(ctx, db, id)
+	default:
+		return getBoardByID(ctx, db, id, false)
+	}
+}
+
+// requireContentAccess is requireBoardAccess for a route that names a
+// board, thread, post, or card tree by ID. Missing content gets the same
+// not-found page as content the viewer can't see.
+func requireContentAccess(w http.ResponseWriter, r *http.Request, kind string, id int) bool {
+	board, err := contentBoardOf(r.Context(), kind, id)
+	if err != nil {
+		renderNotFoundPage(w, r, kind)
+		return false
+	}
+	return requireBoardAccess(w, r, board, kind)
+}
+
+// requireAPIContentAccess is requireContentAccess for the REST API.
+func requireAPIContentAccess(w http.ResponseWriter, r *http.Request, kind string, id int) bool {
+	board, err := contentBoardOf(r.Context(), kind, id)
+	if err != nil {
+		respondNotFound(w, kind)
+		return false
+	}
+	return requireAPIBoardAccess(w, r, board, kind)
+}
+
+// requireBoardAccess renders the missing-board (or missing-thread) page for
+// members-only boards the viewer isn't on, signed in or not.
+func requireBoardAccess(w http.ResponseWriter, r *http.Request, board *Board, kind string) bool {
+	ok, err := canViewBoard(r.Context(), board, requestUsername(r))
+	if err != nil {
+		log.Errorf("Failed to check board access: %v", err)
+		renderStoreErrorPage(w, r, err, "Board Unavailable", "We couldn't check access to that board.", "/")
+		return false
+	}
+	if !ok {
+		renderNotFoundPage(w, r, kind)
+	}
+	return ok
+}
+
+// requireAPIBoardAccess is requireBoardAccess for the REST API.
+func requireAPIBoardAccess(w http.ResponseWriter, r *http.Request, board *Board, kind string) bool {
+	ok, err := canViewBoard(r.Context(), board, requestUsername(r))
+	if err != nil {
+		log.Errorf("Failed to check board access: %v", err)
+		respondStoreError(w, err, "Failed to check board access")
+		return false
+	}
+	if !ok {
+		respondNotFound(w, kind)
+	}
+	return ok
+}
+
+// requireJSONBoardAccess is requireAPIBoardAccess for endpoints that answer
+// errors as JSON objects.
+func requireJSONBoardAccess(w http.ResponseWriter, r *http.Request, board *Board, kind string) bool {
+	ok, err := canViewBoard(r.Context(), board, requestUsername(r))
+	if err != nil {
+		log.Errorf("Failed to check board access: %v", err)
+		respondStoreError(w, err, "Failed to check board access")
+		return false
+	}
+	if !ok {
+		respondJSONError(w, http.StatusNotFound, kind+" not found")
+	}
+	return ok
+}
+
 func getBearerUsername(r *http.Request) (string, bool) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
@@ -134,7 +337,7 @@ func getBearerUsername(r *http.Request) (string, bool) {
 	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
 		return "", false
 	}
-	return verifyJWT(parts[1])
+	return verifyJWT(r.Context(), parts[1])
 }
 
 func issueJWT(username string, ttl time.Duration) (string, time.Time, error) {
@@ -160,7 +363,7 @@ func issueJWT(username string, ttl time.Duration) (string, time.Time, error) {
 	return token, time.Unix(exp, 0), nil
 }
 
-func verifyJWT(token string) (string, bool) {
+func verifyJWT(ctx context.Context, token string) (string, bool) {
 	parts := strings.Split(token, ".")
 	if len(parts) != 3 {
 		return "", false
@@ -192,7 +395,7 @@ func verifyJWT(token string) (string, bool) {
 	if time.Now().Unix() > payload.Exp {
 		return "", false
 	}
-	if !userExists(db, payload.Sub) {
+	if !userActive(ctx, db, payload.Sub) {
 		return "", false
 	}
 	return payload.Sub, true
@@ -0,0 +1,182 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// normalizeBoardVisibility maps unknown or empty settings to public.
+func normalizeBoardVisibility(visibility string) string {
+	if strings.ToLower(strings.TrimSpace(visibility)) == boardVisibilityRestricted {
+		return boardVisibilityRestricted
+	}
+	return boardVisibilityPublic
+}
+
+// setBoardAccess stores a board's visibility and replaces its member list.
+// Cached feeds are dropped so a newly restricted board leaves them at once.
+func setBoardAccess(ctx context.Context, db *sql.DB, boardID int, visibility string, members []string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE boards SET visibility = $1 WHERE id = $2`, normalizeBoardVisibility(visibility), boardID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("board not found")
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM board_members WHERE board_id = $1`, boardID); err != nil {
+		return err
+	}
+	for _, username := range members {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO board_members (board_id, username) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+			boardID, username); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	resetFeedCache()
+	return nil
+}
+
+// getBoardMembers lists the usernames allowed on a restricted board.
+func getBoardMembers(ctx context.Context, db *sql.DB, boardID int) ([]string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `SELECT username FROM board_members WHERE board_id = $1 ORDER BY username`, boardID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []string
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		members = append(members, username)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// isBoardMember reports whether username is on a board's member list.
+func isBoardMember(ctx context.Context, db *sql.DB, boardID int, username string) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	var count int
+	err := db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM board_members WHERE board_id = $1 AND username = $2`,
+		boardID, username).Scan(&count)
+	return count > 0, err
+}
+
+// getHiddenBoardIDs returns the restricted boards username isn't a member of.
+// An empty username gets every restricted board.
+func getHiddenBoardIDs(ctx context.Context, db *sql.DB, username string) (map[int]bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `
+		SELECT id FROM boards
+		WHERE visibility = $1
+		AND id NOT IN (SELECT board_id FROM board_members WHERE username = $2)`,
+		boardVisibilityRestricted, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hidden := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		hidden[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return hidden, nil
+}
+
+// visibleBoardClause returns a condition keeping only rows whose
+// boardColumn is a board viewer can see, and the arguments it binds, which
+// are numbered from $next. It's the SQL form of hiddenBoardIDs, for
+// listings that would otherwise page or count hidden rows. SQLite numbers $N
+// parameters by first appearance, so nothing after the clause may use a
+// lower number.
+func visibleBoardClause(boardColumn, viewer string, next int) (string, []interface{}) {
+	if isModerator(viewer) {
+		return "1 = 1", nil
+	}
+	return fmt.Sprintf(`%s NOT IN (
+			SELECT id FROM boards
+			WHERE visibility = $%d
+			AND id NOT IN (SELECT board_id FROM board_members WHERE username = $%d)
+		)`, boardColumn, next, next+1), []interface{}{boardVisibilityRestricted, viewer}
+}
+
+// getThreadBoard loads the board a thread was posted on.
+func getThreadBoard(ctx context.Context, db *sql.DB, threadID int) (*Board, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	var boardID int
+	err := db.QueryRowContext(ctx, `SELECT board_id FROM threads WHERE id = $1`, threadID).Scan(&boardID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("thread not found")
+	} else if err != nil {
+		return nil, err
+	}
+	return getBoardByID(ctx, db, boardID, false)
+}
+
+// getPostBoard loads the board a post's thread is on.
+func getPostBoard(ctx context.Context, db *sql.DB, postID int) (*Board, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	var boardID int
+	err := db.QueryRowContext(ctx, `
+		SELECT t.board_id FROM posts p
+		JOIN threads t ON t.id = p.thread_id
+		WHERE p.id = $1`, postID).Scan(&boardID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("post not found")
+	} else if err != nil {
+		return nil, err
+	}
+	return getBoardByID(ctx, db, boardID, false)
+}
+
+// getCardTreeBoard loads the board a card tree hangs off, directly or
+// through its thread or post.
+func getCardTreeBoard(ctx context.Context, db *sql.DB, treeID int) (*Board, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	var boardID sql.NullInt64
+	err := db.QueryRowContext(ctx, `SELECT `+cardTreeBoardID+` FROM card_trees t WHERE t.id = $1`, treeID).Scan(&boardID)
+	if err == sql.ErrNoRows || (err == nil && !boardID.Valid) {
+		return nil, fmt.Errorf("tree not found")
+	} else if err != nil {
+		return nil, err
+	}
+	return getBoardByID(ctx, db, int(boardID.Int64), false)
+}
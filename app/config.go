@@ -3,10 +3,20 @@ package app
 import (
 	"crypto/rand"
 	"database/sql"
-	"embed"
+	"encoding/json"
 	"fmt"
 	"html/template"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 // AuthConfig holds credentials and signing secret for auth cookies.
@@ -17,9 +27,393 @@ type AuthConfig struct {
 	JWTSecret []byte
 }
 
-const authCookieName = "jank_auth"
+// TreeLimits caps how much card tree data a single submission may create.
+type TreeLimits struct {
+	MaxTrees            int
+	MaxNodesPerTree     int
+	MaxAnnotationLength int
+}
+
+// PageConfig sets the default page sizes of paginated listings and the
+// largest page any request may ask for.
+type PageConfig struct {
+	Threads int
+	Posts   int
+	Max     int
+}
+
+// SeedConfig controls whether startup seeding runs and which boards it creates.
+type SeedConfig struct {
+	Enabled bool
+	Boards  []seedBoard
+}
+
+type seedBoard struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// CookieConfig names and scopes the auth cookie, so instances on sibling
+// subdomains don't overwrite each other's logins.
+type CookieConfig struct {
+	Name   string
+	Domain string
+	Path   string
+	// Secure is set regardless of whether the request arrived over TLS,
+	// since a TLS-terminating proxy hides that from the server.
+	Secure bool
+	// SameSite is "Lax" or "Strict".
+	SameSite string
+}
+
+// Config is every setting read from the environment at startup. LoadConfig
+// fills it in and apply hands it to the rest of the package. Network lists
+// still read their own variables, since they reload on SIGHUP.
+type Config struct {
+	Production   bool
+	LogLevel     logrus.Level
+	LogFormat    string
+	DBDriver     string
+	DBDSN        string
+	QueryTimeout time.Duration
+	SQLite       SQLiteConfig
+
+	Auth   AuthConfig
+	Cookie CookieConfig
+	Origin OriginConfig
+	Images ImageConfig
+	Seed   SeedConfig
+
+	Attachments AttachmentConfig
+
+	TreeLimits       TreeLimits
+	Pages            PageConfig
+	ReportCategories []string
+
+	ShowPostEmail      bool
+	PostVotes          bool
+	MaxThreadsShown    int
+	TrendingWindow     time.Duration
+	RequireAuthRead    bool
+	PostCooldown       time.Duration
+	BumpCooldown       time.Duration
+	NecroThreshold     time.Duration
+	Retention          time.Duration
+	RetentionInterval  time.Duration
+	RecordPostIPs      bool
+	TrustedProxies     []*net.IPNet
+	MinPasswordLength  int
+	CommonPasswords    bool
+	PrettyJSON         bool
+	MaxBoards          int
+	CollapsePostLength int
+	RenderCacheSize    int
+	StoreRendered      bool
+	ThumbnailSize      int
+	MaxAnimationFrames int
+
+	WebhookURLs           []string
+	WebhookSecret         string
+	ContentSecurityPolicy string
+	ReadOnly              bool
+
+	Addr            string
+	BaseURL         string
+	ShutdownTimeout time.Duration
+	// listenURL is Addr as a URL, for the startup log.
+	listenURL string
+
+	// Problems lists values that were invalid and replaced by defaults.
+	Problems []error
+}
+
+// LoadConfig reads and validates the JANK_* environment. Invalid values fall
+// back to their defaults and are recorded in Problems; only settings the
+// server can't start without (the database, a production password) return
+// an error.
+func LoadConfig() (*Config, error) {
+	var l configLoader
+	cfg := &Config{Production: isProduction()}
+	cfg.LogLevel, cfg.LogFormat = loadLogConfig(&l)
+
+	var err error
+	if cfg.DBDriver, cfg.DBDSN, err = loadDatabaseConfig(); err != nil {
+		return nil, err
+	}
+	if cfg.Auth, err = loadAuthConfig(); err != nil {
+		return nil, err
+	}
+	if cfg.Seed, err = loadSeedConfig(&l); err != nil {
+		l.addf("%v; skipping seed data", err)
+		cfg.Seed.Enabled = false
+	}
+
+	cfg.QueryTimeout = l.duration("JANK_DB_QUERY_TIMEOUT", 5*time.Second)
+	cfg.SQLite = loadSQLiteConfig(&l)
+	cfg.Cookie = loadCookieConfig(&l)
+	cfg.Origin = loadOriginConfig(&l)
+	cfg.Images = loadImageConfig(&l)
+	cfg.Attachments = loadAttachmentConfig(&l)
+	cfg.TreeLimits = loadTreeLimits(&l)
+	cfg.Pages = loadPageConfig(&l)
+	cfg.ReportCategories = loadReportCategories(&l)
+
+	cfg.ShowPostEmail = l.bool("JANK_SHOW_POST_EMAIL", false)
+	cfg.PostVotes = l.bool("JANK_POST_VOTES", true)
+	cfg.MaxThreadsShown = l.int("JANK_MAX_THREADS_SHOWN", 50)
+	cfg.TrendingWindow = l.duration("JANK_TRENDING_WINDOW", 24*time.Hour)
+	if cfg.TrendingWindow == 0 {
+		l.invalid("JANK_TRENDING_WINDOW", getenvTrim("JANK_TRENDING_WINDOW"), 24*time.Hour)
+		cfg.TrendingWindow = 24 * time.Hour
+	}
+	cfg.RequireAuthRead = l.bool("JANK_REQUIRE_AUTH_READ", false)
+	cfg.PostCooldown = l.duration("JANK_POST_COOLDOWN", defaultPostCooldown)
+	cfg.BumpCooldown = l.duration("JANK_BUMP_COOLDOWN", 3*time.Minute)
+	cfg.NecroThreshold = l.duration("JANK_NECRO_THRESHOLD", 30*24*time.Hour)
+	cfg.Retention = l.duration("JANK_RETENTION", 0)
+	cfg.RetentionInterval = l.duration("JANK_RETENTION_INTERVAL", time.Hour)
+	cfg.RecordPostIPs = l.bool("JANK_RECORD_POST_IPS", true)
+	cfg.TrustedProxies = loadTrustedProxies(&l)
+	cfg.MinPasswordLength = l.int("JANK_MIN_PASSWORD_LENGTH", 8)
+	if cfg.MinPasswordLength > maxPasswordLength {
+		l.invalid("JANK_MIN_PASSWORD_LENGTH", strconv.Itoa(cfg.MinPasswordLength), 8)
+		cfg.MinPasswordLength = 8
+	}
+	cfg.CommonPasswords = l.bool("JANK_REJECT_COMMON_PASSWORDS", true)
+	cfg.PrettyJSON = l.bool("JANK_JSON_PRETTY", false)
+	cfg.MaxBoards = l.int("JANK_MAX_BOARDS", 0)
+	cfg.CollapsePostLength = l.int("JANK_COLLAPSE_POST_LENGTH", 2000)
+	cfg.RenderCacheSize = l.int("JANK_RENDER_CACHE_SIZE", defaultRenderCacheSize)
+	cfg.StoreRendered = l.bool("JANK_STORE_RENDERED_POSTS", true)
+	cfg.ThumbnailSize = l.int("JANK_THUMBNAIL_SIZE", 250)
+	cfg.MaxAnimationFrames = l.int("JANK_MAX_ANIMATION_FRAMES", 100)
+
+	cfg.WebhookURLs = parseWebhookURLs(getenvTrim("JANK_WEBHOOK_URLS"))
+	cfg.WebhookSecret = getenvTrim("JANK_WEBHOOK_SECRET")
+	if len(cfg.WebhookURLs) > 0 && cfg.WebhookSecret == "" {
+		l.addf("JANK_WEBHOOK_SECRET is not set; webhooks will be sent unsigned")
+	}
+	cfg.ContentSecurityPolicy = getenvTrim("JANK_CSP")
+	if cfg.ContentSecurityPolicy == "" {
+		cfg.ContentSecurityPolicy = defaultContentSecurityPolicy
+	}
+	cfg.ReadOnly = l.bool("JANK_READONLY", false)
+
+	cfg.Addr, cfg.listenURL = serverAddr(&l)
+	cfg.BaseURL = loadBaseURL(&l, cfg.listenURL)
+	cfg.ShutdownTimeout = l.duration("JANK_SHUTDOWN_TIMEOUT", 10*time.Second)
+
+	cfg.Problems = l.problems
+	return cfg, nil
+}
+
+// apply copies cfg into the package settings the handlers read.
+func (cfg *Config) apply() {
+	auth = cfg.Auth
+	authCookie = cfg.Cookie
+	originPolicy = cfg.Origin
+	markdownImages = cfg.Images
+	queryTimeout = cfg.QueryTimeout
+	treeLimits = cfg.TreeLimits
+	pageConfig = cfg.Pages
+	reportCategories = cfg.ReportCategories
+	showPostEmail = cfg.ShowPostEmail
+	postVotesEnabled = cfg.PostVotes
+	maxThreadsShown = cfg.MaxThreadsShown
+	trendingWindow = cfg.TrendingWindow
+	requireAuthRead = cfg.RequireAuthRead
+	postCooldown = cfg.PostCooldown
+	bumpCooldown = cfg.BumpCooldown
+	necroThreshold = cfg.NecroThreshold
+	retentionAge = cfg.Retention
+	recordPostIPs = cfg.RecordPostIPs
+	trustedProxies = cfg.TrustedProxies
+	minPasswordLength = cfg.MinPasswordLength
+	rejectCommonPasswords = cfg.CommonPasswords
+	prettyJSON = cfg.PrettyJSON
+	maxBoards = cfg.MaxBoards
+	collapsePostLength = cfg.CollapsePostLength
+	postRenderCache = newRenderCache(cfg.RenderCacheSize)
+	storeRenderedPosts = cfg.StoreRendered
+	thumbnailSize = cfg.ThumbnailSize
+	maxAnimationFrames = cfg.MaxAnimationFrames
+	webhookURLs = cfg.WebhookURLs
+	webhookSecret = cfg.WebhookSecret
+	contentSecurityPolicy = cfg.ContentSecurityPolicy
+	readOnlyMode.Store(cfg.ReadOnly)
+	baseURL = cfg.BaseURL
+}
+
+// logFields is the effective configuration for the startup log, with
+// passwords and secrets redacted.
+func (cfg *Config) logFields() logrus.Fields {
+	return logrus.Fields{
+		"production":           cfg.Production,
+		"log_level":            cfg.LogLevel.String(),
+		"log_format":           cfg.LogFormat,
+		"db_driver":            cfg.DBDriver,
+		"db_dsn":               redactDSN(cfg.DBDSN),
+		"db_query_timeout":     cfg.QueryTimeout.String(),
+		"sqlite":               cfg.SQLite,
+		"forum_user":           cfg.Auth.Username,
+		"forum_pass":           redacted(cfg.Auth.Password),
+		"forum_secret":         redacted(string(cfg.Auth.Secret)),
+		"jwt_secret":           redacted(string(cfg.Auth.JWTSecret)),
+		"cookie":               cfg.Cookie,
+		"origin":               cfg.Origin,
+		"images":               cfg.Images,
+		"seed":                 cfg.Seed.Enabled,
+		"attachment_store":     cfg.Attachments.Store,
+		"attachment_dir":       cfg.Attachments.Dir,
+		"s3_bucket":            cfg.Attachments.S3.Bucket,
+		"s3_region":            cfg.Attachments.S3.Region,
+		"s3_endpoint":          cfg.Attachments.S3.Endpoint,
+		"s3_public_url":        cfg.Attachments.S3.PublicURL,
+		"s3_access_key_id":     cfg.Attachments.S3.AccessKey,
+		"s3_secret_access_key": redacted(cfg.Attachments.S3.SecretKey),
+		"tree_limits":          cfg.TreeLimits,
+		"pages":                cfg.Pages,
+		"report_categories":    cfg.ReportCategories,
+		"show_post_email":      cfg.ShowPostEmail,
+		"post_votes":           cfg.PostVotes,
+		"max_threads_shown":    cfg.MaxThreadsShown,
+		"trending_window":      cfg.TrendingWindow.String(),
+		"require_auth_read":    cfg.RequireAuthRead,
+		"post_cooldown":        cfg.PostCooldown.String(),
+		"bump_cooldown":        cfg.BumpCooldown.String(),
+		"necro_threshold":      cfg.NecroThreshold.String(),
+		"retention":            cfg.Retention.String(),
+		"retention_interval":   cfg.RetentionInterval.String(),
+		"record_post_ips":      cfg.RecordPostIPs,
+		"trusted_proxies":      len(cfg.TrustedProxies),
+		"min_password_length":  cfg.MinPasswordLength,
+		"common_passwords":     cfg.CommonPasswords,
+		"json_pretty":          cfg.PrettyJSON,
+		"max_boards":           cfg.MaxBoards,
+		"collapse_post_length": cfg.CollapsePostLength,
+		"render_cache_size":    cfg.RenderCacheSize,
+		"store_rendered_posts": cfg.StoreRendered,
+		"thumbnail_size":       cfg.ThumbnailSize,
+		"max_animation_frames": cfg.MaxAnimationFrames,
+		"webhook_urls":         len(cfg.WebhookURLs),
+		"webhook_secret":       redacted(cfg.WebhookSecret),
+		"custom_csp":           cfg.ContentSecurityPolicy != defaultContentSecurityPolicy,
+		"readonly":             cfg.ReadOnly,
+		"addr":                 cfg.Addr,
+		"base_url":             cfg.BaseURL,
+		"shutdown_timeout":     cfg.ShutdownTimeout.String(),
+	}
+}
+
+// redacted hides a secret, saying only whether one is set.
+func redacted(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "xxxxx"
+}
+
+var dsnPasswordPattern = regexp.MustCompile(`(?i)(password=)\S+`)
+
+// redactDSN hides the password in a URL or key=value connection string.
+func redactDSN(dsn string) string {
+	if parsed, err := url.Parse(dsn); err == nil && parsed.User != nil {
+		return parsed.Redacted()
+	}
+	return dsnPasswordPattern.ReplaceAllString(dsn, "${1}xxxxx")
+}
+
+func defaultTreeLimits() TreeLimits {
+	return TreeLimits{
+		MaxTrees:            5,
+		MaxNodesPerTree:     200,
+		MaxAnnotationLength: 2000,
+	}
+}
+
+// openDatabase connects to the database LoadConfig resolved. SQLite
+// connections get sqlite's pragmas through the DSN, so every pooled
+// connection has them, not just the first.
+func openDatabase(driver, dsn string, sqlite SQLiteConfig) (*sql.DB, error) {
+	open := dsn
+	if driver == "sqlite3" {
+		open = sqlite.dsn(dsn)
+	}
+	db, err := sql.Open(driver, open)
+	if err != nil {
+		return nil, err
+	}
+
+	dbDriver = driver
+	dbDSN = dsn
+	return db, nil
+}
+
+// ------------------- SQLite Config -------------------
+
+// SQLiteConfig holds the pragmas set on every SQLite connection. WAL and a
+// busy timeout let readers carry on during writes and make writers wait
+// for each other instead of failing with "database is locked".
+type SQLiteConfig struct {
+	JournalMode string
+	Synchronous string
+	BusyTimeout time.Duration
+	// CacheSizeKB is the page cache per connection, in KiB.
+	CacheSizeKB int
+}
+
+func defaultSQLiteConfig() SQLiteConfig {
+	return SQLiteConfig{JournalMode: "WAL", Synchronous: "NORMAL", BusyTimeout: 5 * time.Second, CacheSizeKB: 20000}
+}
+
+var (
+	sqliteJournalModes = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+	sqliteSyncModes    = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+)
+
+// loadSQLiteConfig reads JANK_SQLITE_JOURNAL_MODE, JANK_SQLITE_SYNCHRONOUS,
+// JANK_SQLITE_BUSY_TIMEOUT, and JANK_SQLITE_CACHE_SIZE (KiB).
+func loadSQLiteConfig(l *configLoader) SQLiteConfig {
+	cfg := defaultSQLiteConfig()
+	cfg.JournalMode = l.choice("JANK_SQLITE_JOURNAL_MODE", cfg.JournalMode, sqliteJournalModes)
+	cfg.Synchronous = l.choice("JANK_SQLITE_SYNCHRONOUS", cfg.Synchronous, sqliteSyncModes)
+	cfg.BusyTimeout = l.duration("JANK_SQLITE_BUSY_TIMEOUT", cfg.BusyTimeout)
+	cfg.CacheSizeKB = l.int("JANK_SQLITE_CACHE_SIZE", cfg.CacheSizeKB)
+	return cfg
+}
+
+// dsn adds c's pragmas, foreign key enforcement, and immediate transactions
+// to a go-sqlite3 DSN as connection parameters. Ones the DSN already sets,
+// under either of the driver's names for them, are left as they are.
+func (c SQLiteConfig) dsn(base string) string {
+	path, rawQuery, _ := strings.Cut(base, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return base
+	}
+	set := func(value string, keys ...string) {
+		for _, key := range keys {
+			if query.Has(key) {
+				return
+			}
+		}
+		query.Set(keys[0], value)
+	}
+	set("1", "_foreign_keys", "_fk")
+	set(c.JournalMode, "_journal_mode", "_journal")
+	set(c.Synchronous, "_synchronous", "_sync")
+	set(strconv.FormatInt(c.BusyTimeout.Milliseconds(), 10), "_busy_timeout", "_timeout")
+	set(strconv.Itoa(-c.CacheSizeKB), "_cache_size")
+	// A deferred transaction that reads before it writes can't wait out the
+	// busy timeout when another writer got there first; SQLite fails the
+	// upgrade at once. Taking the write lock at BEGIN queues it instead.
+	set("immediate", "_txlock")
+	return path + "?" + query.Encode()
+}
 
-func openDatabase() (*sql.DB, error) {
+// loadDatabaseConfig resolves JANK_DB_DRIVER (default postgres) and
+// JANK_DB_DSN / DATABASE_URL to a database/sql driver name and DSN.
+func loadDatabaseConfig() (string, string, error) {
 	driver := strings.ToLower(getenvTrim("JANK_DB_DRIVER"))
 	dsn := firstEnv("JANK_DB_DSN", "DATABASE_URL")
 
@@ -29,43 +423,87 @@ func openDatabase() (*sql.DB, error) {
 
 	switch driver {
 	case "postgres", "postgresql", "pgx":
-		driver = "pgx"
 		if dsn == "" {
-			return nil, fmt.Errorf("postgres selected; set JANK_DB_DSN or DATABASE_URL")
+			return "", "", fmt.Errorf("postgres selected; set JANK_DB_DSN or DATABASE_URL")
 		}
+		return "pgx", dsn, nil
 	case "sqlite", "sqlite3":
-		driver = "sqlite3"
 		if dsn == "" {
 			dsn = "./sqlite.db"
 			log.Warn("JANK_DB_DSN not set; defaulting to ./sqlite.db")
 		}
+		return "sqlite3", dsn, nil
 	default:
-		return nil, fmt.Errorf("unsupported JANK_DB_DRIVER %q", driver)
+		return "", "", fmt.Errorf("unsupported JANK_DB_DRIVER %q", driver)
 	}
+}
 
-	db, err := sql.Open(driver, dsn)
-	if err != nil {
-		return nil, err
+func parseTemplates(fsys fs.FS) (*template.Template, error) {
+	funcs := template.FuncMap{
+		"markdown":    renderMarkdown,
+		"postContent": renderPostContent,
+		"excerpt":     makeExcerpt,
+		"boardURL":    boardURL,
+		"threadURL":   threadURL,
 	}
+	return template.New("base").Funcs(funcs).ParseFS(fsys, "templates/*.html")
+}
 
-	if driver == "sqlite3" {
-		_, _ = db.Exec("PRAGMA foreign_keys = ON")
-	}
+// ------------------- Auth Config -------------------
 
-	dbDriver = driver
-	return db, nil
+func defaultCookieConfig() CookieConfig {
+	return CookieConfig{Name: "jank_auth", Path: "/", Secure: true, SameSite: "Lax"}
 }
 
-func parseTemplates(fs embed.FS) (*template.Template, error) {
-	funcs := template.FuncMap{
-		"markdown": renderMarkdown,
+// loadCookieConfig reads JANK_COOKIE_NAME, JANK_COOKIE_DOMAIN,
+// JANK_COOKIE_PATH, JANK_COOKIE_SECURE (or the older JANK_SECURE_COOKIES),
+// and JANK_COOKIE_SAMESITE. Each value that wouldn't make a valid cookie
+// falls back to its default.
+func loadCookieConfig(l *configLoader) CookieConfig {
+	cfg := defaultCookieConfig()
+	if raw := getenvTrim("JANK_COOKIE_NAME"); raw != "" {
+		if (&http.Cookie{Name: raw}).Valid() == nil {
+			cfg.Name = raw
+		} else {
+			l.invalid("JANK_COOKIE_NAME", raw, cfg.Name)
+		}
 	}
-	return template.New("base").Funcs(funcs).ParseFS(fs, "templates/*.html")
+	if raw := strings.TrimPrefix(getenvTrim("JANK_COOKIE_DOMAIN"), "."); raw != "" {
+		if (&http.Cookie{Name: cfg.Name, Domain: raw}).Valid() == nil {
+			cfg.Domain = raw
+		} else {
+			l.invalid("JANK_COOKIE_DOMAIN", raw, "the request host")
+		}
+	}
+	if raw := getenvTrim("JANK_COOKIE_PATH"); raw != "" {
+		if strings.HasPrefix(raw, "/") && (&http.Cookie{Name: cfg.Name, Path: raw}).Valid() == nil {
+			cfg.Path = raw
+		} else {
+			l.invalid("JANK_COOKIE_PATH", raw, cfg.Path)
+		}
+	}
+	cfg.Secure = l.bool("JANK_COOKIE_SECURE", l.bool("JANK_SECURE_COOKIES", cfg.Secure))
+	switch raw := strings.ToLower(getenvTrim("JANK_COOKIE_SAMESITE")); raw {
+	case "", "lax":
+	case "strict":
+		cfg.SameSite = "Strict"
+	default:
+		l.invalid("JANK_COOKIE_SAMESITE", raw, cfg.SameSite)
+	}
+	return cfg
 }
 
-// ------------------- Auth Config -------------------
+// sameSite is the http.SameSite mode for c.SameSite.
+func (c CookieConfig) sameSite() http.SameSite {
+	if c.SameSite == "Strict" {
+		return http.SameSiteStrictMode
+	}
+	return http.SameSiteLaxMode
+}
 
-func loadAuthConfig() AuthConfig {
+// loadAuthConfig reads forum credentials and secrets from the environment.
+// In production it refuses to fall back to the default admin password.
+func loadAuthConfig() (AuthConfig, error) {
 	username := getenvTrim("JANK_FORUM_USER")
 	password := getenvTrim("JANK_FORUM_PASS")
 	secret := getenvTrim("JANK_FORUM_SECRET")
@@ -76,6 +514,9 @@ func loadAuthConfig() AuthConfig {
 		log.Warn("JANK_FORUM_USER not set; defaulting to 'admin'")
 	}
 	if password == "" {
+		if isProduction() {
+			return AuthConfig{}, fmt.Errorf("JANK_FORUM_PASS must be set when JANK_ENV=production")
+		}
 		password = "admin"
 		log.Warn("JANK_FORUM_PASS not set; defaulting to 'admin'")
 	}
@@ -100,7 +541,7 @@ func loadAuthConfig() AuthConfig {
 		} else {
 			config.JWTSecret = []byte(jwtSecret)
 		}
-		return config
+		return config, nil
 	}
 
 	if jwtSecret == "" {
@@ -113,5 +554,145 @@ func loadAuthConfig() AuthConfig {
 		Password:  password,
 		Secret:    []byte(secret),
 		JWTSecret: []byte(jwtSecret),
+	}, nil
+}
+
+// ------------------- Tree Limits -------------------
+
+func loadTreeLimits(l *configLoader) TreeLimits {
+	defaults := defaultTreeLimits()
+	return TreeLimits{
+		MaxTrees:            l.int("JANK_TREE_MAX_TREES", defaults.MaxTrees),
+		MaxNodesPerTree:     l.int("JANK_TREE_MAX_NODES", defaults.MaxNodesPerTree),
+		MaxAnnotationLength: l.int("JANK_TREE_MAX_ANNOTATION_LENGTH", defaults.MaxAnnotationLength),
+	}
+}
+
+// ------------------- Pagination -------------------
+
+func defaultPageConfig() PageConfig {
+	return PageConfig{
+		Threads: 100,
+		Posts:   100,
+		Max:     500,
+	}
+}
+
+// loadPageConfig reads JANK_PAGE_SIZE_THREADS (board and thread listings),
+// JANK_PAGE_SIZE_POSTS (report and post listings), and JANK_MAX_PAGE_SIZE.
+// Default sizes above the max are lowered to it.
+func loadPageConfig(l *configLoader) PageConfig {
+	defaults := defaultPageConfig()
+	config := PageConfig{
+		Threads: l.int("JANK_PAGE_SIZE_THREADS", defaults.Threads),
+		Posts:   l.int("JANK_PAGE_SIZE_POSTS", defaults.Posts),
+		Max:     l.int("JANK_MAX_PAGE_SIZE", defaults.Max),
+	}
+	if config.Threads > config.Max {
+		l.addf("JANK_PAGE_SIZE_THREADS %d exceeds JANK_MAX_PAGE_SIZE; using %d", config.Threads, config.Max)
+		config.Threads = config.Max
+	}
+	if config.Posts > config.Max {
+		l.addf("JANK_PAGE_SIZE_POSTS %d exceeds JANK_MAX_PAGE_SIZE; using %d", config.Posts, config.Max)
+		config.Posts = config.Max
+	}
+	return config
+}
+
+// ------------------- Report Categories -------------------
+
+func defaultReportCategories() []string {
+	return []string{"spam", "harassment", "illegal", "off-topic", "other"}
+}
+
+// loadReportCategories reads JANK_REPORT_CATEGORIES, a comma-separated list.
+func loadReportCategories(l *configLoader) []string {
+	raw := getenvTrim("JANK_REPORT_CATEGORIES")
+	if raw == "" {
+		return defaultReportCategories()
+	}
+	categories := parseReportCategories(raw)
+	if len(categories) == 0 {
+		l.invalid("JANK_REPORT_CATEGORIES", raw, defaultReportCategories())
+		return defaultReportCategories()
+	}
+	return categories
+}
+
+// parseReportCategories lowercases and dedupes a comma-separated category
+// list, dropping empty entries.
+func parseReportCategories(raw string) []string {
+	var categories []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(raw, ",") {
+		category := strings.ToLower(strings.TrimSpace(part))
+		if category == "" || seen[category] {
+			continue
+		}
+		seen[category] = true
+		categories = append(categories, category)
+	}
+	return categories
+}
+
+// ------------------- Seed Config -------------------
+
+func defaultSeedConfig() SeedConfig {
+	return SeedConfig{
+		Enabled: true,
+		Boards: []seedBoard{
+			{Name: "/test/", Description: "A test board."},
+		},
+	}
+}
+
+// loadSeedConfig reads JANK_SEED (default on outside production) and an
+// optional JANK_SEED_FILE containing a JSON array of boards to create on an
+// empty database.
+func loadSeedConfig(l *configLoader) (SeedConfig, error) {
+	config := defaultSeedConfig()
+	config.Enabled = l.bool("JANK_SEED", !isProduction())
+
+	path := getenvTrim("JANK_SEED_FILE")
+	if path == "" {
+		return config, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("read seed file: %w", err)
+	}
+	var boards []seedBoard
+	if err := json.Unmarshal(raw, &boards); err != nil {
+		return config, fmt.Errorf("parse seed file: %w", err)
+	}
+	config.Boards = boards
+	return config, nil
+}
+
+// ------------------- Logging -------------------
+
+// loadLogConfig reads JANK_LOG_LEVEL and JANK_LOG_FORMAT (json/text),
+// falling back to JSON at info level on invalid values.
+func loadLogConfig(l *configLoader) (logrus.Level, string) {
+	format := l.choice("JANK_LOG_FORMAT", "json", []string{"json", "text"})
+	raw := getenvTrim("JANK_LOG_LEVEL")
+	if raw == "" {
+		return logrus.InfoLevel, format
+	}
+	level, err := logrus.ParseLevel(raw)
+	if err != nil {
+		l.invalid("JANK_LOG_LEVEL", raw, logrus.InfoLevel)
+		return logrus.InfoLevel, format
+	}
+	return level, format
+}
+
+// configureLogger sets logger's level and its format, json or text.
+func configureLogger(logger *logrus.Logger, level logrus.Level, format string) {
+	if format == "text" {
+		logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	} else {
+		logger.SetFormatter(&logrus.JSONFormatter{})
 	}
+	logger.SetLevel(level)
 }
@@ -3,9 +3,11 @@ package app
 import (
 	"fmt"
 	"net"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func getenvTrim(key string) string {
@@ -21,14 +23,106 @@ func firstEnv(keys ...string) string {
 	return ""
 }
 
-func serverAddr() (string, string) {
+// isProduction reports whether JANK_ENV marks this process as a production deployment.
+func isProduction() bool {
+	switch strings.ToLower(getenvTrim("JANK_ENV")) {
+	case "prod", "production":
+		return true
+	default:
+		return false
+	}
+}
+
+// configLoader reads settings from the environment, noting each value it
+// had to replace with a default instead of logging it straight away, so
+// LoadConfig can report every problem together.
+type configLoader struct {
+	problems []error
+}
+
+func (l *configLoader) addf(format string, args ...interface{}) {
+	l.problems = append(l.problems, fmt.Errorf(format, args...))
+}
+
+func (l *configLoader) invalid(key, raw string, fallback interface{}) {
+	l.addf("invalid %s %q; using default %v", key, raw, fallback)
+}
+
+func (l *configLoader) bool(key string, fallback bool) bool {
+	raw := strings.ToLower(getenvTrim(key))
+	switch raw {
+	case "":
+		return fallback
+	case "1", "true", "yes", "on":
+		return true
+	case "0", "false", "no", "off":
+		return false
+	default:
+		l.invalid(key, raw, fallback)
+		return fallback
+	}
+}
+
+// int reads a positive integer.
+func (l *configLoader) int(key string, fallback int) int {
+	raw := getenvTrim(key)
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value <= 0 {
+		l.invalid(key, raw, fallback)
+		return fallback
+	}
+	return value
+}
+
+// duration reads a non-negative Go duration such as "90s".
+func (l *configLoader) duration(key string, fallback time.Duration) time.Duration {
+	raw := getenvTrim(key)
+	if raw == "" {
+		return fallback
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil || value < 0 {
+		l.invalid(key, raw, fallback)
+		return fallback
+	}
+	return value
+}
+
+// choice reads one of options, matched case-insensitively and returned as
+// written in options.
+func (l *configLoader) choice(key, fallback string, options []string) string {
+	raw := getenvTrim(key)
+	if raw == "" {
+		return fallback
+	}
+	for _, option := range options {
+		if strings.EqualFold(raw, option) {
+			return option
+		}
+	}
+	l.invalid(key, raw, fallback)
+	return fallback
+}
+
+// capitalize upper-cases the first letter of an error message for logging.
+func capitalize(message string) string {
+	if message == "" {
+		return message
+	}
+	return strings.ToUpper(message[:1]) + message[1:]
+}
+
+func serverAddr(l *configLoader) (string, string) {
 	if addr := getenvTrim("JANK_ADDR"); addr != "" {
 		return normalizeAddr(addr)
 	}
 
 	if port := firstEnv("JANK_PORT", "PORT"); port != "" {
 		if !validPort(port) {
-			log.Warnf("Invalid port %q; falling back to :9090", port)
+			l.invalid("port", port, ":9090")
 			return normalizeAddr(":9090")
 		}
 		return normalizeAddr(":" + port)
@@ -77,3 +171,23 @@ func normalizeAddr(addr string) (string, string) {
 	logURL := fmt.Sprintf("http://%s:%s", displayHost, port)
 	return normalized, logURL
 }
+
+// loadBaseURL reads the external site URL from JANK_BASE_URL, falling back to
+// the listen address when it is unset or not an absolute http(s) URL.
+func loadBaseURL(l *configLoader, fallback string) string {
+	raw := getenvTrim("JANK_BASE_URL")
+	if raw == "" {
+		return fallback
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		l.invalid("JANK_BASE_URL", raw, fallback)
+		return fallback
+	}
+	return strings.TrimRight(raw, "/")
+}
+
+// absURL joins the site base URL and a site-relative path.
+func absURL(path string) string {
+	return strings.TrimRight(baseURL, "/") + "/" + strings.TrimLeft(path, "/")
+}
@@ -3,6 +3,7 @@ package app
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
@@ -21,13 +22,43 @@ type treeCreateRequest struct {
 type nodeCreateRequest struct {
 	ParentID *int   `json:"parent_id"`
 	CardName string `json:"card_name"`
-	Position int    `json:"position"`
+	Position *int   `json:"position"`
+}
+
+type nodeBatchRequest struct {
+	Nodes []cardTreePayloadNode `json:"nodes"`
+}
+
+type nodeBatchResponse struct {
+	IDs map[string]int `json:"ids"`
+}
+
+type threadCreateRequest struct {
+	Title   string                `json:"title"`
+	Tags    []string              `json:"tags"`
+	Content string                `json:"content"`
+	Trees   []cardTreePayloadTree `json:"trees"`
+}
+
+type postCreateRequest struct {
+	Content   string `json:"content"`
+	Email     string `json:"email"`
+	Anonymous bool   `json:"anonymous"`
 }
 
 type nodeUpdateRequest struct {
 	ParentID *int   `json:"parent_id"`
 	CardName string `json:"card_name"`
-	Position int    `json:"position"`
+	Position *int   `json:"position"`
+}
+
+// nodePosition maps an optional position from a request to the store's
+// unsetPosition when it's missing.
+func nodePosition(position *int) int {
+	if position == nil {
+		return unsetPosition
+	}
+	return *position
 }
 
 type annotationCreateRequest struct {
@@ -45,24 +76,59 @@ type reportCreateRequest struct {
 }
 
 type reportResolveRequest struct {
-	Note string `json:"note"`
+	Note       string `json:"note"`
+	LockThread bool   `json:"lock_thread"`
 }
 
 type postDeleteRequest struct {
 	Reason string `json:"reason"`
 }
 
+type postVoteRequest struct {
+	Value int `json:"value"`
+}
+
+type postVoteResponse struct {
+	PostID int `json:"post_id"`
+	Score  int `json:"score"`
+	Vote   int `json:"vote"`
+}
+
 // boardsHandler handles creation/listing of boards (REST API).
 func boardsHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		boards, err := getAllBoards(db)
+		limit, offset, err := parsePageParams(r, pageConfig.Threads, pageConfig.Max)
+		if err != nil {
+			http.Error(w, "Invalid limit or offset", http.StatusBadRequest)
+			return
+		}
+		boards, err := getAllBoards(r.Context(), db)
 		if err != nil {
 			log.Errorf("Failed to retrieve boards: %v", err)
-			http.Error(w, "Failed to retrieve boards", http.StatusInternalServerError)
+			respondStoreError(w, err, "Failed to retrieve boards")
 			return
 		}
-		respondJSON(w, boards)
+		hidden, err := hiddenBoardIDs(r.Context(), requestUsername(r))
+		if err != nil {
+			log.Errorf("Failed to check board access: %v", err)
+			respondStoreError(w, err, "Failed to retrieve boards")
+			return
+		}
+		boards = visibleBoards(boards, hidden)
+		start, end := pageBounds(len(boards), limit, offset)
+		setPaginationHeaders(w, r, len(boards), limit, offset)
+		if fields := parseFieldsParam(r); fields != nil {
+			projected, err := projectFields(boards[start:end], fields)
+			if err != nil {
+				log.Errorf("Failed to project boards: %v", err)
+				http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+				return
+			}
+			respondJSON(w, r, projected)
+			return
+		}
+		respondJSONStream(w, r, boards[start:end])
 
 	case http.MethodPost:
 		if !requireAPIAuth(w, r) {
@@ -74,19 +140,60 @@ func boardsHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		insertedBoard, err := createBoard(db, board.Name, board.Description)
+		insertedBoard, err := createBoard(r.Context(), db, board.Name, board.Description)
+		if errors.Is(err, errBoardNameTaken) {
+			http.Error(w, "Board name already taken", http.StatusConflict)
+			return
+		}
+		if errors.Is(err, errBoardLimitReached) {
+			http.Error(w, "Board limit reached", http.StatusForbidden)
+			return
+		}
 		if err != nil {
 			log.Errorf("Failed to create board: %v", err)
-			http.Error(w, "Failed to create board", http.StatusInternalServerError)
+			respondStoreError(w, err, "Failed to create board")
 			return
 		}
-		respondJSON(w, insertedBoard)
+		respondJSON(w, r, insertedBoard)
 
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
+// boardsMinimalHandler lists every visible board with just its ID, name,
+// slug, and thread count, for building navigation.
+func boardsMinimalHandler(w http.ResponseWriter, r *http.Request) {
+	boards, err := getAllBoards(r.Context(), db)
+	if err != nil {
+		log.Errorf("Failed to retrieve boards: %v", err)
+		respondStoreError(w, err, "Failed to retrieve boards")
+		return
+	}
+	hidden, err := hiddenBoardIDs(r.Context(), requestUsername(r))
+	if err != nil {
+		log.Errorf("Failed to check board access: %v", err)
+		respondStoreError(w, err, "Failed to retrieve boards")
+		return
+	}
+	counts, err := getBoardThreadCounts(r.Context(), db)
+	if err != nil {
+		log.Errorf("Failed to count board threads: %v", err)
+		respondStoreError(w, err, "Failed to retrieve boards")
+		return
+	}
+	summaries := []BoardSummary{}
+	for _, board := range visibleBoards(boards, hidden) {
+		summaries = append(summaries, BoardSummary{
+			ID:          board.ID,
+			Name:        board.Name,
+			Slug:        urlSlug(board.Name),
+			ThreadCount: counts[board.ID],
+		})
+	}
+	respondJSON(w, r, summaries)
+}
+
 // boardHandler fetches a specific board (with threads + posts) in JSON form.
 func boardHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -98,19 +205,203 @@ func boardHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == http.MethodGet {
-		board, err := getBoardByID(db, boardID, true)
+		board, err := getBoardByID(r.Context(), db, boardID, true)
 		if err != nil {
 			log.Errorf("Board not found: %v", err)
 			http.Error(w, "Board not found", http.StatusNotFound)
 			return
 		}
-		respondJSON(w, board)
+		if !requireAPIBoardAccess(w, r, board, contentBoard) {
+			return
+		}
+		for _, thread := range board.Threads {
+			if err := resolvePostLinks(r.Context(), db, thread.Posts); err != nil {
+				log.Errorf("Failed to resolve post links: %v", err)
+			}
+		}
+		respondJSON(w, r, board)
 		return
 	}
 
 	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 }
 
+const (
+	userTreesPageSize    = 20
+	userTreesMaxPageSize = 100
+)
+
+// userTreesHandler lists the card trees a user created across all scopes (REST API).
+func userTreesHandler(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+	limit, offset, err := parsePageParams(r, userTreesPageSize, userTreesMaxPageSize)
+	if err != nil {
+		http.Error(w, "Invalid limit or offset", http.StatusBadRequest)
+		return
+	}
+	if !userExists(r.Context(), db, username) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	viewer := requestUsername(r)
+	trees, err := getCardTreesByCreator(r.Context(), db, username, viewer, limit+1, offset)
+	if err != nil {
+		log.Errorf("Failed to load card trees: %v", err)
+		respondStoreError(w, err, "Failed to load card trees")
+		return
+	}
+	total, err := countCardTreesByCreator(r.Context(), db, username, viewer)
+	if err != nil {
+		log.Errorf("Failed to count card trees: %v", err)
+		respondStoreError(w, err, "Failed to load card trees")
+		return
+	}
+	page := CardTreePage{Trees: trees, Total: total, Limit: limit, Offset: offset}
+	if len(trees) > limit {
+		page.Trees = trees[:limit]
+		page.HasMore = true
+	}
+	if page.Trees == nil {
+		page.Trees = []*CardTree{}
+	}
+	respondJSON(w, r, page)
+}
+
+const (
+	trendingPageSize    = 20
+	trendingMaxPageSize = 100
+)
+
+// trendingHandler lists threads with the most posts in the trending window (REST API).
+func trendingHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePageParams(r, trendingPageSize, trendingMaxPageSize)
+	if err != nil {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	viewer := requestUsername(r)
+	threads, err := getTrending(r.Context(), db, viewer, trendingWindow, limit, offset)
+	if err != nil {
+		log.Errorf("Failed to load trending threads: %v", err)
+		respondStoreError(w, err, "Failed to load trending threads")
+		return
+	}
+	total, err := countTrending(r.Context(), db, viewer, trendingWindow)
+	if err != nil {
+		log.Errorf("Failed to count trending threads: %v", err)
+		respondStoreError(w, err, "Failed to load trending threads")
+		return
+	}
+	if threads == nil {
+		threads = []*TrendingThread{}
+	}
+	setPaginationHeaders(w, r, total, limit, offset)
+	respondJSON(w, r, threads)
+}
+
+// klaxonHandler returns the site-wide announcement for JS clients and
+// status pages, or 204 when none is set (REST API).
+func klaxonHandler(w http.ResponseWriter, r *http.Request) {
+	klaxon, err := getKlaxon(r.Context(), db)
+	if err != nil {
+		log.Errorf("Failed to load klaxon: %v", err)
+		respondStoreError(w, err, "Failed to load klaxon")
+		return
+	}
+	if klaxon == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	respondJSON(w, r, klaxon)
+}
+
+// boardThreadsHandler pages through a board's threads in bump order using an
+// opaque ?after= cursor, for infinite scroll clients (REST API).
+func boardThreadsHandler(w http.ResponseWriter, r *http.Request) {
+	boardID, err := strconv.Atoi(mux.Vars(r)["boardID"])
+	if err != nil {
+		http.Error(w, "Invalid Board ID", http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	limit := maxThreadsShown
+	if raw := query.Get("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if limit > maxThreadsShown {
+			limit = maxThreadsShown
+		}
+	}
+	var after *threadCursor
+	if raw := query.Get("after"); raw != "" {
+		after, err = decodeThreadCursor(raw)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+	board, err := getBoardByID(r.Context(), db, boardID, false)
+	if err != nil {
+		http.Error(w, "Board not found", http.StatusNotFound)
+		return
+	}
+	if !requireAPIBoardAccess(w, r, board, contentBoard) {
+		return
+	}
+
+	threads, err := getThreadsAfterCursor(r.Context(), db, boardID, after, limit+1)
+	if err != nil {
+		log.Errorf("Failed to retrieve threads: %v", err)
+		respondStoreError(w, err, "Failed to retrieve threads")
+		return
+	}
+	page := ThreadPage{Threads: threads}
+	if len(threads) > limit {
+		page.Threads = threads[:limit]
+		page.HasMore = true
+		page.NextCursor = encodeThreadCursor(page.Threads[limit-1])
+	}
+	if page.Threads == nil {
+		page.Threads = []*Thread{}
+	}
+	respondJSON(w, r, page)
+}
+
+// boardInfoHandler describes a board, including its rules as markdown and
+// rendered HTML (REST API).
+func boardInfoHandler(w http.ResponseWriter, r *http.Request) {
+	boardID, err := strconv.Atoi(mux.Vars(r)["boardID"])
+	if err != nil {
+		http.Error(w, "Invalid Board ID", http.StatusBadRequest)
+		return
+	}
+	board, err := getBoardByID(r.Context(), db, boardID, false)
+	if err != nil {
+		http.Error(w, "Board not found", http.StatusNotFound)
+		return
+	}
+	if !requireAPIBoardAccess(w, r, board, contentBoard) {
+		return
+	}
+	threadCount, err := countThreadsByBoardID(r.Context(), db, boardID)
+	if err != nil {
+		log.Errorf("Failed to count threads: %v", err)
+		respondStoreError(w, err, "Failed to load board info")
+		return
+	}
+	respondJSON(w, r, BoardInfo{
+		ID:          board.ID,
+		Name:        board.Name,
+		Description: board.Description,
+		Rules:       board.Rules,
+		RulesHTML:   string(renderMarkdown(board.Rules)),
+		ThreadCount: threadCount,
+	})
+}
+
 // threadsHandler lists or creates threads under a board (REST API).
 func threadsHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -121,30 +412,48 @@ func threadsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Printf("handling threads for board %d", boardID)
+	board, err := getBoardByID(r.Context(), db, boardID, false)
+	if err != nil {
+		http.Error(w, "Board not found", http.StatusNotFound)
+		return
+	}
+	if !requireAPIBoardAccess(w, r, board, contentBoard) {
+		return
+	}
 
 	switch r.Method {
 	case http.MethodGet:
-		threads, err := getThreadsByBoardID(db, boardID, false)
+		sortKey, ok := parseThreadSort(r.URL.Query().Get("sort"))
+		if !ok {
+			http.Error(w, "Invalid sort; use bump, new, replies, or tags", http.StatusBadRequest)
+			return
+		}
+		limit, offset, err := parsePageParams(r, pageConfig.Threads, pageConfig.Max)
+		if err != nil {
+			http.Error(w, "Invalid limit or offset", http.StatusBadRequest)
+			return
+		}
+		threads, err := getThreadsByBoardID(r.Context(), db, boardID, sortKey, false)
 		if err != nil {
 			log.Errorf("Failed to retrieve threads: %v", err)
-			http.Error(w, "Failed to retrieve threads", http.StatusInternalServerError)
+			respondStoreError(w, err, "Failed to retrieve threads")
 			return
 		}
-		respondJSON(w, threads)
+		start, end := pageBounds(len(threads), limit, offset)
+		setPaginationHeaders(w, r, len(threads), limit, offset)
+		respondJSONStream(w, r, threads[start:end])
 
 	case http.MethodPost:
 		if !requireAPIAuth(w, r) {
 			return
 		}
 		username, _ := getBearerUsername(r)
-		var thread Thread
-		if err := json.NewDecoder(r.Body).Decode(&thread); err != nil {
+		var req threadCreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		log.Printf("created thread %+v", &thread)
-
-		tags, err := validateTags(thread.Tags)
+		tags, err := validateTags(req.Tags)
 		if err != nil {
 			message := "Invalid tags"
 			if errors.Is(err, errTagCount) {
@@ -155,14 +464,75 @@ func threadsHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, message, http.StatusBadRequest)
 			return
 		}
+		content := strings.TrimSpace(req.Content)
+		title, err := resolveThreadTitle(board, req.Title, content)
+		if err != nil {
+			http.Error(w, "Title is required", http.StatusBadRequest)
+			return
+		}
+		var treePayload *cardTreePayload
+		if len(req.Trees) > 0 {
+			if content == "" {
+				http.Error(w, "Content is required to attach card trees", http.StatusBadRequest)
+				return
+			}
+			treePayload = &cardTreePayload{Trees: req.Trees}
+			if err := validateCardTreePayload(treePayload, treeLimits); err != nil {
+				http.Error(w, cardTreePayloadErrorMessage(err), http.StatusBadRequest)
+				return
+			}
+		}
 
-		insertedThread, err := createThread(db, boardID, thread.Title, username, tags)
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Errorf("Failed to begin thread creation: %v", err)
+			respondStoreError(w, err, "Failed to create thread")
+			return
+		}
+		defer tx.Rollback()
+		insertedThread, err := createThread(r.Context(), tx, boardID, title, username, tags)
 		if err != nil {
 			log.Errorf("Failed to create thread: %v", err)
-			http.Error(w, "Failed to create thread", http.StatusInternalServerError)
+			respondStoreError(w, err, "Failed to create thread")
 			return
 		}
-		respondJSON(w, insertedThread)
+		var op *Post
+		if content != "" {
+			op, err = createPost(withPosterIP(r), tx, insertedThread.ID, username, content, "")
+			if err != nil {
+				if status, _, message, ok := postRejection(err); ok {
+					http.Error(w, message, status)
+					return
+				}
+				log.Errorf("Failed to create post: %v", err)
+				respondStoreError(w, err, "Failed to create thread")
+				return
+			}
+			if err := applyCardTreePayload(r.Context(), tx, "post", op.ID, username, treePayload); err != nil {
+				if errors.Is(err, errTreePayloadInvalid) {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				log.Errorf("Failed to create card tree: %v", err)
+				respondStoreError(w, err, "Failed to create thread")
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			log.Errorf("Failed to commit thread creation: %v", err)
+			respondStoreError(w, err, "Failed to create thread")
+			return
+		}
+		emitThreadCreated(insertedThread, boardID)
+
+		if op != nil {
+			op.Trees, err = getCardTreesByScope(r.Context(), db, "post", op.ID, true)
+			if err != nil {
+				log.Errorf("Failed to load card trees: %v", err)
+			}
+			insertedThread.Posts = []*Post{op}
+		}
+		respondJSON(w, r, insertedThread)
 
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -184,6 +554,14 @@ func postsHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid Thread ID", http.StatusBadRequest)
 		return
 	}
+	board, err := getThreadBoard(r.Context(), db, threadID)
+	if err != nil {
+		http.Error(w, "Thread not found", http.StatusNotFound)
+		return
+	}
+	if !requireAPIBoardAccess(w, r, board, contentThread) {
+		return
+	}
 
 	switch r.Method {
 	case http.MethodPost:
@@ -191,39 +569,93 @@ func postsHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		username, _ := getBearerUsername(r)
-		var post Post
-		if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+		var req postCreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		post.Author = username
-		insertedPost, err := createPost(db, threadID, post.Author, post.Content)
+		ctx := withPosterIP(r)
+		if req.Anonymous {
+			ctx = withAnonymousPost(ctx)
+		}
+		insertedPost, err := createPost(ctx, db, threadID, username, req.Content, req.Email)
 		if err != nil {
+			if status, _, message, ok := postRejection(err); ok {
+				http.Error(w, message, status)
+				return
+			}
 			log.Errorf("Failed to create post: %v", err)
-			http.Error(w, "Failed to create post", http.StatusInternalServerError)
+			respondStoreError(w, err, "Failed to create post")
 			return
 		}
-		respondJSON(w, insertedPost)
+		if err := resolvePostLinks(r.Context(), db, []*Post{insertedPost}); err != nil {
+			log.Errorf("Failed to resolve post links: %v", err)
+		}
+		respondJSON(w, r, insertedPost)
 
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
+// threadPostsSinceHandler returns a thread's posts newer than a post ID as
+// JSON, so polling clients can append new replies without reloading the thread.
+func threadPostsSinceHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	threadID, err := strconv.Atoi(vars["threadID"])
+	if err != nil {
+		respondJSONError(w, http.StatusBadRequest, "invalid thread ID")
+		return
+	}
+	afterID, err := strconv.Atoi(vars["postID"])
+	if err != nil {
+		respondJSONError(w, http.StatusBadRequest, "invalid post ID")
+		return
+	}
+	board, err := getThreadBoard(r.Context(), db, threadID)
+	if err != nil {
+		respondJSONError(w, http.StatusNotFound, "thread not found")
+		return
+	}
+	if !requireJSONBoardAccess(w, r, board, contentThread) {
+		return
+	}
+	posts, err := getPostsSince(r.Context(), db, threadID, afterID)
+	if err != nil {
+		log.Errorf("Failed to load posts: %v", err)
+		respondStoreError(w, err, "Failed to load posts")
+		return
+	}
+	if err := resolvePostLinks(r.Context(), db, posts); err != nil {
+		log.Errorf("Failed to resolve post links: %v", err)
+	}
+	if posts == nil {
+		posts = []*Post{}
+	}
+	respondJSON(w, r, posts)
+}
+
 func reportsHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 		if !requireAPIModerator(w, r) {
 			return
 		}
-		reports, err := getOpenReports(db)
+		limit, offset, err := parsePageParams(r, pageConfig.Posts, pageConfig.Max)
+		if err != nil {
+			http.Error(w, "Invalid limit or offset", http.StatusBadRequest)
+			return
+		}
+		reports, err := getOpenReports(r.Context(), db)
 		if err != nil {
 			log.Errorf("Failed to load reports: %v", err)
-			http.Error(w, "Failed to load reports", http.StatusInternalServerError)
+			respondStoreError(w, err, "Failed to load reports")
 			return
 		}
-		respondJSON(w, reports)
+		start, end := pageBounds(len(reports), limit, offset)
+		setPaginationHeaders(w, r, len(reports), limit, offset)
+		respondJSONStream(w, r, reports[start:end])
 
 	case http.MethodPost:
 		if !requireAPIAuth(w, r) {
@@ -239,19 +671,27 @@ func reportsHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Post ID is required", http.StatusBadRequest)
 			return
 		}
+		if !requireAPIContentAccess(w, r, contentPost, req.PostID) {
+			return
+		}
 		req.Category = strings.TrimSpace(req.Category)
 		if !isValidReportCategory(req.Category) {
 			http.Error(w, "Invalid category", http.StatusBadRequest)
 			return
 		}
 		req.Reason = strings.TrimSpace(req.Reason)
-		report, err := createReport(db, req.PostID, req.Category, req.Reason, username)
+		report, err := createReport(r.Context(), db, req.PostID, req.Category, req.Reason, username)
 		if err != nil {
+			if errors.Is(err, errReportLimit) {
+				http.Error(w, reportLimitMessage, http.StatusTooManyRequests)
+				return
+			}
 			log.Errorf("Failed to create report: %v", err)
-			http.Error(w, "Failed to create report", http.StatusInternalServerError)
+			respondStoreError(w, err, "Failed to create report")
 			return
 		}
-		respondJSON(w, report)
+		emitWebhook(webhookReportCreated, report)
+		respondJSON(w, r, report)
 
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -278,12 +718,12 @@ func reportResolveHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	username, _ := getBearerUsername(r)
-	if err := resolveReport(db, reportID, username, strings.TrimSpace(req.Note)); err != nil {
+	if err := resolveReport(r.Context(), db, reportID, username, strings.TrimSpace(req.Note), req.LockThread); err != nil {
 		log.Errorf("Failed to resolve report: %v", err)
-		http.Error(w, "Failed to resolve report", http.StatusInternalServerError)
+		respondStoreError(w, err, "Failed to resolve report")
 		return
 	}
-	respondJSON(w, map[string]string{"status": "ok"})
+	respondJSON(w, r, map[string]string{"status": "ok"})
 }
 
 func postDeleteHandler(w http.ResponseWriter, r *http.Request) {
@@ -311,12 +751,59 @@ func postDeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	username, _ := getBearerUsername(r)
-	if err := softDeletePost(db, postID, username, req.Reason); err != nil {
+	if err := softDeletePost(r.Context(), db, postID, username, req.Reason); err != nil {
 		log.Errorf("Failed to delete post: %v", err)
-		http.Error(w, "Failed to delete post", http.StatusInternalServerError)
+		respondStoreError(w, err, "Failed to delete post")
 		return
 	}
-	respondJSON(w, map[string]string{"status": "ok"})
+	emitWebhook(webhookPostDeleted, webhookPostDeletion{PostID: postID, DeletedBy: username, Reason: req.Reason})
+	respondJSON(w, r, map[string]string{"status": "ok"})
+}
+
+// postVoteHandler records the caller's +1/-1 vote on a post (REST API).
+func postVoteHandler(w http.ResponseWriter, r *http.Request) {
+	if !postVotesEnabled {
+		respondJSONError(w, http.StatusNotFound, "voting is disabled")
+		return
+	}
+	if !requireAPIAuth(w, r) {
+		return
+	}
+	postID, err := strconv.Atoi(mux.Vars(r)["postID"])
+	if err != nil {
+		http.Error(w, "Invalid Post ID", http.StatusBadRequest)
+		return
+	}
+	var req postVoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !isValidVote(req.Value) {
+		http.Error(w, "Value must be 1, -1, or 0", http.StatusBadRequest)
+		return
+	}
+	if !requireAPIContentAccess(w, r, contentPost, postID) {
+		return
+	}
+	username, _ := getBearerUsername(r)
+	vote, err := votePost(r.Context(), db, postID, username, req.Value)
+	if errors.Is(err, errVoteDeletedPost) {
+		respondJSONError(w, http.StatusConflict, "post has been deleted")
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to record vote: %v", err)
+		respondStoreError(w, err, "Failed to record vote")
+		return
+	}
+	scores, err := getPostScores(r.Context(), db, []int{postID})
+	if err != nil {
+		log.Errorf("Failed to load post score: %v", err)
+		respondStoreError(w, err, "Failed to load score")
+		return
+	}
+	respondJSON(w, r, postVoteResponse{PostID: postID, Score: scores[postID], Vote: vote})
 }
 
 // boardTreesHandler lists or creates trees under a board (REST API).
@@ -328,16 +815,19 @@ func boardTreesHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid Board ID", http.StatusBadRequest)
 		return
 	}
+	if !requireAPIContentAccess(w, r, contentBoard, boardID) {
+		return
+	}
 
 	switch r.Method {
 	case http.MethodGet:
-		trees, err := getCardTreesByScope(db, "board", boardID, false)
+		trees, err := getCardTreesByScope(r.Context(), db, "board", boardID, false)
 		if err != nil {
 			log.Errorf("Failed to retrieve board trees: %v", err)
-			http.Error(w, "Failed to retrieve trees", http.StatusInternalServerError)
+			respondStoreError(w, err, "Failed to retrieve trees")
 			return
 		}
-		respondJSON(w, trees)
+		respondJSON(w, r, trees)
 
 	case http.MethodPost:
 		if !requireAPIAuth(w, r) {
@@ -353,13 +843,13 @@ func boardTreesHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Title is required", http.StatusBadRequest)
 			return
 		}
-		tree, err := createCardTree(db, "board", boardID, req.Title, req.Description, username, req.IsPrimary)
+		tree, err := createCardTree(r.Context(), db, "board", boardID, req.Title, req.Description, username, req.IsPrimary)
 		if err != nil {
 			log.Errorf("Failed to create board tree: %v", err)
-			http.Error(w, "Failed to create tree", http.StatusInternalServerError)
+			respondStoreError(w, err, "Failed to create tree")
 			return
 		}
-		respondJSON(w, tree)
+		respondJSON(w, r, tree)
 
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -375,16 +865,19 @@ func threadTreesHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid Thread ID", http.StatusBadRequest)
 		return
 	}
+	if !requireAPIContentAccess(w, r, contentThread, threadID) {
+		return
+	}
 
 	switch r.Method {
 	case http.MethodGet:
-		trees, err := getCardTreesByScope(db, "thread", threadID, false)
+		trees, err := getCardTreesByScope(r.Context(), db, "thread", threadID, false)
 		if err != nil {
 			log.Errorf("Failed to retrieve thread trees: %v", err)
-			http.Error(w, "Failed to retrieve trees", http.StatusInternalServerError)
+			respondStoreError(w, err, "Failed to retrieve trees")
 			return
 		}
-		respondJSON(w, trees)
+		respondJSON(w, r, trees)
 
 	case http.MethodPost:
 		if !requireAPIAuth(w, r) {
@@ -400,13 +893,13 @@ func threadTreesHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Title is required", http.StatusBadRequest)
 			return
 		}
-		tree, err := createCardTree(db, "thread", threadID, req.Title, req.Description, username, req.IsPrimary)
+		tree, err := createCardTree(r.Context(), db, "thread", threadID, req.Title, req.Description, username, req.IsPrimary)
 		if err != nil {
 			log.Errorf("Failed to create thread tree: %v", err)
-			http.Error(w, "Failed to create tree", http.StatusInternalServerError)
+			respondStoreError(w, err, "Failed to create tree")
 			return
 		}
-		respondJSON(w, tree)
+		respondJSON(w, r, tree)
 
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -427,14 +920,62 @@ func treeHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !requireAPIContentAccess(w, r, contentTree, treeID) {
+		return
+	}
 
-	tree, err := getCardTreeByID(db, treeID)
+	tree, err := getCardTreeByID(r.Context(), db, treeID)
 	if err != nil {
 		log.Errorf("Tree not found: %v", err)
 		http.Error(w, "Tree not found", http.StatusNotFound)
 		return
 	}
-	respondJSON(w, tree)
+	respondJSON(w, r, tree)
+}
+
+// treeGraphHandler returns a tree as nodes and edges for visualization
+// (REST API). Nodes come in tree order; each non-root node has one edge from
+// its parent.
+func treeGraphHandler(w http.ResponseWriter, r *http.Request) {
+	treeID, err := strconv.Atoi(mux.Vars(r)["treeID"])
+	if err != nil {
+		http.Error(w, "Invalid Tree ID", http.StatusBadRequest)
+		return
+	}
+	if !requireAPIContentAccess(w, r, contentTree, treeID) {
+		return
+	}
+	tree, err := getCardTreeByID(r.Context(), db, treeID)
+	if err != nil {
+		log.Errorf("Tree not found: %v", err)
+		http.Error(w, "Tree not found", http.StatusNotFound)
+		return
+	}
+	graph := CardTreeGraph{
+		TreeID: tree.ID,
+		Title:  tree.Title,
+		Nodes:  make([]CardTreeGraphNode, 0, len(tree.Nodes)),
+		Edges:  []CardTreeGraphEdge{},
+	}
+	for _, node := range tree.Nodes {
+		graph.Nodes = append(graph.Nodes, CardTreeGraphNode{
+			ID:          node.ID,
+			Label:       node.CardName,
+			ParentID:    node.ParentID,
+			Position:    node.Position,
+			Depth:       node.Depth,
+			Indent:      node.Indent,
+			Annotations: len(node.Annotations),
+		})
+		if node.ParentID != nil {
+			graph.Edges = append(graph.Edges, CardTreeGraphEdge{
+				ID:     fmt.Sprintf("%d-%d", *node.ParentID, node.ID),
+				Source: *node.ParentID,
+				Target: node.ID,
+			})
+		}
+	}
+	respondJSON(w, r, graph)
 }
 
 // treeNodesHandler creates nodes under a tree (REST API).
@@ -451,7 +992,7 @@ func treeNodesHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if !requireAPIAuth(w, r) {
+	if !requireAPIAuth(w, r) || !requireAPIContentAccess(w, r, contentTree, treeID) {
 		return
 	}
 	username, _ := getBearerUsername(r)
@@ -464,13 +1005,75 @@ func treeNodesHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Card name is required", http.StatusBadRequest)
 		return
 	}
-	node, err := createCardTreeNode(db, treeID, req.ParentID, req.CardName, req.Position, username)
+	node, err := createCardTreeNode(r.Context(), db, treeID, req.ParentID, req.CardName, nodePosition(req.Position), username)
 	if err != nil {
 		log.Errorf("Failed to create tree node: %v", err)
-		http.Error(w, "Failed to create node", http.StatusInternalServerError)
+		respondStoreError(w, err, "Failed to create node")
 		return
 	}
-	respondJSON(w, node)
+	respondJSON(w, r, node)
+}
+
+// treeNodesBatchHandler creates several nodes in one transaction, resolving
+// parent references by temp ID (REST API).
+func treeNodesBatchHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	treeID, err := strconv.Atoi(vars["treeID"])
+	if err != nil {
+		http.Error(w, "Invalid Tree ID", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAPIAuth(w, r) || !requireAPIContentAccess(w, r, contentTree, treeID) {
+		return
+	}
+	username, _ := getBearerUsername(r)
+	var req nodeBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Nodes) == 0 {
+		http.Error(w, "At least one node is required", http.StatusBadRequest)
+		return
+	}
+	payload := &cardTreePayload{Trees: []cardTreePayloadTree{{Nodes: req.Nodes}}}
+	if err := validateCardTreePayload(payload, treeLimits); err != nil {
+		http.Error(w, cardTreePayloadErrorMessage(err), http.StatusBadRequest)
+		return
+	}
+	if _, err := getCardTreeByID(r.Context(), db, treeID); err != nil {
+		http.Error(w, "Tree not found", http.StatusNotFound)
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Errorf("Failed to begin node batch: %v", err)
+		respondStoreError(w, err, "Failed to create nodes")
+		return
+	}
+	defer tx.Rollback()
+	ids, err := createCardTreePayloadNodes(r.Context(), tx, treeID, req.Nodes, username)
+	if err != nil {
+		if errors.Is(err, errTreePayloadInvalid) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Errorf("Failed to create tree nodes: %v", err)
+		respondStoreError(w, err, "Failed to create nodes")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Errorf("Failed to commit node batch: %v", err)
+		respondStoreError(w, err, "Failed to create nodes")
+		return
+	}
+	respondJSON(w, r, nodeBatchResponse{IDs: ids})
 }
 
 // treeNodeHandler updates or deletes a tree node (REST API).
@@ -491,7 +1094,7 @@ func treeNodeHandler(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodPatch:
-		if !requireAPIAuth(w, r) {
+		if !requireAPIAuth(w, r) || !requireAPIContentAccess(w, r, contentTree, treeID) {
 			return
 		}
 		var req nodeUpdateRequest
@@ -503,7 +1106,7 @@ func treeNodeHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Card name is required", http.StatusBadRequest)
 			return
 		}
-		nodeTreeID, err := getCardTreeNodeTreeID(db, nodeID)
+		nodeTreeID, err := getCardTreeNodeTreeID(r.Context(), db, nodeID)
 		if err != nil {
 			http.Error(w, "Node not found", http.StatusNotFound)
 			return
@@ -512,18 +1115,18 @@ func treeNodeHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Node does not belong to tree", http.StatusBadRequest)
 			return
 		}
-		if err := updateCardTreeNode(db, nodeID, req.ParentID, req.CardName, req.Position); err != nil {
+		if err := updateCardTreeNode(r.Context(), db, nodeID, req.ParentID, req.CardName, nodePosition(req.Position)); err != nil {
 			log.Errorf("Failed to update tree node: %v", err)
-			http.Error(w, "Failed to update node", http.StatusInternalServerError)
+			respondStoreError(w, err, "Failed to update node")
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
 
 	case http.MethodDelete:
-		if !requireAPIAuth(w, r) {
+		if !requireAPIAuth(w, r) || !requireAPIContentAccess(w, r, contentTree, treeID) {
 			return
 		}
-		nodeTreeID, err := getCardTreeNodeTreeID(db, nodeID)
+		nodeTreeID, err := getCardTreeNodeTreeID(r.Context(), db, nodeID)
 		if err != nil {
 			http.Error(w, "Node not found", http.StatusNotFound)
 			return
@@ -532,9 +1135,9 @@ func treeNodeHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Node does not belong to tree", http.StatusBadRequest)
 			return
 		}
-		if err := deleteCardTreeNode(db, nodeID); err != nil {
+		if err := deleteCardTreeNode(r.Context(), db, nodeID); err != nil {
 			log.Errorf("Failed to delete tree node: %v", err)
-			http.Error(w, "Failed to delete node", http.StatusInternalServerError)
+			respondStoreError(w, err, "Failed to delete node")
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
@@ -564,10 +1167,10 @@ func treeNodeAnnotationsHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if !requireAPIAuth(w, r) {
+	if !requireAPIAuth(w, r) || !requireAPIContentAccess(w, r, contentTree, treeID) {
 		return
 	}
-	nodeTreeID, err := getCardTreeNodeTreeID(db, nodeID)
+	nodeTreeID, err := getCardTreeNodeTreeID(r.Context(), db, nodeID)
 	if err != nil {
 		http.Error(w, "Node not found", http.StatusNotFound)
 		return
@@ -590,18 +1193,28 @@ func treeNodeAnnotationsHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Body is required", http.StatusBadRequest)
 		return
 	}
-	annotation, err := createCardTreeAnnotation(db, nodeID, kind, req.Body, req.Label, req.Tags, req.SourcePostID, username)
+	annotation, err := createCardTreeAnnotation(r.Context(), db, nodeID, kind, req.Body, req.Label, req.Tags, req.SourcePostID, username)
 	if err != nil {
 		log.Errorf("Failed to create annotation: %v", err)
-		http.Error(w, "Failed to create annotation", http.StatusInternalServerError)
+		respondStoreError(w, err, "Failed to create annotation")
 		return
 	}
-	respondJSON(w, annotation)
+	respondJSON(w, r, annotation)
 }
 
 // treeNodeAnnotationHandler deletes an annotation (REST API).
 func treeNodeAnnotationHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	treeID, err := strconv.Atoi(vars["treeID"])
+	if err != nil {
+		http.Error(w, "Invalid Tree ID", http.StatusBadRequest)
+		return
+	}
+	nodeID, err := strconv.Atoi(vars["nodeID"])
+	if err != nil {
+		http.Error(w, "Invalid Node ID", http.StatusBadRequest)
+		return
+	}
 	annotationIDStr := vars["annotationID"]
 	annotationID, err := strconv.Atoi(annotationIDStr)
 	if err != nil {
@@ -612,12 +1225,21 @@ func treeNodeAnnotationHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if !requireAPIAuth(w, r) {
+	if !requireAPIAuth(w, r) || !requireAPIContentAccess(w, r, contentTree, treeID) {
+		return
+	}
+	nodeTreeID, err := getCardTreeNodeTreeID(r.Context(), db, nodeID)
+	if err != nil {
+		http.Error(w, "Node not found", http.StatusNotFound)
+		return
+	}
+	if nodeTreeID != treeID {
+		http.Error(w, "Node does not belong to tree", http.StatusBadRequest)
 		return
 	}
-	if err := deleteCardTreeAnnotation(db, annotationID); err != nil {
+	if err := deleteCardTreeAnnotation(r.Context(), db, nodeID, annotationID); err != nil {
 		log.Errorf("Failed to delete annotation: %v", err)
-		http.Error(w, "Failed to delete annotation", http.StatusInternalServerError)
+		respondStoreError(w, err, "Failed to delete annotation")
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -641,10 +1263,10 @@ func deleteBoardHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = deleteBoardByID(db, boardID)
+	err = deleteBoardByID(r.Context(), db, boardID)
 	if err != nil {
 		log.Errorf("Failed to delete board: %v", err)
-		http.Error(w, "Failed to delete board", http.StatusInternalServerError)
+		respondStoreError(w, err, "Failed to delete board")
 		return
 	}
 
@@ -18,16 +18,17 @@ func authTokenHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	if !authenticateUser(db, credentials.Username, credentials.Password) {
+	if !authenticateUser(r.Context(), db, credentials.Username, credentials.Password) {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
+	noteLogin(r, credentials.Username)
 	token, expiresAt, err := issueJWT(credentials.Username, 24*time.Hour)
 	if err != nil {
 		http.Error(w, "Failed to issue token", http.StatusInternalServerError)
 		return
 	}
-	respondJSON(w, map[string]interface{}{
+	respondJSON(w, r, map[string]interface{}{
 		"token":      token,
 		"expires_at": expiresAt.UTC().Format(time.RFC3339),
 	})
@@ -47,11 +48,15 @@ func authSignupHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Username and password required", http.StatusBadRequest)
 		return
 	}
-	if len(credentials.Username) > 32 || len(credentials.Password) < 8 || len(credentials.Password) > 1024 {
-		http.Error(w, "Invalid username or password length", http.StatusBadRequest)
+	if len(credentials.Username) > 32 {
+		http.Error(w, "Invalid username length", http.StatusBadRequest)
 		return
 	}
-	if _, err := createUser(db, credentials.Username, credentials.Password); err != nil {
+	if err := checkPassword(credentials.Password); err != nil {
+		http.Error(w, capitalize(err.Error()), http.StatusBadRequest)
+		return
+	}
+	if _, err := createUser(r.Context(), db, credentials.Username, credentials.Password); err != nil {
 		log.Errorf("Failed to create user: %v", err)
 		http.Error(w, signupErrorMessage(err), http.StatusBadRequest)
 		return
@@ -61,8 +66,30 @@ func authSignupHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to issue token", http.StatusInternalServerError)
 		return
 	}
-	respondJSON(w, map[string]interface{}{
+	respondJSON(w, r, map[string]interface{}{
 		"token":      token,
 		"expires_at": expiresAt.UTC().Format(time.RFC3339),
 	})
 }
+
+// whoamiHandler reports the caller's username and roles, or 401 when
+// signed out. It accepts a bearer token or, for pages, the login cookie.
+func whoamiHandler(w http.ResponseWriter, r *http.Request) {
+	identity := Identity{Via: "bearer"}
+	username, ok := getBearerUsername(r)
+	if !ok {
+		identity.Via = "cookie"
+		username, ok = getAuthenticatedUsername(r)
+	}
+	if !ok {
+		respondJSONError(w, http.StatusUnauthorized, "not signed in")
+		return
+	}
+	identity.Username = username
+	identity.Roles = []string{"user"}
+	if isModerator(username) {
+		identity.Roles = append(identity.Roles, "moderator")
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, r, identity)
+}
@@ -0,0 +1,175 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// ------------------- 4chan-Compatible JSON -------------------
+
+// chanCatalogPageSize is how many threads each catalog.json page holds,
+// matching 4chan's boards.
+const chanCatalogPageSize = 15
+
+// chanCatalogReplies is how many recent replies each catalog thread carries
+// in last_replies.
+const chanCatalogReplies = 5
+
+// chanPost is a post in the shape of 4chan's read-only API. The opening
+// post's no is the thread ID so clients can build thread.json URLs from it;
+// replies use their post ID.
+type chanPost struct {
+	No    int    `json:"no"`
+	Resto int    `json:"resto"`
+	Now   string `json:"now"`
+	Time  int64  `json:"time"`
+	Name  string `json:"name"`
+	Sub   string `json:"sub,omitempty"`
+	Com   string `json:"com,omitempty"`
+}
+
+// chanOP adds the thread-level fields 4chan only sets on opening posts.
+type chanOP struct {
+	chanPost
+	Closed       int        `json:"closed,omitempty"`
+	Replies      int        `json:"replies"`
+	Images       int        `json:"images"`
+	LastModified int64      `json:"last_modified"`
+	OmittedPosts int        `json:"omitted_posts,omitempty"`
+	LastReplies  []chanPost `json:"last_replies,omitempty"`
+}
+
+type chanCatalogPage struct {
+	Page    int      `json:"page"`
+	Threads []chanOP `json:"threads"`
+}
+
+type chanThread struct {
+	Posts []interface{} `json:"posts"`
+}
+
+func newChanPost(post *Post, no, resto int, anonName string) chanPost {
+	name := post.Author
+	if name == "" {
+		name = anonName
+	}
+	cp := chanPost{
+		No:    no,
+		Resto: resto,
+		Now:   post.Created.UTC().Format("01/02/06(Mon)15:04:05"),
+		Time:  post.Created.Unix(),
+		Name:  name,
+	}
+	if !post.IsDeleted {
+		cp.Com = strings.TrimSpace(string(renderPostContent(post)))
+	}
+	return cp
+}
+
+// chanBoard resolves {boardName} and checks the caller may read it, writing
+// the error response if not.
+func chanBoard(w http.ResponseWriter, r *http.Request) (*Board, bool) {
+	board, err := getBoardByCode(r.Context(), db, mux.Vars(r)["boardName"])
+	if errors.Is(err, errBoardNotFound) {
+		respondJSONError(w, http.StatusNotFound, "board not found")
+		return nil, false
+	}
+	if err != nil {
+		log.Errorf("Failed to load board: %v", err)
+		respondStoreError(w, err, "Failed to load board")
+		return nil, false
+	}
+	if !requireJSONBoardAccess(w, r, board, contentBoard) {
+		return nil, false
+	}
+	return board, true
+}
+
+// chanCatalogHandler serves /{boardName}/catalog.json: every thread on the
+// board in bump order, split into pages. Like thread.json, reply counts and
+// last_replies leave out deleted posts.
+func chanCatalogHandler(w http.ResponseWriter, r *http.Request) {
+	board, ok := chanBoard(w, r)
+	if !ok {
+		return
+	}
+	threads, err := getThreadsByBoardID(r.Context(), db, board.ID, defaultThreadSort, false)
+	if err != nil {
+		log.Errorf("Failed to load catalog threads: %v", err)
+		respondStoreError(w, err, "Failed to load threads")
+		return
+	}
+
+	previews, err := getBoardThreadPreviews(r.Context(), db, board.ID, chanCatalogReplies)
+	if err != nil {
+		log.Errorf("Failed to load thread previews: %v", err)
+		respondStoreError(w, err, "Failed to load threads")
+		return
+	}
+
+	pages := []chanCatalogPage{}
+	for _, thread := range threads {
+		preview, ok := previews[thread.ID]
+		if !ok || preview.OP == nil {
+			continue
+		}
+		op := chanOP{
+			chanPost:     newChanPost(preview.OP, thread.ID, 0, board.AnonLabel()),
+			Replies:      preview.LiveReplies,
+			LastModified: thread.LastBump.Unix(),
+		}
+		op.Sub = thread.Title
+		for _, reply := range preview.Tail {
+			if !reply.IsDeleted {
+				op.LastReplies = append(op.LastReplies, newChanPost(reply, reply.ID, thread.ID, board.AnonLabel()))
+			}
+		}
+		op.OmittedPosts = op.Replies - len(op.LastReplies)
+		if len(pages) == 0 || len(pages[len(pages)-1].Threads) == chanCatalogPageSize {
+			pages = append(pages, chanCatalogPage{Page: len(pages) + 1})
+		}
+		pages[len(pages)-1].Threads = append(pages[len(pages)-1].Threads, op)
+	}
+	respondJSON(w, r, pages)
+}
+
+// chanThreadHandler serves /{boardName}/thread/{threadID}.json: the opening
+// post followed by every reply that hasn't been deleted.
+func chanThreadHandler(w http.ResponseWriter, r *http.Request) {
+	board, ok := chanBoard(w, r)
+	if !ok {
+		return
+	}
+	threadID, err := strconv.Atoi(mux.Vars(r)["threadID"])
+	if err != nil {
+		respondJSONError(w, http.StatusBadRequest, "invalid thread ID")
+		return
+	}
+	thread, boardID, err := getThreadByID(r.Context(), db, threadID)
+	if err != nil || boardID != board.ID || len(thread.Posts) == 0 {
+		respondJSONError(w, http.StatusNotFound, "thread not found")
+		return
+	}
+
+	op := chanOP{
+		chanPost:     newChanPost(thread.Posts[0], thread.ID, 0, board.AnonLabel()),
+		Replies:      len(thread.Posts) - 1,
+		LastModified: thread.LastBump.Unix(),
+	}
+	op.Sub = thread.Title
+	if thread.IsLocked {
+		op.Closed = 1
+	}
+	out := chanThread{Posts: []interface{}{op}}
+	for _, post := range thread.Posts[1:] {
+		if post.IsDeleted {
+			continue
+		}
+		out.Posts = append(out.Posts, newChanPost(post, post.ID, thread.ID, board.AnonLabel()))
+	}
+	respondJSON(w, r, out)
+}
@@ -0,0 +1,139 @@
+package app
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ------------------- RSS Feeds -------------------
+
+const (
+	feedItemLimit = 30
+	feedCacheTTL  = time.Minute
+)
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        string   `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Category    []string `xml:"category"`
+	Description string   `xml:"description,omitempty"`
+}
+
+// feedCache keeps rendered feeds for feedCacheTTL so crawlers polling the
+// feeds don't hit the database on every request.
+var feedCache = newTTLStore[[]byte]()
+
+func resetFeedCache() {
+	feedCache.Clear()
+}
+
+// serveGlobalFeed lists the newest threads across all boards.
+func serveGlobalFeed(w http.ResponseWriter, r *http.Request) {
+	serveThreadFeed(w, r, "jank: new threads", "The newest threads across every board.", 0, "")
+}
+
+// serveTagFeed lists the newest threads carrying a tag.
+func serveTagFeed(w http.ResponseWriter, r *http.Request) {
+	tags := normalizeTags([]string{mux.Vars(r)["tag"]})
+	if len(tags) == 0 {
+		http.Error(w, "Invalid tag", http.StatusBadRequest)
+		return
+	}
+	tag := tags[0]
+	serveThreadFeed(w, r, fmt.Sprintf("jank: #%s", tag), fmt.Sprintf("The newest threads tagged #%s.", tag), 0, tag)
+}
+
+// serveBoardFeed lists the newest threads on one board.
+func serveBoardFeed(w http.ResponseWriter, r *http.Request) {
+	boardID, err := strconv.Atoi(mux.Vars(r)["boardID"])
+	if err != nil {
+		http.Error(w, "Invalid board ID", http.StatusBadRequest)
+		return
+	}
+	board, err := getBoardByID(r.Context(), db, boardID, false)
+	// Feeds are public and cached, so restricted boards don't get one.
+	if err != nil || board.Visibility == boardVisibilityRestricted {
+		http.Error(w, "Board not found", http.StatusNotFound)
+		return
+	}
+	description := board.Description
+	if description == "" {
+		description = fmt.Sprintf("The newest threads on %s.", board.Name)
+	}
+	serveThreadFeed(w, r, fmt.Sprintf("jank: %s", board.Name), description, boardID, "")
+}
+
+func serveThreadFeed(w http.ResponseWriter, r *http.Request, title, description string, boardID int, tag string) {
+	cacheKey := r.URL.Path
+	body, ok := feedCache.Get(cacheKey)
+	if !ok {
+		threads, err := getRecentThreads(r.Context(), db, boardID, tag, feedItemLimit)
+		if err != nil {
+			log.Errorf("Failed to load feed threads: %v", err)
+			respondStoreError(w, err, "Failed to load feed")
+			return
+		}
+		body, err = renderThreadFeed(title, description, threads)
+		if err != nil {
+			log.Errorf("Failed to render feed: %v", err)
+			http.Error(w, "Failed to render feed", http.StatusInternalServerError)
+			return
+		}
+		feedCache.Set(cacheKey, body, feedCacheTTL)
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(feedCacheTTL.Seconds())))
+	_, _ = w.Write(body)
+}
+
+func renderThreadFeed(title, description string, threads []*RecentThread) ([]byte, error) {
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       title,
+			Link:        absURL("/"),
+			Description: description,
+		},
+	}
+	for _, thread := range threads {
+		link := absURL(fmt.Sprintf("/view/thread/%d", thread.ID))
+		summary := fmt.Sprintf("New thread on %s", thread.BoardName)
+		if thread.Author != "" {
+			summary += " by " + thread.Author
+		}
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       thread.Title,
+			Link:        link,
+			GUID:        link,
+			PubDate:     thread.Created.UTC().Format(time.RFC1123Z),
+			Category:    append([]string{thread.BoardName}, thread.Tags...),
+			Description: summary,
+		})
+	}
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(body, '\n')...), nil
+}
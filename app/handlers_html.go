@@ -1,29 +1,37 @@
 package app
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gorilla/mux"
 )
 
 // ------------------- HTML Handlers -------------------
 
-var reportCategories = []string{
-	"spam",
-	"harassment",
-	"illegal",
-	"off-topic",
-	"other",
-}
+// boardPreviewReplies is how many of the latest replies the board view shows per thread.
+const boardPreviewReplies = 3
+
+// reportCategories are the choices on the report form. Run replaces them
+// with JANK_REPORT_CATEGORIES when it's set.
+var reportCategories = defaultReportCategories()
+
+// reportLimitMessage explains errReportLimit to the reporter.
+var reportLimitMessage = fmt.Sprintf("You've sent %d reports in the last hour. Please wait before reporting more.", maxReportsPerHour)
+
+const indexTrendingLimit = 5
 
 // serveIndex executes index.html, showing a list of boards with links.
 func serveIndex(w http.ResponseWriter, r *http.Request) {
@@ -32,12 +40,26 @@ func serveIndex(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	boards, err := getAllBoards(db)
+	boards, err := getAllBoards(r.Context(), db)
 	if err != nil {
 		log.Errorf("Failed to retrieve boards: %v", err)
-		renderErrorPage(w, r, http.StatusInternalServerError, "Boards Unavailable", "Failed to load boards. Please try again.", "/")
+		renderStoreErrorPage(w, r, err, "Boards Unavailable", "Failed to load boards. Please try again.", "/")
+		return
+	}
+
+	hidden, err := hiddenBoardIDs(r.Context(), requestUsername(r))
+	if err != nil {
+		log.Errorf("Failed to check board access: %v", err)
+		renderStoreErrorPage(w, r, err, "Boards Unavailable", "Failed to load boards. Please try again.", "/")
 		return
 	}
+	boards = visibleBoards(boards, hidden)
+
+	// Trending is a nice-to-have; the board list still renders without it.
+	trending, err := getTrending(r.Context(), db, requestUsername(r), trendingWindow, indexTrendingLimit, 0)
+	if err != nil {
+		log.Errorf("Failed to load trending threads: %v", err)
+	}
 
 	authData := getAuthViewData(r)
 	data := IndexViewData{
@@ -45,6 +67,7 @@ func serveIndex(w http.ResponseWriter, r *http.Request) {
 		Title:        "Welcome to /jank/",
 		Description:  "Select a board below to view its threads.",
 		Boards:       boards,
+		Trending:     trending,
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -66,20 +89,21 @@ func serveSearch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if query != "" {
-		boards, err := searchBoards(db, query, 20)
+		viewer := requestUsername(r)
+		boards, err := searchBoards(r.Context(), db, viewer, query, 20)
 		if err != nil {
 			log.Errorf("Failed to search boards: %v", err)
-			renderErrorPage(w, r, http.StatusInternalServerError, "Search Unavailable", "Board search failed. Please try again.", "/")
+			renderStoreErrorPage(w, r, err, "Search Unavailable", "Board search failed. Please try again.", "/")
 			return
 		}
-		threads, err := searchThreads(db, query, 50)
+		threads, err := searchThreads(r.Context(), db, viewer, query, 50)
 		if err != nil {
 			log.Errorf("Failed to search threads: %v", err)
-			renderErrorPage(w, r, http.StatusInternalServerError, "Search Unavailable", "Thread search failed. Please try again.", "/")
+			renderStoreErrorPage(w, r, err, "Search Unavailable", "Thread search failed. Please try again.", "/")
 			return
 		}
-		data.Boards = boards
-		data.Threads = threads
+		data.Boards = append(data.Boards, boards...)
+		data.Threads = append(data.Threads, threads...)
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -98,50 +122,76 @@ func serveBoardView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	board, err := getBoardByID(db, boardID, true)
+	board, err := getBoardByID(r.Context(), db, boardID, false)
 	if err != nil {
 		log.Errorf("Board not found: %v", err)
 		renderErrorPage(w, r, http.StatusNotFound, "Board Not Found", "We couldn't find that board.", "/")
 		return
 	}
-	if board != nil {
-		cardTagPattern := regexp.MustCompile(`\[\[([^\]]+)\]\]`)
-		for _, thread := range board.Threads {
-			if thread == nil {
-				continue
-			}
-			thread.ReplyCount = 0
-			thread.LastBump = thread.Created
-			thread.CardTags = nil
+	if !requireBoardAccess(w, r, board, contentBoard) {
+		return
+	}
+	if redirectToCanonicalSlug(w, r, boardURL(board.ID, board.Name)) {
+		return
+	}
+	sortKey, ok := parseThreadSort(r.URL.Query().Get("sort"))
+	if !ok {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Sort", "Threads can be sorted by bump, new, replies, or tags.", fmt.Sprintf("/view/board/%d", boardID))
+		return
+	}
+	threads, err := getThreadsByBoardID(r.Context(), db, boardID, sortKey, false)
+	if err != nil {
+		log.Errorf("Failed to retrieve threads: %v", err)
+		renderStoreErrorPage(w, r, err, "Threads Unavailable", "We couldn't load this board's threads.", "/")
+		return
+	}
+	board.Threads = threads
+	previews, err := getBoardThreadPreviews(r.Context(), db, boardID, boardPreviewReplies)
+	if err != nil {
+		log.Errorf("Failed to load thread previews: %v", err)
+		renderStoreErrorPage(w, r, err, "Threads Unavailable", "We couldn't load this board's threads.", "/")
+		return
+	}
+
+	cardTagPattern := regexp.MustCompile(`\[\[([^\]]+)\]\]`)
+	for _, thread := range board.Threads {
+		if thread == nil {
+			continue
+		}
+		thread.CardTags = nil
 
-			if len(thread.Posts) == 0 {
+		preview := previews[thread.ID]
+		if preview == nil || preview.OP == nil {
+			continue
+		}
+		thread.Posts = append([]*Post{preview.OP}, preview.Tail...)
+		thread.Omitted = preview.Omitted
+		for _, post := range thread.Posts {
+			if strings.TrimSpace(post.Content) == "" {
 				continue
 			}
+			thread.Excerpt = makeExcerpt(post.Content, 180)
+			break
+		}
 
-			if len(thread.Posts) > 1 {
-				thread.ReplyCount = len(thread.Posts) - 1
+		opContent := preview.OP.Content
+		matches := cardTagPattern.FindAllStringSubmatch(opContent, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		seen := make(map[string]struct{})
+		for _, match := range matches {
+			tag := strings.TrimSpace(match[1])
+			if tag == "" {
+				continue
 			}
-			thread.LastBump = thread.Posts[len(thread.Posts)-1].Created
-
-			opContent := thread.Posts[0].Content
-			matches := cardTagPattern.FindAllStringSubmatch(opContent, -1)
-			if len(matches) == 0 {
+			if _, ok := seen[tag]; ok {
 				continue
 			}
-			seen := make(map[string]struct{})
-			for _, match := range matches {
-				tag := strings.TrimSpace(match[1])
-				if tag == "" {
-					continue
-				}
-				if _, ok := seen[tag]; ok {
-					continue
-				}
-				seen[tag] = struct{}{}
-				thread.CardTags = append(thread.CardTags, tag)
-				if len(thread.CardTags) >= 4 {
-					break
-				}
+			seen[tag] = struct{}{}
+			thread.CardTags = append(thread.CardTags, tag)
+			if len(thread.CardTags) >= 4 {
+				break
 			}
 		}
 	}
@@ -150,6 +200,8 @@ func serveBoardView(w http.ResponseWriter, r *http.Request) {
 	data := BoardViewData{
 		AuthViewData: authData,
 		Board:        board,
+		Sort:         sortKey,
+		SortOptions:  []string{"bump", "new", "replies", "tags"},
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -167,6 +219,15 @@ func serveNewThread(w http.ResponseWriter, r *http.Request) {
 		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Board", "That board ID is not valid.", "/")
 		return
 	}
+	board, err := getBoardByID(r.Context(), db, boardID, false)
+	if err != nil {
+		log.Errorf("Board not found: %v", err)
+		renderErrorPage(w, r, http.StatusNotFound, "Board Not Found", "We couldn't find that board.", "/")
+		return
+	}
+	if !requireBoardAccess(w, r, board, contentBoard) {
+		return
+	}
 
 	switch r.Method {
 	case http.MethodGet:
@@ -174,6 +235,7 @@ func serveNewThread(w http.ResponseWriter, r *http.Request) {
 		data := NewThreadViewData{
 			AuthViewData: authData,
 			BoardID:      boardID,
+			RequireTitle: board.RequireTitle,
 		}
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		if err := templates.ExecuteTemplate(w, "new_thread.html", data); err != nil {
@@ -191,12 +253,7 @@ func serveNewThread(w http.ResponseWriter, r *http.Request) {
 		}
 		treePayload, err := parseCardTreePayload(r.FormValue("tree_payload"))
 		if err != nil {
-			renderErrorPage(w, r, http.StatusBadRequest, "Invalid Tree Data", "We couldn't read your card tree details.", fmt.Sprintf("/view/board/newthread/%d", boardID))
-			return
-		}
-		title := strings.TrimSpace(r.FormValue("title"))
-		if title == "" {
-			renderErrorPage(w, r, http.StatusBadRequest, "Missing Title", "Thread title cannot be empty.", fmt.Sprintf("/view/board/newthread/%d", boardID))
+			renderErrorPage(w, r, http.StatusBadRequest, "Invalid Tree Data", cardTreePayloadErrorMessage(err), fmt.Sprintf("/view/board/newthread/%d", boardID))
 			return
 		}
 		tags, err := validateTags(parseTagsInput(r.FormValue("tags")))
@@ -218,26 +275,41 @@ func serveNewThread(w http.ResponseWriter, r *http.Request) {
 			renderErrorPage(w, r, http.StatusBadRequest, "Missing Post", "Thread content cannot be empty.", fmt.Sprintf("/view/board/newthread/%d", boardID))
 			return
 		}
+		title, err := resolveThreadTitle(board, r.FormValue("title"), content)
+		if err != nil {
+			renderErrorPage(w, r, http.StatusBadRequest, "Missing Title", "Thread title cannot be empty.", fmt.Sprintf("/view/board/newthread/%d", boardID))
+			return
+		}
 
-		thread, err := createThread(db, boardID, title, username, tags)
+		if err := checkPostCooldown(r.Context(), db, username); err != nil {
+			if status, title, message, ok := postRejection(err); ok {
+				renderErrorPage(w, r, status, title, message, fmt.Sprintf("/view/board/newthread/%d", boardID))
+				return
+			}
+			log.Errorf("Failed to check post cooldown: %v", err)
+			renderStoreErrorPage(w, r, err, "Create Thread Failed", "We couldn't create that thread. Please try again.", fmt.Sprintf("/view/board/%d", boardID))
+			return
+		}
+		thread, err := createThread(r.Context(), db, boardID, title, username, tags)
 		if err != nil {
 			log.Errorf("Failed to create thread: %v", err)
-			renderErrorPage(w, r, http.StatusInternalServerError, "Create Thread Failed", "We couldn't create that thread. Please try again.", fmt.Sprintf("/view/board/%d", boardID))
+			renderStoreErrorPage(w, r, err, "Create Thread Failed", "We couldn't create that thread. Please try again.", fmt.Sprintf("/view/board/%d", boardID))
 			return
 		}
-		post, err := createPost(db, thread.ID, username, content)
+		post, err := createPost(withPosterIP(r), db, thread.ID, username, content, "")
 		if err != nil {
 			log.Errorf("Failed to create starter post: %v", err)
-			renderErrorPage(w, r, http.StatusInternalServerError, "Post Failed", "We couldn't save your post. Please try again.", fmt.Sprintf("/view/board/%d", boardID))
+			renderStoreErrorPage(w, r, err, "Post Failed", "We couldn't save your post. Please try again.", fmt.Sprintf("/view/board/%d", boardID))
 			return
 		}
-		if err := applyCardTreePayload(db, "post", post.ID, username, treePayload); err != nil {
+		if err := applyCardTreePayload(r.Context(), db, "post", post.ID, username, treePayload); err != nil {
 			log.Errorf("Failed to create card tree: %v", err)
 			renderErrorPage(w, r, http.StatusBadRequest, "Tree Create Failed", "We couldn't save your card trees. Please review and try again.", fmt.Sprintf("/view/board/newthread/%d", boardID))
 			return
 		}
 
 		log.Infof("Created thread: ID=%d, Title=%s, BoardID=%d", thread.ID, thread.Title, boardID)
+		emitThreadCreated(thread, boardID)
 		http.Redirect(w, r, fmt.Sprintf("/view/board/%d", boardID), http.StatusSeeOther)
 
 	default:
@@ -245,6 +317,64 @@ func serveNewThread(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// serveBoardBySlug redirects /b/{slug} to the board whose name has that slug.
+func serveBoardBySlug(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+	boards, err := getAllBoards(r.Context(), db)
+	if err != nil {
+		log.Errorf("Failed to retrieve boards: %v", err)
+		renderStoreErrorPage(w, r, err, "Boards Unavailable", "We couldn't load the boards.", "/")
+		return
+	}
+	for _, board := range boards {
+		if urlSlug(board.Name) != slug {
+			continue
+		}
+		if !requireBoardAccess(w, r, board, contentBoard) {
+			return
+		}
+		http.Redirect(w, r, boardURL(board.ID, board.Name), http.StatusMovedPermanently)
+		return
+	}
+	renderErrorPage(w, r, http.StatusNotFound, "Board Not Found", "We couldn't find that board.", "/")
+}
+
+// serveBoardByName serves /{boardName}/ as the board view for the board with
+// that short code.
+func serveBoardByName(w http.ResponseWriter, r *http.Request) {
+	board, err := getBoardByCode(r.Context(), db, mux.Vars(r)["boardName"])
+	if err != nil {
+		renderErrorPage(w, r, http.StatusNotFound, "Board Not Found", "We couldn't find that board.", "/")
+		return
+	}
+	serveBoardView(w, mux.SetURLVars(r, map[string]string{"boardID": strconv.Itoa(board.ID)}))
+}
+
+// serveBoardThreadByName serves /{boardName}/thread/{threadID} as the thread
+// view, as long as the thread is on that board.
+func serveBoardThreadByName(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	board, err := getBoardByCode(r.Context(), db, vars["boardName"])
+	if err != nil {
+		renderErrorPage(w, r, http.StatusNotFound, "Board Not Found", "We couldn't find that board.", "/")
+		return
+	}
+	if !requireBoardAccess(w, r, board, contentBoard) {
+		return
+	}
+	threadID, err := strconv.Atoi(vars["threadID"])
+	if err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Thread", "That thread ID is not valid.", "/")
+		return
+	}
+	threadBoard, err := getThreadBoard(r.Context(), db, threadID)
+	if err != nil || threadBoard.ID != board.ID {
+		renderErrorPage(w, r, http.StatusNotFound, "Thread Not Found", "We couldn't find that thread.", boardURL(board.ID, board.Name))
+		return
+	}
+	serveThreadView(w, mux.SetURLVars(r, map[string]string{"threadID": vars["threadID"]}))
+}
+
 // serveThreadView handles both displaying a thread and adding new posts.
 func serveThreadView(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -254,36 +384,89 @@ func serveThreadView(w http.ResponseWriter, r *http.Request) {
 		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Thread", "That thread ID is not valid.", "/")
 		return
 	}
+	board, err := getThreadBoard(r.Context(), db, threadID)
+	if err != nil {
+		log.Errorf("Thread not found: %v", err)
+		renderErrorPage(w, r, http.StatusNotFound, "Thread Not Found", "We couldn't find that thread.", "/")
+		return
+	}
+	if !requireBoardAccess(w, r, board, contentThread) {
+		return
+	}
 
 	if r.Method == http.MethodGet {
-		thread, boardID, err := getThreadByID(db, threadID)
+		thread, boardID, err := getThreadByID(r.Context(), db, threadID)
 		if err != nil {
 			log.Errorf("Thread not found: %v", err)
 			renderErrorPage(w, r, http.StatusNotFound, "Thread Not Found", "We couldn't find that thread.", "/")
 			return
 		}
-
-		lastBump := thread.Created
-		if len(thread.Posts) > 0 {
-			lastBump = thread.Posts[len(thread.Posts)-1].Created
+		if redirectToCanonicalSlug(w, r, threadURL(thread.ID, thread.Title)) {
+			return
 		}
-		const bumpCooldown = 3 * time.Minute
-		const necroThreshold = 30 * 24 * time.Hour
-		sinceBump := time.Since(lastBump)
-		bumpCooldownRemaining := 0
-		if sinceBump >= 0 && sinceBump < bumpCooldown {
-			bumpCooldownRemaining = int(bumpCooldown.Seconds() - sinceBump.Seconds())
+
+		lastBump := thread.LastBump
+		necroAfter := boardNecroThreshold(board)
+		necroWarning := necroAfter > 0 && time.Since(lastBump) > necroAfter
+		if err := resolvePostLinks(r.Context(), db, thread.Posts); err != nil {
+			log.Errorf("Failed to resolve post links: %v", err)
 		}
-		necroWarning := sinceBump > necroThreshold
+		toc := threadTOC(thread)
 		authData := getAuthViewData(r)
+		markPostReplies(thread.Posts, authData.Username)
+		markCollapsiblePosts(thread.Posts)
+		sortMode := ""
+		if postVotesEnabled {
+			postIDs := make([]int, 0, len(thread.Posts))
+			for _, post := range thread.Posts {
+				postIDs = append(postIDs, post.ID)
+			}
+			votes, err := getUserPostVotes(r.Context(), db, authData.Username, postIDs)
+			if err != nil {
+				log.Errorf("Failed to load votes: %v", err)
+			}
+			for _, post := range thread.Posts {
+				post.MyVote = votes[post.ID]
+			}
+			if r.URL.Query().Get("sort") == "top" {
+				sortMode = "top"
+				sortRepliesByScore(thread.Posts)
+			}
+		}
+		var acceptedPost *Post
+		if thread.AcceptedPostID != nil {
+			for _, post := range thread.Posts {
+				if post.ID == *thread.AcceptedPostID && !post.IsDeleted {
+					post.IsAccepted = true
+					acceptedPost = post
+					break
+				}
+			}
+		}
+		slowModeWait, err := slowModeRemaining(r.Context(), db, threadID, authData.Username)
+		if err != nil {
+			log.Errorf("Failed to check slow mode: %v", err)
+		}
 		data := ThreadViewData{
 			AuthViewData:          authData,
 			Thread:                thread,
 			BoardID:               boardID,
 			LastBump:              lastBump,
-			BumpCooldownRemaining: bumpCooldownRemaining,
+			BumpCooldownRemaining: bumpCooldownRemaining(lastBump, time.Now()),
 			NecroWarning:          necroWarning,
+			NecroThreshold:        necroAfter,
 			ReportCategories:      reportCategories,
+			ShowPostEmail:         showPostEmail,
+			AcceptedPost:          acceptedPost,
+			CanAcceptAnswer:       authData.IsAuthenticated && thread.Author != "" && authData.Username == thread.Author,
+			VotesEnabled:          postVotesEnabled,
+			Sort:                  sortMode,
+			TOC:                   toc,
+			SlowModeInterval:      formatSlowModeInterval(thread.SlowModeSeconds),
+			SlowModeRemaining:     int(slowModeWait.Seconds()),
+			CanSetSlowMode:        authData.IsModerator || (thread.Author != "" && authData.Username == thread.Author),
+			PostNumbering:         board.PostNumbering,
+			AnonName:              board.AnonLabel(),
 		}
 
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -304,7 +487,7 @@ func serveThreadView(w http.ResponseWriter, r *http.Request) {
 		}
 		treePayload, err := parseCardTreePayload(r.FormValue("tree_payload"))
 		if err != nil {
-			renderErrorPage(w, r, http.StatusBadRequest, "Invalid Tree Data", "We couldn't read your card tree details.", fmt.Sprintf("/view/thread/%d", threadID))
+			renderErrorPage(w, r, http.StatusBadRequest, "Invalid Tree Data", cardTreePayloadErrorMessage(err), fmt.Sprintf("/view/thread/%d", threadID))
 			return
 		}
 		content := strings.TrimSpace(r.FormValue("content"))
@@ -313,14 +496,21 @@ func serveThreadView(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		author := username
-		post, err := createPost(db, threadID, author, content)
+		ctx := withPosterIP(r)
+		if r.FormValue("anonymous") != "" {
+			ctx = withAnonymousPost(ctx)
+		}
+		post, err := createPost(ctx, db, threadID, username, content, r.FormValue("email"))
 		if err != nil {
+			if status, title, message, ok := postRejection(err); ok {
+				renderErrorPage(w, r, status, title, message, fmt.Sprintf("/view/thread/%d", threadID))
+				return
+			}
 			log.Errorf("Failed to create post: %v", err)
-			renderErrorPage(w, r, http.StatusInternalServerError, "Post Failed", "We couldn't create that reply. Please try again.", fmt.Sprintf("/view/thread/%d", threadID))
+			renderStoreErrorPage(w, r, err, "Post Failed", "We couldn't create that reply. Please try again.", fmt.Sprintf("/view/thread/%d", threadID))
 			return
 		}
-		if err := applyCardTreePayload(db, "post", post.ID, username, treePayload); err != nil {
+		if err := applyCardTreePayload(r.Context(), db, "post", post.ID, username, treePayload); err != nil {
 			log.Errorf("Failed to create card tree: %v", err)
 			renderErrorPage(w, r, http.StatusBadRequest, "Tree Create Failed", "We couldn't save your card trees. Please review and try again.", fmt.Sprintf("/view/thread/%d", threadID))
 			return
@@ -348,6 +538,9 @@ func reportPostHandler(w http.ResponseWriter, r *http.Request) {
 		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Post", "That post ID is not valid.", "/")
 		return
 	}
+	if !requireContentAccess(w, r, contentPost, postID) {
+		return
+	}
 	if err := r.ParseForm(); err != nil {
 		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Form", "We couldn't read that report.", "/")
 		return
@@ -359,13 +552,19 @@ func reportPostHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	reason := strings.TrimSpace(r.FormValue("reason"))
 	username, _ := getAuthenticatedUsername(r)
-	if _, err := createReport(db, postID, category, reason, username); err != nil {
+	report, err := createReport(r.Context(), db, postID, category, reason, username)
+	if err != nil {
+		if errors.Is(err, errReportLimit) {
+			renderErrorPage(w, r, http.StatusTooManyRequests, "Too Many Reports", reportLimitMessage, "/")
+			return
+		}
 		log.Errorf("Failed to create report: %v", err)
-		renderErrorPage(w, r, http.StatusInternalServerError, "Report Failed", "We couldn't send that report.", "/")
+		renderStoreErrorPage(w, r, err, "Report Failed", "We couldn't send that report.", "/")
 		return
 	}
+	emitWebhook(webhookReportCreated, report)
 
-	threadID, err := getPostThreadID(db, postID)
+	threadID, err := getPostThreadID(r.Context(), db, postID)
 	if err != nil {
 		renderErrorPage(w, r, http.StatusNotFound, "Post Not Found", "We couldn't find that post.", "/")
 		return
@@ -381,10 +580,10 @@ func serveModReports(w http.ResponseWriter, r *http.Request) {
 	if !requireModerator(w, r) {
 		return
 	}
-	reports, err := getOpenReports(db)
+	reports, err := getOpenReports(r.Context(), db)
 	if err != nil {
 		log.Errorf("Failed to load reports: %v", err)
-		renderErrorPage(w, r, http.StatusInternalServerError, "Queue Unavailable", "We couldn't load the report queue.", "/")
+		renderStoreErrorPage(w, r, err, "Queue Unavailable", "We couldn't load the report queue.", "/")
 		return
 	}
 
@@ -399,6 +598,64 @@ func serveModReports(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+const (
+	defaultDashboardDays = 7
+	maxDashboardDays     = 90
+)
+
+// serveModDashboard shows moderators where open reports are piling up and
+// how quickly they've been handled over the last ?days= days.
+func serveModDashboard(w http.ResponseWriter, r *http.Request) {
+	if !requireModerator(w, r) {
+		return
+	}
+	days := defaultDashboardDays
+	if raw := strings.TrimSpace(r.URL.Query().Get("days")); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxDashboardDays {
+			renderErrorPage(w, r, http.StatusBadRequest, "Invalid Window", fmt.Sprintf("Pick a window between 1 and %d days.", maxDashboardDays), "/mod/dashboard")
+			return
+		}
+		days = parsed
+	}
+	stats, err := getReportStats(r.Context(), db, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		log.Errorf("Failed to load report stats: %v", err)
+		renderStoreErrorPage(w, r, err, "Dashboard Unavailable", "We couldn't load the report stats.", "/mod/reports")
+		return
+	}
+
+	data := ModDashboardViewData{
+		AuthViewData: getAuthViewData(r),
+		Stats:        stats,
+		WindowDays:   days,
+	}
+	if stats.OldestOpen != nil {
+		data.OldestOpenAge = formatAge(time.Since(*stats.OldestOpen))
+	}
+	if stats.ResolvedInWindow > 0 {
+		data.AvgResolution = formatAge(stats.AvgResolution)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ExecuteTemplate(w, "mod_dashboard.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// formatAge renders a duration compactly, such as "45m", "6h", or "3d".
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "<1m"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
+
 func serveBoardAdminList(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		renderErrorPage(w, r, http.StatusMethodNotAllowed, "Not Allowed", "That action isn't supported here.", "/")
@@ -407,10 +664,10 @@ func serveBoardAdminList(w http.ResponseWriter, r *http.Request) {
 	if !requireModerator(w, r) {
 		return
 	}
-	boards, err := getAllBoards(db)
+	boards, err := getAllBoards(r.Context(), db)
 	if err != nil {
 		log.Errorf("Failed to retrieve boards: %v", err)
-		renderErrorPage(w, r, http.StatusInternalServerError, "Boards Unavailable", "We couldn't load the boards list.", "/")
+		renderStoreErrorPage(w, r, err, "Boards Unavailable", "We couldn't load the boards list.", "/")
 		return
 	}
 
@@ -434,8 +691,8 @@ func serveBoardAdminCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var message string
-	board := &Board{}
+	var message, membersInput string
+	board := &Board{Visibility: boardVisibilityPublic, PostNumbering: postNumberingGlobal, RequireTitle: true}
 
 	if r.Method == http.MethodPost {
 		if err := r.ParseForm(); err != nil {
@@ -444,13 +701,54 @@ func serveBoardAdminCreate(w http.ResponseWriter, r *http.Request) {
 		}
 		name := strings.TrimSpace(r.FormValue("name"))
 		description := strings.TrimSpace(r.FormValue("description"))
+		rules := strings.TrimSpace(r.FormValue("rules"))
+		members := parseBoardMembers(r.FormValue("members"))
 		board.Name = name
 		board.Description = description
+		board.Rules = rules
+		board.Visibility = normalizeBoardVisibility(r.FormValue("visibility"))
+		board.PostNumbering = normalizePostNumbering(r.FormValue("post_numbering"))
+		board.AnonName = normalizeAnonName(r.FormValue("anon_name"))
+		board.RequireTitle = r.FormValue("require_title") != ""
+		necroDays, necroErr := parseNecroDays(r.FormValue("necro_days"))
+		board.NecroDays = necroDays
+		retentionDays, retentionErr := parseRetentionDays(r.FormValue("retention_days"))
+		board.RetentionDays = retentionDays
+		membersInput = strings.Join(members, "\n")
 		if name == "" {
 			message = "Board name cannot be empty."
-		} else if _, err := createBoard(db, name, description); err != nil {
+		} else if necroErr != nil {
+			message = "Necro warning days must be a whole number, 0 or more."
+		} else if retentionErr != nil {
+			message = "Retention days must be a whole number, 0 or more."
+		} else if created, err := createBoard(r.Context(), db, name, description); errors.Is(err, errBoardNameTaken) {
+			message = "A board with that name already exists."
+		} else if errors.Is(err, errBoardLimitReached) {
+			message = fmt.Sprintf("This site is limited to %d boards. Delete one before adding another.", maxBoards)
+		} else if err != nil {
 			log.Errorf("Failed to create board: %v", err)
 			message = "Failed to create the board."
+		} else if err := setBoardRules(r.Context(), db, created.ID, rules); err != nil {
+			log.Errorf("Failed to set board rules: %v", err)
+			message = "The board was created, but its rules couldn't be saved."
+		} else if err := setBoardAccess(r.Context(), db, created.ID, board.Visibility, members); err != nil {
+			log.Errorf("Failed to set board access: %v", err)
+			message = "The board was created, but its visibility couldn't be saved."
+		} else if err := setBoardPostNumbering(r.Context(), db, created.ID, board.PostNumbering); err != nil {
+			log.Errorf("Failed to set board post numbering: %v", err)
+			message = "The board was created, but its post numbering couldn't be saved."
+		} else if err := setBoardAnonName(r.Context(), db, created.ID, board.AnonName); err != nil {
+			log.Errorf("Failed to set board anonymous name: %v", err)
+			message = "The board was created, but its anonymous name couldn't be saved."
+		} else if err := setBoardRequireTitle(r.Context(), db, created.ID, board.RequireTitle); err != nil {
+			log.Errorf("Failed to set board title requirement: %v", err)
+			message = "The board was created, but its title requirement couldn't be saved."
+		} else if err := setBoardNecroDays(r.Context(), db, created.ID, board.NecroDays); err != nil {
+			log.Errorf("Failed to set board necro threshold: %v", err)
+			message = "The board was created, but its necro warning couldn't be saved."
+		} else if err := setBoardRetentionDays(r.Context(), db, created.ID, board.RetentionDays); err != nil {
+			log.Errorf("Failed to set board retention: %v", err)
+			message = "The board was created, but its retention couldn't be saved."
 		} else {
 			http.Redirect(w, r, "/mod/boards", http.StatusSeeOther)
 			return
@@ -461,6 +759,7 @@ func serveBoardAdminCreate(w http.ResponseWriter, r *http.Request) {
 	data := BoardAdminFormViewData{
 		AuthViewData: authData,
 		Board:        board,
+		Members:      membersInput,
 		Error:        message,
 		IsEdit:       false,
 	}
@@ -486,12 +785,18 @@ func serveBoardAdminEdit(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var message string
-	board, err := getBoardByID(db, boardID, false)
+	board, err := getBoardByID(r.Context(), db, boardID, false)
 	if err != nil {
 		log.Errorf("Board not found: %v", err)
 		renderErrorPage(w, r, http.StatusNotFound, "Board Not Found", "We couldn't find that board.", "/mod/boards")
 		return
 	}
+	members, err := getBoardMembers(r.Context(), db, boardID)
+	if err != nil {
+		log.Errorf("Failed to load board members: %v", err)
+		renderStoreErrorPage(w, r, err, "Board Unavailable", "We couldn't load that board's members.", "/mod/boards")
+		return
+	}
 
 	if r.Method == http.MethodPost {
 		if err := r.ParseForm(); err != nil {
@@ -500,13 +805,51 @@ func serveBoardAdminEdit(w http.ResponseWriter, r *http.Request) {
 		}
 		name := strings.TrimSpace(r.FormValue("name"))
 		description := strings.TrimSpace(r.FormValue("description"))
+		rules := strings.TrimSpace(r.FormValue("rules"))
+		members = parseBoardMembers(r.FormValue("members"))
 		board.Name = name
 		board.Description = description
+		board.Rules = rules
+		board.Visibility = normalizeBoardVisibility(r.FormValue("visibility"))
+		board.PostNumbering = normalizePostNumbering(r.FormValue("post_numbering"))
+		board.AnonName = normalizeAnonName(r.FormValue("anon_name"))
+		board.RequireTitle = r.FormValue("require_title") != ""
+		necroDays, necroErr := parseNecroDays(r.FormValue("necro_days"))
+		board.NecroDays = necroDays
+		retentionDays, retentionErr := parseRetentionDays(r.FormValue("retention_days"))
+		board.RetentionDays = retentionDays
 		if name == "" {
 			message = "Board name cannot be empty."
-		} else if err := updateBoardByID(db, boardID, name, description); err != nil {
+		} else if necroErr != nil {
+			message = "Necro warning days must be a whole number, 0 or more."
+		} else if retentionErr != nil {
+			message = "Retention days must be a whole number, 0 or more."
+		} else if err := updateBoardByID(r.Context(), db, boardID, name, description); errors.Is(err, errBoardNameTaken) {
+			message = "A board with that name already exists."
+		} else if err != nil {
 			log.Errorf("Failed to update board: %v", err)
 			message = "Failed to update the board."
+		} else if err := setBoardRules(r.Context(), db, boardID, rules); err != nil {
+			log.Errorf("Failed to set board rules: %v", err)
+			message = "Failed to update the board's rules."
+		} else if err := setBoardAccess(r.Context(), db, boardID, board.Visibility, members); err != nil {
+			log.Errorf("Failed to set board access: %v", err)
+			message = "Failed to update the board's visibility."
+		} else if err := setBoardPostNumbering(r.Context(), db, boardID, board.PostNumbering); err != nil {
+			log.Errorf("Failed to set board post numbering: %v", err)
+			message = "Failed to update the board's post numbering."
+		} else if err := setBoardAnonName(r.Context(), db, boardID, board.AnonName); err != nil {
+			log.Errorf("Failed to set board anonymous name: %v", err)
+			message = "Failed to update the board's anonymous name."
+		} else if err := setBoardRequireTitle(r.Context(), db, boardID, board.RequireTitle); err != nil {
+			log.Errorf("Failed to set board title requirement: %v", err)
+			message = "Failed to update the board's title requirement."
+		} else if err := setBoardNecroDays(r.Context(), db, boardID, board.NecroDays); err != nil {
+			log.Errorf("Failed to set board necro threshold: %v", err)
+			message = "Failed to update the board's necro warning."
+		} else if err := setBoardRetentionDays(r.Context(), db, boardID, board.RetentionDays); err != nil {
+			log.Errorf("Failed to set board retention: %v", err)
+			message = "Failed to update the board's retention."
 		} else {
 			http.Redirect(w, r, "/mod/boards", http.StatusSeeOther)
 			return
@@ -517,6 +860,7 @@ func serveBoardAdminEdit(w http.ResponseWriter, r *http.Request) {
 	data := BoardAdminFormViewData{
 		AuthViewData: authData,
 		Board:        board,
+		Members:      strings.Join(members, "\n"),
 		Error:        message,
 		IsEdit:       true,
 	}
@@ -540,14 +884,149 @@ func serveBoardAdminDelete(w http.ResponseWriter, r *http.Request) {
 		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Board", "That board ID is not valid.", "/mod/boards")
 		return
 	}
-	if err := deleteBoardByID(db, boardID); err != nil {
+	if err := deleteBoardByID(r.Context(), db, boardID); err != nil {
 		log.Errorf("Failed to delete board: %v", err)
-		renderErrorPage(w, r, http.StatusInternalServerError, "Delete Failed", "We couldn't delete that board.", "/mod/boards")
+		renderStoreErrorPage(w, r, err, "Delete Failed", "We couldn't delete that board.", "/mod/boards")
 		return
 	}
 	http.Redirect(w, r, "/mod/boards", http.StatusSeeOther)
 }
 
+func serveUserAdminList(w http.ResponseWriter, r *http.Request) {
+	if !requireModerator(w, r) {
+		return
+	}
+	users, err := listUsers(r.Context(), db)
+	if err != nil {
+		log.Errorf("Failed to list users: %v", err)
+		renderStoreErrorPage(w, r, err, "Users Unavailable", "We couldn't load the user list.", "/")
+		return
+	}
+
+	authData := getAuthViewData(r)
+	data := UserAdminListViewData{
+		AuthViewData: authData,
+		Users:        users,
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ExecuteTemplate(w, "mod_users.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// userAdminTarget reads the {username} a moderator is acting on, refusing
+// the moderator's own account.
+func userAdminTarget(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if !requireModerator(w, r) {
+		return "", false
+	}
+	username := mux.Vars(r)["username"]
+	if isModerator(username) {
+		renderErrorPage(w, r, http.StatusBadRequest, "Not Allowed", "You can't change the moderator account from here.", "/mod/users")
+		return "", false
+	}
+	if err := r.ParseForm(); err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Form", "We couldn't read that request.", "/mod/users")
+		return "", false
+	}
+	return username, true
+}
+
+// disableUserHandler turns a user's logins off (disabled=true) or back on.
+func disableUserHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := userAdminTarget(w, r)
+	if !ok {
+		return
+	}
+	disabled := r.FormValue("disabled") != "false"
+	if err := setUserDisabled(r.Context(), db, username, disabled); err != nil {
+		log.Errorf("Failed to update user: %v", err)
+		renderStoreErrorPage(w, r, err, "Update Failed", "We couldn't update that user.", "/mod/users")
+		return
+	}
+	log.Infof("User %s disabled=%t by %s", username, disabled, auth.Username)
+	http.Redirect(w, r, "/mod/users", http.StatusSeeOther)
+}
+
+// resetUserPasswordHandler sets a new password for a user.
+func resetUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := userAdminTarget(w, r)
+	if !ok {
+		return
+	}
+	password := r.FormValue("password")
+	if err := checkPassword(password); err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Password", capitalize(err.Error())+".", "/mod/users")
+		return
+	}
+	if err := resetUserPassword(r.Context(), db, username, password); err != nil {
+		log.Errorf("Failed to reset password: %v", err)
+		renderStoreErrorPage(w, r, err, "Reset Failed", "We couldn't reset that password.", "/mod/users")
+		return
+	}
+	log.Infof("Password for %s reset by %s", username, auth.Username)
+	http.Redirect(w, r, "/mod/users", http.StatusSeeOther)
+}
+
+// deleteUserHandler deletes an account, keeping its posts under [deleted].
+func deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := userAdminTarget(w, r)
+	if !ok {
+		return
+	}
+	if err := deleteUser(r.Context(), db, username); err != nil {
+		log.Errorf("Failed to delete user: %v", err)
+		renderStoreErrorPage(w, r, err, "Delete Failed", "We couldn't delete that user.", "/mod/users")
+		return
+	}
+	log.Infof("User %s deleted by %s", username, auth.Username)
+	http.Redirect(w, r, "/mod/users", http.StatusSeeOther)
+}
+
+// userModNotesHandler lists a user's moderator notes as JSON (GET) or adds
+// one from the "note" form field (POST), then returns to their profile.
+func userModNotesHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireModerator(w, r) {
+		return
+	}
+	username := mux.Vars(r)["username"]
+	profileURL := "/user/" + url.PathEscape(username)
+	if _, err := getUserByUsername(r.Context(), db, username); err != nil {
+		renderErrorPage(w, r, http.StatusNotFound, "User Not Found", "We couldn't find that user.", "/mod/users")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			renderErrorPage(w, r, http.StatusBadRequest, "Invalid Form", "We couldn't read that note.", profileURL)
+			return
+		}
+		body := strings.TrimSpace(r.FormValue("note"))
+		if body == "" || utf8.RuneCountInString(body) > maxModNoteLength {
+			renderErrorPage(w, r, http.StatusBadRequest, "Invalid Note", fmt.Sprintf("Notes must be 1 to %d characters.", maxModNoteLength), profileURL)
+			return
+		}
+		moderator, _ := getAuthenticatedUsername(r)
+		if _, err := createUserModNote(r.Context(), db, username, body, moderator); err != nil {
+			log.Errorf("Failed to add moderator note: %v", err)
+			renderStoreErrorPage(w, r, err, "Note Failed", "We couldn't save that note.", profileURL)
+			return
+		}
+		log.Infof("Moderator %s added a note on %s", moderator, username)
+		http.Redirect(w, r, profileURL+"#mod-notes", http.StatusSeeOther)
+		return
+	}
+
+	notes, err := getUserModNotes(r.Context(), db, username)
+	if err != nil {
+		log.Errorf("Failed to load moderator notes: %v", err)
+		respondStoreError(w, err, "Failed to load notes")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, r, notes)
+}
+
 func serveKlaxonAdmin(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet && r.Method != http.MethodPost {
 		renderErrorPage(w, r, http.StatusMethodNotAllowed, "Not Allowed", "That action isn't supported here.", "/")
@@ -560,13 +1039,14 @@ func serveKlaxonAdmin(w http.ResponseWriter, r *http.Request) {
 	var message string
 	var success string
 
+	actor, _ := getAuthenticatedUsername(r)
 	if r.Method == http.MethodPost {
 		if err := r.ParseForm(); err != nil {
 			renderErrorPage(w, r, http.StatusBadRequest, "Invalid Form", "We couldn't read that klaxon update.", "/mod/klaxon")
 			return
 		}
 		if r.FormValue("clear") != "" {
-			if err := saveKlaxon(db, "", "", "", time.Now()); err != nil {
+			if err := saveKlaxon(r.Context(), db, "", "", "", actor, time.Now()); err != nil {
 				log.Errorf("Failed to clear klaxon: %v", err)
 				message = "Failed to clear the klaxon."
 			} else {
@@ -578,7 +1058,7 @@ func serveKlaxonAdmin(w http.ResponseWriter, r *http.Request) {
 			body := strings.TrimSpace(r.FormValue("message"))
 			if body == "" {
 				message = "Klaxon message cannot be empty."
-			} else if err := saveKlaxon(db, tone, emoji, body, time.Now()); err != nil {
+			} else if err := saveKlaxon(r.Context(), db, tone, emoji, body, actor, time.Now()); err != nil {
 				log.Errorf("Failed to save klaxon: %v", err)
 				message = "Failed to save the klaxon."
 			} else {
@@ -587,10 +1067,16 @@ func serveKlaxonAdmin(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	klaxon, err := getKlaxon(db)
+	klaxon, err := getKlaxon(r.Context(), db)
 	if err != nil {
 		log.Errorf("Failed to load klaxon: %v", err)
-		renderErrorPage(w, r, http.StatusInternalServerError, "Klaxon Unavailable", "We couldn't load the klaxon settings.", "/")
+		renderStoreErrorPage(w, r, err, "Klaxon Unavailable", "We couldn't load the klaxon settings.", "/")
+		return
+	}
+	history, err := getKlaxonHistory(r.Context(), db, klaxonHistoryShown)
+	if err != nil {
+		log.Errorf("Failed to load klaxon history: %v", err)
+		renderStoreErrorPage(w, r, err, "Klaxon Unavailable", "We couldn't load the klaxon history.", "/")
 		return
 	}
 
@@ -598,6 +1084,7 @@ func serveKlaxonAdmin(w http.ResponseWriter, r *http.Request) {
 	data := KlaxonAdminViewData{
 		AuthViewData: authData,
 		Klaxon:       klaxon,
+		History:      history,
 		Error:        message,
 		Success:      success,
 	}
@@ -626,10 +1113,11 @@ func resolveReportHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	note := strings.TrimSpace(r.FormValue("note"))
+	lockThread := r.FormValue("lock_thread") == "true"
 	username, _ := getAuthenticatedUsername(r)
-	if err := resolveReport(db, reportID, username, note); err != nil {
+	if err := resolveReport(r.Context(), db, reportID, username, note, lockThread); err != nil {
 		log.Errorf("Failed to resolve report: %v", err)
-		renderErrorPage(w, r, http.StatusInternalServerError, "Resolve Failed", "We couldn't resolve that report.", "/mod/reports")
+		renderStoreErrorPage(w, r, err, "Resolve Failed", "We couldn't resolve that report.", "/mod/reports")
 		return
 	}
 	http.Redirect(w, r, "/mod/reports", http.StatusSeeOther)
@@ -659,14 +1147,15 @@ func deletePostHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	username, _ := getAuthenticatedUsername(r)
-	if err := softDeletePost(db, postID, username, reason); err != nil {
+	if err := softDeletePost(r.Context(), db, postID, username, reason); err != nil {
 		log.Errorf("Failed to delete post: %v", err)
-		renderErrorPage(w, r, http.StatusInternalServerError, "Delete Failed", "We couldn't remove that post.", "/")
+		renderStoreErrorPage(w, r, err, "Delete Failed", "We couldn't remove that post.", "/")
 		return
 	}
+	emitWebhook(webhookPostDeleted, webhookPostDeletion{PostID: postID, DeletedBy: username, Reason: reason})
 	next := sanitizeNext(r.FormValue("next"))
 	if next == "" {
-		threadID, err := getPostThreadID(db, postID)
+		threadID, err := getPostThreadID(r.Context(), db, postID)
 		if err == nil {
 			next = fmt.Sprintf("/view/thread/%d", threadID)
 		} else {
@@ -676,23 +1165,574 @@ func deletePostHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, next, http.StatusSeeOther)
 }
 
-type cardTreePayload struct {
-	Trees []cardTreePayloadTree `json:"trees"`
-}
-
-type cardTreePayloadTree struct {
-	Title       string                `json:"title"`
-	Description string                `json:"description"`
-	IsPrimary   bool                  `json:"is_primary"`
-	Nodes       []cardTreePayloadNode `json:"nodes"`
-}
+// bulkDeletePostsHandler soft-deletes several posts for one shared reason.
+// IDs come from repeated post_id fields and/or a post_ids list separated by
+// commas or whitespace. If any post can't be deleted, none are.
+func bulkDeletePostsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireModerator(w, r) {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Form", "We couldn't read that deletion.", "/mod/reports")
+		return
+	}
+	next := sanitizeNext(r.FormValue("next"))
+	if next == "" {
+		next = "/mod/reports"
+	}
+	raw := append([]string{}, r.Form["post_id"]...)
+	raw = append(raw, strings.FieldsFunc(r.FormValue("post_ids"), func(c rune) bool {
+		return c == ',' || unicode.IsSpace(c)
+	})...)
+	var postIDs []int
+	seen := make(map[int]bool)
+	for _, entry := range raw {
+		postID, err := strconv.Atoi(strings.TrimSpace(entry))
+		if err != nil || postID <= 0 {
+			renderErrorPage(w, r, http.StatusBadRequest, "Invalid Post", fmt.Sprintf("%q is not a valid post ID.", entry), next)
+			return
+		}
+		if !seen[postID] {
+			seen[postID] = true
+			postIDs = append(postIDs, postID)
+		}
+	}
+	if len(postIDs) == 0 {
+		renderErrorPage(w, r, http.StatusBadRequest, "No Posts", "Choose at least one post to remove.", next)
+		return
+	}
+	if len(postIDs) > maxBulkDeletePosts {
+		renderErrorPage(w, r, http.StatusBadRequest, "Too Many Posts", fmt.Sprintf("Remove at most %d posts at a time.", maxBulkDeletePosts), next)
+		return
+	}
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		renderErrorPage(w, r, http.StatusBadRequest, "Missing Reason", "Please add a reason for the removal.", next)
+		return
+	}
 
-type cardTreePayloadNode struct {
-	TempID       string                      `json:"temp_id"`
-	ParentTempID *string                     `json:"parent_temp_id"`
-	CardName     string                      `json:"card_name"`
-	Position     int                         `json:"position"`
-	Annotations  []cardTreePayloadAnnotation `json:"annotations"`
+	username, _ := getAuthenticatedUsername(r)
+	if err := softDeletePosts(r.Context(), db, postIDs, username, reason); errors.Is(err, errPostNotDeletable) {
+		renderErrorPage(w, r, http.StatusBadRequest, "Delete Failed", fmt.Sprintf("Nothing was removed: %v.", err), next)
+		return
+	} else if err != nil {
+		log.Errorf("Failed to bulk delete posts: %v", err)
+		renderStoreErrorPage(w, r, err, "Delete Failed", "We couldn't remove those posts.", next)
+		return
+	}
+	log.Infof("Moderator %s removed %d posts (%v): %s", username, len(postIDs), postIDs, reason)
+	for _, postID := range postIDs {
+		emitWebhook(webhookPostDeleted, webhookPostDeletion{PostID: postID, DeletedBy: username, Reason: reason})
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// serveModIP lists every post made from an address, or from a range with
+// /mod/ip/{ip}/{bits}, so moderators can spot ban evasion across boards.
+func serveModIP(w http.ResponseWriter, r *http.Request) {
+	if !requireModerator(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	raw := vars["ip"]
+	if bits := vars["bits"]; bits != "" {
+		raw += "/" + bits
+	}
+	network, err := parseNetwork(raw)
+	if err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Address", fmt.Sprintf("%q is not an IP address or range.", raw), "/mod/reports")
+		return
+	}
+	limit, offset, err := parsePageParams(r, pageConfig.Posts, pageConfig.Max)
+	if err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Page", err.Error(), "/mod/reports")
+		return
+	}
+	ips, err := postIPsInNetwork(r.Context(), db, network)
+	if err != nil {
+		log.Errorf("Failed to look up addresses in %s: %v", network, err)
+		renderStoreErrorPage(w, r, err, "Lookup Failed", "We couldn't search posts by address.", "/mod/reports")
+		return
+	}
+	posts, total, err := getPostsByNetwork(r.Context(), db, network, limit, offset)
+	if err != nil {
+		log.Errorf("Failed to load posts from %s: %v", network, err)
+		renderStoreErrorPage(w, r, err, "Lookup Failed", "We couldn't search posts by address.", "/mod/reports")
+		return
+	}
+
+	data := ModIPViewData{
+		AuthViewData: getAuthViewData(r),
+		Network:      network.String(),
+		Addresses:    ips,
+		Posts:        posts,
+		Total:        total,
+		Banned:       activeNetworkPolicy.Load().blocks(network.IP.String()),
+	}
+	page := func(offset int) string {
+		return fmt.Sprintf("%s?limit=%d&offset=%d", r.URL.Path, limit, offset)
+	}
+	if offset > 0 {
+		data.PrevURL = page(max(offset-limit, 0))
+	}
+	if offset+len(posts) < total {
+		data.NextURL = page(offset + limit)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ExecuteTemplate(w, "mod_ip.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// modIPURL is the lookup page for network.
+func modIPURL(network *net.IPNet) string {
+	if ones, bits := network.Mask.Size(); ones == bits {
+		return "/mod/ip/" + network.IP.String()
+	}
+	return "/mod/ip/" + network.String()
+}
+
+// banIPHandler blocks writes from the form's network, the same way a
+// JANK_BLOCKED_NETWORKS entry does, and takes effect immediately.
+func banIPHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireModerator(w, r) {
+		return
+	}
+	network, err := parseNetwork(strings.TrimSpace(r.FormValue("network")))
+	if err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Address", "That is not an IP address or range.", "/mod/reports")
+		return
+	}
+	next := modIPURL(network)
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		renderErrorPage(w, r, http.StatusBadRequest, "Missing Reason", "Please add a reason for the ban.", next)
+		return
+	}
+	username, _ := getAuthenticatedUsername(r)
+	if err := banNetwork(r.Context(), db, network, reason, username); err != nil {
+		log.Errorf("Failed to ban %s: %v", network, err)
+		renderStoreErrorPage(w, r, err, "Ban Failed", "We couldn't ban that address.", next)
+		return
+	}
+	if _, err := reloadNetworkPolicy(); err != nil {
+		log.Errorf("Failed to reload network lists: %v", err)
+		renderErrorPage(w, r, http.StatusInternalServerError, "Ban Failed", "The ban was saved but couldn't be applied yet.", next)
+		return
+	}
+	log.Infof("Moderator %s banned %s: %s", username, network, reason)
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// deleteIPPostsHandler soft deletes the remaining posts from the form's
+// network, oldest first and at most maxBulkDeletePosts at a time.
+func deleteIPPostsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireModerator(w, r) {
+		return
+	}
+	network, err := parseNetwork(strings.TrimSpace(r.FormValue("network")))
+	if err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Address", "That is not an IP address or range.", "/mod/reports")
+		return
+	}
+	next := modIPURL(network)
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		renderErrorPage(w, r, http.StatusBadRequest, "Missing Reason", "Please add a reason for the removal.", next)
+		return
+	}
+	postIDs, err := getLivePostIDsByNetwork(r.Context(), db, network, maxBulkDeletePosts)
+	if err != nil {
+		log.Errorf("Failed to load posts from %s: %v", network, err)
+		renderStoreErrorPage(w, r, err, "Delete Failed", "We couldn't find those posts.", next)
+		return
+	}
+	if len(postIDs) == 0 {
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
+	username, _ := getAuthenticatedUsername(r)
+	if err := softDeletePosts(r.Context(), db, postIDs, username, reason); err != nil {
+		log.Errorf("Failed to delete posts from %s: %v", network, err)
+		renderStoreErrorPage(w, r, err, "Delete Failed", "We couldn't remove those posts.", next)
+		return
+	}
+	log.Infof("Moderator %s removed %d posts from %s: %s", username, len(postIDs), network, reason)
+	for _, postID := range postIDs {
+		emitWebhook(webhookPostDeleted, webhookPostDeletion{PostID: postID, DeletedBy: username, Reason: reason})
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// warnPostHandler records a moderator warning to a post's author. The author
+// sees it as a banner until they acknowledge it.
+func warnPostHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireModerator(w, r) {
+		return
+	}
+	postID, err := strconv.Atoi(mux.Vars(r)["postID"])
+	if err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Post", "That post ID is not valid.", "/")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Form", "We couldn't read that warning.", "/")
+		return
+	}
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		renderErrorPage(w, r, http.StatusBadRequest, "Missing Reason", "Please say what the warning is for.", "/")
+		return
+	}
+	author, err := getPostAuthor(r.Context(), db, postID)
+	if err != nil {
+		renderStoreErrorPage(w, r, err, "Post Not Found", "We couldn't find that post.", "/")
+		return
+	}
+	if author == "" || author == deletedUsername {
+		renderErrorPage(w, r, http.StatusBadRequest, "No Author", "That post has no account to warn.", "/")
+		return
+	}
+	moderator, _ := getAuthenticatedUsername(r)
+	if _, err := createUserWarning(r.Context(), db, author, &postID, reason, moderator); err != nil {
+		log.Errorf("Failed to warn user: %v", err)
+		renderStoreErrorPage(w, r, err, "Warning Failed", "We couldn't record that warning.", "/")
+		return
+	}
+	count, err := countUserWarnings(r.Context(), db, author)
+	if err != nil {
+		log.Errorf("Failed to count warnings: %v", err)
+	}
+	log.Infof("Moderator %s warned %s about post %d (%d warnings total)", moderator, author, postID, count)
+
+	next := sanitizeNext(r.FormValue("next"))
+	if next == "" {
+		next = "/"
+		if threadID, err := getPostThreadID(r.Context(), db, postID); err == nil {
+			next = fmt.Sprintf("/view/thread/%d#post-%d", threadID, postID)
+		}
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// acknowledgeWarningHandler dismisses one of the signed-in user's warnings.
+func acknowledgeWarningHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAuth(w, r) {
+		return
+	}
+	warningID, err := strconv.Atoi(mux.Vars(r)["warningID"])
+	if err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Warning", "That warning ID is not valid.", "/")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Form", "We couldn't read that request.", "/")
+		return
+	}
+	username, _ := getAuthenticatedUsername(r)
+	if err := acknowledgeWarning(r.Context(), db, warningID, username); err != nil {
+		renderStoreErrorPage(w, r, err, "Warning Not Found", "We couldn't find that warning.", "/")
+		return
+	}
+	next := sanitizeNext(r.FormValue("next"))
+	if next == "" {
+		next = "/"
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// acceptAnswerHandler lets a thread's author mark one reply as the accepted
+// answer; an empty post_id clears it.
+func acceptAnswerHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAuth(w, r) {
+		return
+	}
+	threadID, err := strconv.Atoi(mux.Vars(r)["threadID"])
+	if err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Thread", "That thread ID is not valid.", "/")
+		return
+	}
+	if !requireContentAccess(w, r, contentThread, threadID) {
+		return
+	}
+	backURL := fmt.Sprintf("/view/thread/%d", threadID)
+	thread, _, err := getThreadByID(r.Context(), db, threadID)
+	if err != nil {
+		renderErrorPage(w, r, http.StatusNotFound, "Thread Not Found", "We couldn't find that thread.", "/")
+		return
+	}
+	username, _ := getAuthenticatedUsername(r)
+	if thread.Author == "" || username != thread.Author {
+		renderErrorPage(w, r, http.StatusForbidden, "Forbidden", "Only the thread's author can pick an accepted answer.", backURL)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Form", "We couldn't read that form submission.", backURL)
+		return
+	}
+
+	var postID *int
+	if raw := strings.TrimSpace(r.FormValue("post_id")); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil {
+			renderErrorPage(w, r, http.StatusBadRequest, "Invalid Post", "That post ID is not valid.", backURL)
+			return
+		}
+		var target *Post
+		for index, post := range thread.Posts {
+			if post.ID == id && index > 0 && !post.IsDeleted {
+				target = post
+				break
+			}
+		}
+		if target == nil {
+			renderErrorPage(w, r, http.StatusBadRequest, "Invalid Post", "Only replies in this thread can be accepted.", backURL)
+			return
+		}
+		postID = &target.ID
+		backURL = fmt.Sprintf("%s#post-%d", backURL, id)
+	}
+	if err := setThreadAcceptedPost(r.Context(), db, threadID, postID); err != nil {
+		log.Errorf("Failed to set accepted answer: %v", err)
+		renderStoreErrorPage(w, r, err, "Update Failed", "We couldn't update the accepted answer.", backURL)
+		return
+	}
+	http.Redirect(w, r, backURL, http.StatusSeeOther)
+}
+
+// formatSlowModeInterval renders a slow mode interval such as "5 minutes".
+func formatSlowModeInterval(seconds int) string {
+	unit, count := "second", seconds
+	switch {
+	case seconds >= 3600 && seconds%3600 == 0:
+		unit, count = "hour", seconds/3600
+	case seconds >= 60 && seconds%60 == 0:
+		unit, count = "minute", seconds/60
+	}
+	if count == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", count, unit)
+}
+
+// lockThreadHandler locks a thread against new replies, or unlocks it with
+// locked=false.
+func lockThreadHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireModerator(w, r) {
+		return
+	}
+	threadID, err := strconv.Atoi(mux.Vars(r)["threadID"])
+	if err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Thread", "That thread ID is not valid.", "/")
+		return
+	}
+	backURL := fmt.Sprintf("/view/thread/%d", threadID)
+	if err := r.ParseForm(); err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Form", "We couldn't read that form submission.", backURL)
+		return
+	}
+	locked := r.FormValue("locked") != "false"
+	if err := setThreadLocked(r.Context(), db, threadID, locked); err != nil {
+		log.Errorf("Failed to lock thread: %v", err)
+		renderStoreErrorPage(w, r, err, "Update Failed", "We couldn't update that thread.", backURL)
+		return
+	}
+	http.Redirect(w, r, backURL, http.StatusSeeOther)
+}
+
+// stickyThreadHandler pins a thread to the top of its board, or unpins it
+// with sticky=false.
+func stickyThreadHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireModerator(w, r) {
+		return
+	}
+	threadID, err := strconv.Atoi(mux.Vars(r)["threadID"])
+	if err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Thread", "That thread ID is not valid.", "/")
+		return
+	}
+	backURL := fmt.Sprintf("/view/thread/%d", threadID)
+	if err := r.ParseForm(); err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Form", "We couldn't read that form submission.", backURL)
+		return
+	}
+	sticky := r.FormValue("sticky") != "false"
+	if err := setThreadSticky(r.Context(), db, threadID, sticky); err != nil {
+		log.Errorf("Failed to set thread sticky: %v", err)
+		renderStoreErrorPage(w, r, err, "Update Failed", "We couldn't update that thread.", backURL)
+		return
+	}
+	http.Redirect(w, r, backURL, http.StatusSeeOther)
+}
+
+// slowModeHandler lets moderators and the thread's author set the minimum
+// time between one user's posts in the thread.
+func slowModeHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAuth(w, r) {
+		return
+	}
+	threadID, err := strconv.Atoi(mux.Vars(r)["threadID"])
+	if err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Thread", "That thread ID is not valid.", "/")
+		return
+	}
+	if !requireContentAccess(w, r, contentThread, threadID) {
+		return
+	}
+	backURL := fmt.Sprintf("/view/thread/%d", threadID)
+	thread, _, err := getThreadByID(r.Context(), db, threadID)
+	if err != nil {
+		renderErrorPage(w, r, http.StatusNotFound, "Thread Not Found", "We couldn't find that thread.", "/")
+		return
+	}
+	username, _ := getAuthenticatedUsername(r)
+	if !isModerator(username) && (thread.Author == "" || username != thread.Author) {
+		renderErrorPage(w, r, http.StatusForbidden, "Forbidden", "Only moderators and the thread's author can change slow mode.", backURL)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Form", "We couldn't read that form submission.", backURL)
+		return
+	}
+	seconds, err := strconv.Atoi(strings.TrimSpace(r.FormValue("seconds")))
+	if err != nil || seconds < 0 || seconds > maxSlowModeSeconds {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Interval", "Slow mode must be between 0 seconds and one day.", backURL)
+		return
+	}
+	if err := setThreadSlowMode(r.Context(), db, threadID, seconds); err != nil {
+		log.Errorf("Failed to set slow mode: %v", err)
+		renderStoreErrorPage(w, r, err, "Update Failed", "We couldn't update slow mode.", backURL)
+		return
+	}
+	log.Infof("User %s set slow mode on thread %d to %ds", username, threadID, seconds)
+	http.Redirect(w, r, backURL, http.StatusSeeOther)
+}
+
+func isValidVote(value int) bool {
+	return value >= -1 && value <= 1
+}
+
+// votePostHandler records a vote from the thread view's vote buttons.
+func votePostHandler(w http.ResponseWriter, r *http.Request) {
+	if !postVotesEnabled {
+		renderErrorPage(w, r, http.StatusNotFound, "Not Found", "Voting is disabled.", "/")
+		return
+	}
+	if !requireAuth(w, r) {
+		return
+	}
+	postID, err := strconv.Atoi(mux.Vars(r)["postID"])
+	if err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Post", "That post ID is not valid.", "/")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Form", "We couldn't read that vote.", "/")
+		return
+	}
+	value, err := strconv.Atoi(r.FormValue("value"))
+	if err != nil || !isValidVote(value) {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Vote", "Votes must be up or down.", "/")
+		return
+	}
+	if !requireContentAccess(w, r, contentPost, postID) {
+		return
+	}
+	threadID, err := getPostThreadID(r.Context(), db, postID)
+	if err != nil {
+		renderErrorPage(w, r, http.StatusNotFound, "Post Not Found", "We couldn't find that post.", "/")
+		return
+	}
+	username, _ := getAuthenticatedUsername(r)
+	if _, err := votePost(r.Context(), db, postID, username, value); err != nil {
+		if errors.Is(err, errVoteDeletedPost) {
+			renderErrorPage(w, r, http.StatusConflict, "Post Deleted", "That post has been deleted and can't be voted on.", fmt.Sprintf("/view/thread/%d", threadID))
+			return
+		}
+		log.Errorf("Failed to record vote: %v", err)
+		renderStoreErrorPage(w, r, err, "Vote Failed", "We couldn't record that vote.", fmt.Sprintf("/view/thread/%d", threadID))
+		return
+	}
+	next := sanitizeNext(r.FormValue("next"))
+	if next == "" {
+		next = fmt.Sprintf("/view/thread/%d#post-%d", threadID, postID)
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// sortRepliesByScore orders replies by score, highest first, keeping the
+// opening post in place and ties in posting order.
+func sortRepliesByScore(posts []*Post) {
+	if len(posts) < 2 {
+		return
+	}
+	replies := posts[1:]
+	sort.SliceStable(replies, func(i, j int) bool {
+		return replies[i].Score > replies[j].Score
+	})
+}
+
+// maxPostBadgeLength caps moderator badge text such as "official".
+const maxPostBadgeLength = 32
+
+// setPostBadgeHandler lets moderators set or clear a badge on a post.
+func setPostBadgeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderErrorPage(w, r, http.StatusMethodNotAllowed, "Not Allowed", "That action isn't supported here.", "/")
+		return
+	}
+	if !requireModerator(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	postID, err := strconv.Atoi(vars["postID"])
+	if err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Post", "That post ID is not valid.", "/")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Form", "We couldn't read that badge.", "/")
+		return
+	}
+	badge := strings.TrimSpace(r.FormValue("badge"))
+	if utf8.RuneCountInString(badge) > maxPostBadgeLength {
+		renderErrorPage(w, r, http.StatusBadRequest, "Badge Too Long", fmt.Sprintf("Badges must be %d characters or fewer.", maxPostBadgeLength), "/")
+		return
+	}
+	if err := setPostBadge(r.Context(), db, postID, badge); err != nil {
+		log.Errorf("Failed to set post badge: %v", err)
+		renderStoreErrorPage(w, r, err, "Badge Failed", "We couldn't update that post's badge.", "/")
+		return
+	}
+	next := sanitizeNext(r.FormValue("next"))
+	if next == "" {
+		threadID, err := getPostThreadID(r.Context(), db, postID)
+		if err == nil {
+			next = fmt.Sprintf("/view/thread/%d#post-%d", threadID, postID)
+		} else {
+			next = "/"
+		}
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+type cardTreePayload struct {
+	Trees []cardTreePayloadTree `json:"trees"`
+}
+
+type cardTreePayloadTree struct {
+	Title       string                `json:"title"`
+	Description string                `json:"description"`
+	IsPrimary   bool                  `json:"is_primary"`
+	Nodes       []cardTreePayloadNode `json:"nodes"`
+}
+
+type cardTreePayloadNode struct {
+	TempID       string                      `json:"temp_id"`
+	ParentTempID *string                     `json:"parent_temp_id"`
+	CardName     string                      `json:"card_name"`
+	Position     *int                        `json:"position"`
+	Annotations  []cardTreePayloadAnnotation `json:"annotations"`
 }
 
 type cardTreePayloadAnnotation struct {
@@ -702,6 +1742,13 @@ type cardTreePayloadAnnotation struct {
 	Tags  string `json:"tags"`
 }
 
+var (
+	errTreePayloadInvalid          = errors.New("invalid tree payload")
+	errTreePayloadTreeCount        = errors.New("too many card trees in submission")
+	errTreePayloadNodeCount        = errors.New("too many nodes in card tree")
+	errTreePayloadAnnotationLength = errors.New("annotation body too long")
+)
+
 func parseCardTreePayload(raw string) (*cardTreePayload, error) {
 	raw = strings.TrimSpace(raw)
 	if raw == "" {
@@ -714,20 +1761,82 @@ func parseCardTreePayload(raw string) (*cardTreePayload, error) {
 	if len(payload.Trees) == 0 {
 		return nil, nil
 	}
+	if err := validateCardTreePayload(&payload, treeLimits); err != nil {
+		return nil, err
+	}
 	return &payload, nil
 }
 
-func applyCardTreePayload(db *sql.DB, scopeType string, scopeID int, username string, payload *cardTreePayload) error {
+// validateCardTreePayload checks a payload against the configured size limits.
+func validateCardTreePayload(payload *cardTreePayload, limits TreeLimits) error {
+	if payload == nil {
+		return nil
+	}
+	if len(payload.Trees) > limits.MaxTrees {
+		return errTreePayloadTreeCount
+	}
+	for _, tree := range payload.Trees {
+		if len(tree.Nodes) > limits.MaxNodesPerTree {
+			return errTreePayloadNodeCount
+		}
+		for _, node := range tree.Nodes {
+			for _, annotation := range node.Annotations {
+				if utf8.RuneCountInString(strings.TrimSpace(annotation.Body)) > limits.MaxAnnotationLength {
+					return errTreePayloadAnnotationLength
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// postRejection explains why createPost turned a post away. ok is false for
+// errors that aren't the poster's fault.
+func postRejection(err error) (status int, title, message string, ok bool) {
+	switch {
+	case errors.Is(err, errPostingTooFast):
+		return http.StatusTooManyRequests, "Slow Down", fmt.Sprintf("You're posting too fast. Please wait %s between posts.", postCooldown), true
+	case errors.Is(err, errThreadLocked):
+		return http.StatusForbidden, "Thread Locked", "This thread is locked. No new replies can be posted.", true
+	case errors.Is(err, errSlowMode):
+		return http.StatusTooManyRequests, "Slow Mode", "This thread is in slow mode. Please wait before posting here again.", true
+	case errors.Is(err, errDuplicatePost):
+		return http.StatusConflict, "Duplicate Post", "You just posted that in this thread.", true
+	case errors.Is(err, errPostEmailTooLong):
+		return http.StatusBadRequest, "Email Too Long", fmt.Sprintf("The email field must be %d characters or fewer.", maxPostEmailLength), true
+	default:
+		return 0, "", "", false
+	}
+}
+
+// cardTreePayloadErrorMessage explains a payload parse failure to the poster.
+func cardTreePayloadErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, errTreePayloadTreeCount):
+		return fmt.Sprintf("Please attach %d card trees or fewer.", treeLimits.MaxTrees)
+	case errors.Is(err, errTreePayloadNodeCount):
+		return fmt.Sprintf("Each card tree can have at most %d cards.", treeLimits.MaxNodesPerTree)
+	case errors.Is(err, errTreePayloadAnnotationLength):
+		return fmt.Sprintf("Card notes must be %d characters or fewer.", treeLimits.MaxAnnotationLength)
+	default:
+		return "We couldn't read your card tree details."
+	}
+}
+
+func applyCardTreePayload(ctx context.Context, db dbConn, scopeType string, scopeID int, username string, payload *cardTreePayload) error {
 	if payload == nil || len(payload.Trees) == 0 {
 		return nil
 	}
+	if err := validateCardTreePayload(payload, treeLimits); err != nil {
+		return err
+	}
 	for _, tree := range payload.Trees {
 		title := strings.TrimSpace(tree.Title)
 		if title == "" {
-			return fmt.Errorf("tree title is required")
+			return fmt.Errorf("%w: tree title is required", errTreePayloadInvalid)
 		}
 		description := strings.TrimSpace(tree.Description)
-		cardTree, err := createCardTree(db, scopeType, scopeID, title, description, username, tree.IsPrimary)
+		cardTree, err := createCardTree(ctx, db, scopeType, scopeID, title, description, username, tree.IsPrimary)
 		if err != nil {
 			return err
 		}
@@ -736,58 +1845,76 @@ func applyCardTreePayload(db *sql.DB, scopeType string, scopeID int, username st
 			continue
 		}
 
-		idMap := make(map[string]int)
-		pending := append([]cardTreePayloadNode(nil), tree.Nodes...)
-		for len(pending) > 0 {
-			progressed := false
-			remaining := pending[:0]
-			for _, node := range pending {
-				cardName := strings.TrimSpace(node.CardName)
-				if cardName == "" {
-					return fmt.Errorf("card name is required")
-				}
-				if strings.TrimSpace(node.TempID) == "" {
-					return fmt.Errorf("node id is required")
+		if _, err := createCardTreePayloadNodes(ctx, db, cardTree.ID, tree.Nodes, username); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createCardTreePayloadNodes inserts nodes that reference parents by temp ID,
+// creating parents before children, and returns the temp ID to node ID map.
+func createCardTreePayloadNodes(ctx context.Context, conn dbConn, treeID int, nodes []cardTreePayloadNode, username string) (map[string]int, error) {
+	seen := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		tempID := strings.TrimSpace(node.TempID)
+		if tempID == "" {
+			return nil, fmt.Errorf("%w: node id is required", errTreePayloadInvalid)
+		}
+		if seen[tempID] {
+			return nil, fmt.Errorf("%w: duplicate node id %q", errTreePayloadInvalid, tempID)
+		}
+		seen[tempID] = true
+	}
+
+	idMap := make(map[string]int, len(nodes))
+	pending := append([]cardTreePayloadNode(nil), nodes...)
+	for len(pending) > 0 {
+		progressed := false
+		remaining := pending[:0]
+		for _, node := range pending {
+			cardName := strings.TrimSpace(node.CardName)
+			if cardName == "" {
+				return nil, fmt.Errorf("%w: card name is required", errTreePayloadInvalid)
+			}
+			var parentID *int
+			if node.ParentTempID != nil && strings.TrimSpace(*node.ParentTempID) != "" {
+				parentDBID, ok := idMap[strings.TrimSpace(*node.ParentTempID)]
+				if !ok {
+					remaining = append(remaining, node)
+					continue
 				}
-				var parentID *int
-				if node.ParentTempID != nil && strings.TrimSpace(*node.ParentTempID) != "" {
-					parentDBID, ok := idMap[*node.ParentTempID]
-					if !ok {
-						remaining = append(remaining, node)
-						continue
-					}
-					parentID = &parentDBID
+				parentID = &parentDBID
+			}
+			createdNode, err := createCardTreeNode(ctx, conn, treeID, parentID, cardName, nodePosition(node.Position), username)
+			if err != nil {
+				return nil, err
+			}
+			idMap[strings.TrimSpace(node.TempID)] = createdNode.ID
+			progressed = true
+
+			for _, annotation := range node.Annotations {
+				body := strings.TrimSpace(annotation.Body)
+				label := strings.TrimSpace(annotation.Label)
+				tags := strings.TrimSpace(annotation.Tags)
+				if body == "" {
+					continue
 				}
-				createdNode, err := createCardTreeNode(db, cardTree.ID, parentID, cardName, node.Position, username)
-				if err != nil {
-					return err
+				kind := strings.TrimSpace(annotation.Kind)
+				if kind == "" {
+					kind = "note"
 				}
-				idMap[node.TempID] = createdNode.ID
-				progressed = true
-
-				for _, annotation := range node.Annotations {
-					body := strings.TrimSpace(annotation.Body)
-					label := strings.TrimSpace(annotation.Label)
-					tags := strings.TrimSpace(annotation.Tags)
-					if body == "" {
-						continue
-					}
-					kind := strings.TrimSpace(annotation.Kind)
-					if kind == "" {
-						kind = "note"
-					}
-					if _, err := createCardTreeAnnotation(db, createdNode.ID, kind, body, label, tags, nil, username); err != nil {
-						return err
-					}
+				if _, err := createCardTreeAnnotation(ctx, conn, createdNode.ID, kind, body, label, tags, nil, username); err != nil {
+					return nil, err
 				}
 			}
-			if !progressed && len(remaining) > 0 {
-				return fmt.Errorf("invalid tree payload")
-			}
-			pending = remaining
 		}
+		if !progressed && len(remaining) > 0 {
+			return nil, fmt.Errorf("%w: unknown or circular parent reference", errTreePayloadInvalid)
+		}
+		pending = remaining
 	}
-	return nil
+	return idMap, nil
 }
 
 func serveLogin(w http.ResponseWriter, r *http.Request) {
@@ -821,8 +1948,9 @@ func serveLogin(w http.ResponseWriter, r *http.Request) {
 		password := r.FormValue("password")
 		next := sanitizeNext(r.FormValue("next"))
 
-		if authenticateUser(db, username, password) {
+		if authenticateUser(r.Context(), db, username, password) {
 			setAuthCookie(w, r, username)
+			noteLogin(r, username)
 			if next == "" {
 				next = "/"
 			}
@@ -860,19 +1988,29 @@ func serveProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	username, _ := getAuthenticatedUsername(r)
-	user, err := getUserByUsername(db, username)
+	user, err := getUserByUsername(r.Context(), db, username)
+	if err != nil {
+		renderStoreErrorPage(w, r, err, "Profile Unavailable", "We couldn't load your profile.", "/")
+		return
+	}
+	threads, err := getThreadsByAuthor(r.Context(), db, username, username)
+	if err != nil {
+		renderStoreErrorPage(w, r, err, "Threads Unavailable", "We couldn't load your threads.", "/profile")
+		return
+	}
+	posts, err := getPostsByAuthor(r.Context(), db, username, username)
 	if err != nil {
-		renderErrorPage(w, r, http.StatusInternalServerError, "Profile Unavailable", "We couldn't load your profile.", "/")
+		renderStoreErrorPage(w, r, err, "Comments Unavailable", "We couldn't load your comments.", "/profile")
 		return
 	}
-	threads, err := getThreadsByAuthor(db, username)
+	treeCount, err := countCardTreesByCreator(r.Context(), db, username, username)
 	if err != nil {
-		renderErrorPage(w, r, http.StatusInternalServerError, "Threads Unavailable", "We couldn't load your threads.", "/profile")
+		renderStoreErrorPage(w, r, err, "Profile Unavailable", "We couldn't load your card trees.", "/profile")
 		return
 	}
-	posts, err := getPostsByAuthor(db, username)
+	logins, err := getLoginHistory(r.Context(), db, username)
 	if err != nil {
-		renderErrorPage(w, r, http.StatusInternalServerError, "Comments Unavailable", "We couldn't load your comments.", "/profile")
+		renderStoreErrorPage(w, r, err, "Profile Unavailable", "We couldn't load your sign-in history.", "/profile")
 		return
 	}
 
@@ -882,6 +2020,8 @@ func serveProfile(w http.ResponseWriter, r *http.Request) {
 		User:         user,
 		Threads:      threads,
 		Posts:        posts,
+		TreeCount:    treeCount,
+		Logins:       logins,
 	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := templates.ExecuteTemplate(w, "profile.html", data); err != nil {
@@ -889,6 +2029,76 @@ func serveProfile(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// serveProfileExport downloads everything the signed-in user has written as
+// one JSON file.
+func serveProfileExport(w http.ResponseWriter, r *http.Request) {
+	if !requireAuth(w, r) {
+		return
+	}
+	username, _ := getAuthenticatedUsername(r)
+	export := UserExport{ExportedAt: time.Now().UTC()}
+	var err error
+	if export.User, err = getUserByUsername(r.Context(), db, username); err != nil {
+		renderStoreErrorPage(w, r, err, "Export Failed", "We couldn't load your profile.", "/profile")
+		return
+	}
+	if export.Threads, err = getThreadsByAuthor(r.Context(), db, username, username); err != nil {
+		renderStoreErrorPage(w, r, err, "Export Failed", "We couldn't load your threads.", "/profile")
+		return
+	}
+	if export.Posts, err = getPostsByAuthor(r.Context(), db, username, username); err != nil {
+		renderStoreErrorPage(w, r, err, "Export Failed", "We couldn't load your comments.", "/profile")
+		return
+	}
+	if export.Trees, err = getCardTreesByCreator(r.Context(), db, username, username, 0, 0); err != nil {
+		renderStoreErrorPage(w, r, err, "Export Failed", "We couldn't load your card trees.", "/profile")
+		return
+	}
+	for _, tree := range export.Trees {
+		if tree.Nodes, err = getCardTreeNodesByTreeID(r.Context(), db, tree.ID); err != nil {
+			renderStoreErrorPage(w, r, err, "Export Failed", "We couldn't load your card trees.", "/profile")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="jank_user_%d_export.json"`, export.User.ID))
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		log.Errorf("Failed to write profile export: %v", err)
+	}
+}
+
+// deleteAccountHandler lets a user delete their own account after
+// confirming their password. Their content stays up under [deleted].
+func deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAuth(w, r) {
+		return
+	}
+	username, _ := getAuthenticatedUsername(r)
+	if err := r.ParseForm(); err != nil {
+		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Form", "We couldn't read that request.", "/profile")
+		return
+	}
+	if isModerator(username) {
+		renderErrorPage(w, r, http.StatusBadRequest, "Not Allowed", "The moderator account can't be deleted.", "/profile")
+		return
+	}
+	if !authenticateUser(r.Context(), db, username, r.FormValue("password")) {
+		renderErrorPage(w, r, http.StatusBadRequest, "Wrong Password", "Enter your current password to delete your account.", "/profile")
+		return
+	}
+	if err := deleteUser(r.Context(), db, username); err != nil {
+		log.Errorf("Failed to delete account: %v", err)
+		renderStoreErrorPage(w, r, err, "Delete Failed", "We couldn't delete your account. Please try again.", "/profile")
+		return
+	}
+	log.Infof("User %s deleted their account", username)
+	clearAuthCookie(w)
+	renderErrorPage(w, r, http.StatusOK, "Account Deleted", "Your account is gone and you've been logged out. Your threads and posts stay up with [deleted] as the author.", "/")
+}
+
 func serveUserTrees(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		renderErrorPage(w, r, http.StatusMethodNotAllowed, "Not Allowed", "That action isn't supported here.", "/")
@@ -898,15 +2108,15 @@ func serveUserTrees(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	username, _ := getAuthenticatedUsername(r)
-	trees, err := getCardTreesByCreator(db, username)
+	trees, err := getCardTreesByCreator(r.Context(), db, username, username, 0, 0)
 	if err != nil {
 		log.Errorf("Failed to load card trees: %v", err)
-		renderErrorPage(w, r, http.StatusInternalServerError, "Card Trees Unavailable", "We couldn't load your card trees.", "/profile")
+		renderStoreErrorPage(w, r, err, "Card Trees Unavailable", "We couldn't load your card trees.", "/profile")
 		return
 	}
 	summaries := make([]*CardTreeSummary, 0, len(trees))
 	for _, tree := range trees {
-		label, url := treeSourceInfo(tree)
+		label, url := treeSourceInfo(r.Context(), tree)
 		summaries = append(summaries, &CardTreeSummary{
 			Tree:        tree,
 			SourceLabel: label,
@@ -936,13 +2146,16 @@ func serveCardTreeView(w http.ResponseWriter, r *http.Request) {
 		renderErrorPage(w, r, http.StatusBadRequest, "Invalid Tree", "That tree ID is not valid.", "/")
 		return
 	}
-	tree, err := getCardTreeByID(db, treeID)
+	if !requireContentAccess(w, r, contentTree, treeID) {
+		return
+	}
+	tree, err := getCardTreeByID(r.Context(), db, treeID)
 	if err != nil {
 		log.Errorf("Tree not found: %v", err)
 		renderErrorPage(w, r, http.StatusNotFound, "Tree Not Found", "We couldn't find that card tree.", "/")
 		return
 	}
-	label, url := treeSourceInfo(tree)
+	label, url := treeSourceInfo(r.Context(), tree)
 
 	authData := getAuthViewData(r)
 	data := CardTreeViewData{
@@ -957,7 +2170,7 @@ func serveCardTreeView(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func treeSourceInfo(tree *CardTree) (string, string) {
+func treeSourceInfo(ctx context.Context, tree *CardTree) (string, string) {
 	if tree == nil {
 		return "", ""
 	}
@@ -967,7 +2180,7 @@ func treeSourceInfo(tree *CardTree) (string, string) {
 	case "thread":
 		return fmt.Sprintf("Thread #%d", tree.ScopeID), fmt.Sprintf("/view/thread/%d", tree.ScopeID)
 	case "post":
-		threadID, err := getPostThreadID(db, tree.ScopeID)
+		threadID, err := getPostThreadID(ctx, db, tree.ScopeID)
 		if err == nil {
 			return fmt.Sprintf("Post #%d in Thread #%d", tree.ScopeID, threadID), fmt.Sprintf("/view/thread/%d#post-%d", threadID, tree.ScopeID)
 		}
@@ -1013,19 +2226,24 @@ func servePublicProfile(w http.ResponseWriter, r *http.Request) {
 		renderErrorPage(w, r, http.StatusNotFound, "User Not Found", "We couldn't find that user.", "/user")
 		return
 	}
-	user, err := getUserByUsername(db, username)
+	user, err := getUserByUsername(r.Context(), db, username)
 	if err != nil {
 		renderErrorPage(w, r, http.StatusNotFound, "User Not Found", "We couldn't find that user.", "/user")
 		return
 	}
-	threads, err := getThreadsByAuthor(db, username)
+	threads, err := getThreadsByAuthor(r.Context(), db, username, requestUsername(r))
+	if err != nil {
+		renderStoreErrorPage(w, r, err, "Threads Unavailable", "We couldn't load this user's threads.", "/user")
+		return
+	}
+	posts, err := getPostsByAuthor(r.Context(), db, username, requestUsername(r))
 	if err != nil {
-		renderErrorPage(w, r, http.StatusInternalServerError, "Threads Unavailable", "We couldn't load this user's threads.", "/user")
+		renderStoreErrorPage(w, r, err, "Comments Unavailable", "We couldn't load this user's comments.", "/user")
 		return
 	}
-	posts, err := getPostsByAuthor(db, username)
+	treeCount, err := countCardTreesByCreator(r.Context(), db, username, requestUsername(r))
 	if err != nil {
-		renderErrorPage(w, r, http.StatusInternalServerError, "Comments Unavailable", "We couldn't load this user's comments.", "/user")
+		renderStoreErrorPage(w, r, err, "Profile Unavailable", "We couldn't load this user's card trees.", "/user")
 		return
 	}
 
@@ -1035,6 +2253,14 @@ func servePublicProfile(w http.ResponseWriter, r *http.Request) {
 		User:         user,
 		Threads:      threads,
 		Posts:        posts,
+		TreeCount:    treeCount,
+	}
+	if authData.IsModerator {
+		if data.ModNotes, err = getUserModNotes(r.Context(), db, username); err != nil {
+			renderStoreErrorPage(w, r, err, "Profile Unavailable", "We couldn't load the notes on this user.", "/user")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
 	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := templates.ExecuteTemplate(w, "public_profile.html", data); err != nil {
@@ -1081,15 +2307,11 @@ func serveSignup(w http.ResponseWriter, r *http.Request) {
 			renderSignupError(w, r, next, "Username must be 32 characters or fewer.")
 			return
 		}
-		if len(password) < 8 {
-			renderSignupError(w, r, next, "Password must be at least 8 characters.")
-			return
-		}
-		if len(password) > 1024 {
-			renderSignupError(w, r, next, "Password too long.")
+		if err := checkPassword(password); err != nil {
+			renderSignupError(w, r, next, capitalize(err.Error())+".")
 			return
 		}
-		if _, err := createUser(db, username, password); err != nil {
+		if _, err := createUser(r.Context(), db, username, password); err != nil {
 			log.Errorf("Failed to create user: %v", err)
 			renderSignupError(w, r, next, signupErrorMessage(err))
 			return
@@ -1121,6 +2343,16 @@ func renderErrorPage(w http.ResponseWriter, r *http.Request, status int, title,
 	}
 }
 
+// renderStoreErrorPage renders a failed store call, using 503 when the database timed out.
+func renderStoreErrorPage(w http.ResponseWriter, r *http.Request, err error, title, message, backURL string) {
+	if isQueryTimeout(err) {
+		w.Header().Set("Retry-After", "5")
+		renderErrorPage(w, r, http.StatusServiceUnavailable, "Database Busy", "The database is busy right now. Please try again in a moment.", backURL)
+		return
+	}
+	renderErrorPage(w, r, http.StatusInternalServerError, title, message, backURL)
+}
+
 func renderSignupError(w http.ResponseWriter, r *http.Request, next, message string) {
 	authData := getAuthViewData(r)
 	data := SignupViewData{
@@ -1141,6 +2373,9 @@ func signupErrorMessage(err error) string {
 	if strings.Contains(strings.ToLower(err.Error()), "exists") {
 		return "That username is already taken."
 	}
+	if strings.Contains(strings.ToLower(err.Error()), "reserved") {
+		return "That username is reserved."
+	}
 	return "Failed to create account."
 }
 
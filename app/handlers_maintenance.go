@@ -0,0 +1,343 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ------------------- Maintenance Handlers -------------------
+
+var (
+	maintenanceMu sync.Mutex
+	backupLimiter = NewRateLimiter()
+	readOnlyMode  atomic.Bool
+)
+
+// readOnlyExemptPaths stay writable in read-only mode so moderators can still
+// sign in and turn the mode back off.
+var readOnlyExemptPaths = map[string]bool{
+	"/mod/maintenance/readonly": true,
+	"/login":                    true,
+	"/logout":                   true,
+	"/auth/token":               true,
+	apiV1Prefix + "/auth/token": true,
+}
+
+type maintenanceResult struct {
+	Driver     string    `json:"driver"`
+	Operation  string    `json:"operation"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+type rerenderResult struct {
+	Rerendered    int `json:"rerendered"`
+	RenderVersion int `json:"render_version"`
+}
+
+type readOnlyStatus struct {
+	ReadOnly bool `json:"readonly"`
+}
+
+// readOnlyMiddleware rejects write requests with a 503 while read-only mode is on.
+func readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readOnlyMode.Load() && isWriteMethod(r.Method) && !readOnlyExemptPaths[r.URL.Path] {
+			w.Header().Set("Retry-After", "300")
+			http.Error(w, "Site is under maintenance (read-only mode)", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+// readOnlyHandler reports or switches read-only mode (moderator only). POST with
+// enabled=true|false sets the mode; POST without it toggles.
+func readOnlyHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireModerator(w, r) {
+		return
+	}
+	if r.Method == http.MethodPost {
+		enabled := !readOnlyMode.Load()
+		if value := r.FormValue("enabled"); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+				return
+			}
+			enabled = parsed
+		}
+		readOnlyMode.Store(enabled)
+		username, _ := getAuthenticatedUsername(r)
+		log.Warnf("Read-only mode set to %t by %s", enabled, username)
+	}
+	respondJSON(w, r, readOnlyStatus{ReadOnly: readOnlyMode.Load()})
+}
+
+// vacuumHandler compacts the database on demand (moderator only).
+func vacuumHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireModerator(w, r) {
+		return
+	}
+	if !maintenanceMu.TryLock() {
+		http.Error(w, "Maintenance already running", http.StatusConflict)
+		return
+	}
+	defer maintenanceMu.Unlock()
+
+	started := time.Now()
+	operation, err := vacuumDatabase(r.Context(), db)
+	if err != nil {
+		log.Errorf("Failed to vacuum database: %v", err)
+		http.Error(w, "Failed to vacuum database", http.StatusInternalServerError)
+		return
+	}
+	elapsed := time.Since(started)
+	log.Infof("Ran %s in %s", operation, elapsed)
+	respondJSON(w, r, maintenanceResult{
+		Driver:     dbDriver,
+		Operation:  operation,
+		StartedAt:  started.UTC(),
+		DurationMS: elapsed.Milliseconds(),
+	})
+}
+
+// recountHandler rewrites denormalized thread and board aggregates from the
+// posts they summarize (moderator only). It's safe to run while the site is
+// up.
+func recountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireModerator(w, r) {
+		return
+	}
+	if !maintenanceMu.TryLock() {
+		http.Error(w, "Maintenance already running", http.StatusConflict)
+		return
+	}
+	defer maintenanceMu.Unlock()
+
+	started := time.Now()
+	result, err := recountAggregates(r.Context(), db)
+	if err != nil {
+		log.Errorf("Failed to recount aggregates: %v", err)
+		respondStoreError(w, err, "Failed to recount aggregates")
+		return
+	}
+	log.Infof("Recounted aggregates in %s: %d threads and %d boards fixed", time.Since(started), result.ThreadsFixed, result.BoardsFixed)
+	respondJSON(w, r, result)
+}
+
+// rerenderHandler backfills stored post HTML that predates the current
+// markdownRenderVersion (moderator only). Posts are rewritten in batches, so
+// it's safe to run while the site is up.
+func rerenderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireModerator(w, r) {
+		return
+	}
+	if !maintenanceMu.TryLock() {
+		http.Error(w, "Maintenance already running", http.StatusConflict)
+		return
+	}
+	defer maintenanceMu.Unlock()
+
+	started := time.Now()
+	rerendered, err := rerenderStalePosts(r.Context(), db)
+	if err != nil {
+		log.Errorf("Failed to re-render posts after %d: %v", rerendered, err)
+		respondStoreError(w, err, "Failed to re-render posts")
+		return
+	}
+	log.Infof("Re-rendered %d posts in %s", rerendered, time.Since(started))
+	respondJSON(w, r, rerenderResult{Rerendered: rerendered, RenderVersion: markdownRenderVersion})
+}
+
+// backupHandler streams a database backup as a download (moderator only).
+func backupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireModerator(w, r) {
+		return
+	}
+	username, _ := getAuthenticatedUsername(r)
+	if !backupLimiter.Allow(username, 3, time.Hour) {
+		w.Header().Set("Retry-After", "3600")
+		http.Error(w, "Too many backups, try again later", http.StatusTooManyRequests)
+		return
+	}
+	if !maintenanceMu.TryLock() {
+		http.Error(w, "Maintenance already running", http.StatusConflict)
+		return
+	}
+	defer maintenanceMu.Unlock()
+
+	timestamp := time.Now().Format("20060102_150405")
+	switch dbDriver {
+	case "sqlite3":
+		serveSQLiteBackup(w, r, timestamp)
+	case "pgx":
+		servePostgresBackup(w, r, timestamp)
+	default:
+		http.Error(w, "Backups are not supported for this database", http.StatusNotImplemented)
+	}
+}
+
+// backupWriteTimeout replaces the server's WriteTimeout for backup downloads,
+// which can take far longer to send than an ordinary response.
+const backupWriteTimeout = 30 * time.Minute
+
+func serveSQLiteBackup(w http.ResponseWriter, r *http.Request, timestamp string) {
+	dir, err := os.MkdirTemp("", "jank-backup-*")
+	if err != nil {
+		log.Errorf("Failed to create backup directory: %v", err)
+		http.Error(w, "Failed to create backup", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "backup.db")
+	if err := backupSQLite(r.Context(), db, path); err != nil {
+		log.Errorf("Failed to back up database: %v", err)
+		http.Error(w, "Failed to create backup", http.StatusInternalServerError)
+		return
+	}
+	sendBackupFile(w, path, "application/vnd.sqlite3", fmt.Sprintf("sqlite_backup_%s.db", timestamp))
+}
+
+func servePostgresBackup(w http.ResponseWriter, r *http.Request, timestamp string) {
+	pgDump, err := exec.LookPath("pg_dump")
+	if err != nil {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusNotImplemented)
+		_, _ = io.WriteString(w, "pg_dump is not available on this server.\nRun `make backup-db` (scripts/backup_db.sh) from a host with pg_dump installed.\n")
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "jank-backup-*")
+	if err != nil {
+		log.Errorf("Failed to create backup directory: %v", err)
+		http.Error(w, "Failed to create backup", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// pg_dump writes to a file first so a failed dump is reported as an
+	// error instead of a truncated download that already claimed success.
+	path := filepath.Join(dir, "backup.sql")
+	if err := dumpPostgres(r.Context(), pgDump, path); err != nil {
+		log.Errorf("pg_dump failed: %v", err)
+		http.Error(w, "Failed to create backup", http.StatusInternalServerError)
+		return
+	}
+	sendBackupFile(w, path, "application/sql", fmt.Sprintf("postgres_backup_%s.sql", timestamp))
+}
+
+// dumpPostgres runs pg_dump against the configured database into path.
+func dumpPostgres(ctx context.Context, pgDump, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// The password goes in pg_dump's environment, not its arguments, which
+	// any local user can read from ps.
+	dsn, password := splitDSNPassword(dbDSN)
+	cmd := exec.CommandContext(ctx, pgDump, dsn)
+	if password != "" {
+		cmd.Env = append(os.Environ(), "PGPASSWORD="+password)
+	}
+	var stderr strings.Builder
+	cmd.Stdout = file
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return file.Close()
+}
+
+// sendBackupFile sends a finished backup as a download.
+func sendBackupFile(w http.ResponseWriter, path, contentType, filename string) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Errorf("Failed to open backup: %v", err)
+		http.Error(w, "Failed to create backup", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(backupWriteTimeout)); err != nil {
+		log.Warnf("Failed to extend backup write deadline: %v", err)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	if info, err := file.Stat(); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	}
+	if _, err := io.Copy(w, file); err != nil {
+		log.Errorf("Failed to write backup: %v", err)
+	}
+}
+
+var dsnPasswordParam = regexp.MustCompile(`(?i)(^|\s)password\s*=\s*('(?:[^'\\]|\\.)*'|\S*)`)
+
+// splitDSNPassword removes the password from a URL or key=value Postgres
+// connection string and returns it separately.
+func splitDSNPassword(dsn string) (string, string) {
+	if parsed, err := url.Parse(dsn); err == nil && (parsed.Scheme == "postgres" || parsed.Scheme == "postgresql") {
+		var password string
+		if parsed.User != nil {
+			password, _ = parsed.User.Password()
+			parsed.User = url.User(parsed.User.Username())
+		}
+		query := parsed.Query()
+		if query.Has("password") {
+			password = query.Get("password")
+			query.Del("password")
+			parsed.RawQuery = query.Encode()
+		}
+		return parsed.String(), password
+	}
+	match := dsnPasswordParam.FindStringSubmatch(dsn)
+	if match == nil {
+		return dsn, ""
+	}
+	password := match[2]
+	if strings.HasPrefix(password, "'") {
+		password = strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(password[1 : len(password)-1])
+	}
+	return strings.TrimSpace(dsnPasswordParam.ReplaceAllString(dsn, "")), password
+}
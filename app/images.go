@@ -0,0 +1,266 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// ------------------- Image Processing -------------------
+
+var (
+	// thumbnailSize is the longest side of generated thumbnails; Run sets it
+	// from JANK_THUMBNAIL_SIZE.
+	thumbnailSize = 250
+	// maxAnimationFrames caps how many frames an uploaded GIF may have; Run
+	// sets it from JANK_MAX_ANIMATION_FRAMES.
+	maxAnimationFrames = 100
+)
+
+// maxImagePixels caps decoded image area, so a small file with huge
+// dimensions can't exhaust memory.
+const maxImagePixels = 25_000_000
+
+// maxAnimationPixels caps a GIF's canvas area times maxAnimationFrames.
+// Every frame is decoded to check the frame count, so this bounds the
+// memory an animation can take before it's refused.
+const maxAnimationPixels = 100_000_000
+
+var (
+	errImageInvalid     = errors.New("that file isn't a readable image")
+	errImageTooLarge    = errors.New("image dimensions are too large")
+	errAnimationTooLong = errors.New("animation has too many frames")
+	errImageUnsupported = errors.New("unsupported image type")
+)
+
+// processedImage is an upload after metadata stripping, with its thumbnail.
+type processedImage struct {
+	Original    []byte
+	Width       int
+	Height      int
+	Thumb       []byte
+	ThumbType   string
+	ThumbWidth  int
+	ThumbHeight int
+}
+
+// processImage decodes data and re-encodes it with the standard library's
+// codecs, which write pixels and nothing else, so EXIF, XMP, text chunks,
+// and GIF comment or application extensions are all dropped. A JPEG's EXIF
+// orientation is applied to the pixels first, since the tag doesn't survive.
+// The thumbnail is no larger than thumbnailSize on either side.
+func processImage(data []byte, contentType string) (*processedImage, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errImageInvalid
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxImagePixels {
+		return nil, errImageTooLarge
+	}
+
+	out := &processedImage{Width: cfg.Width, Height: cfg.Height, ThumbType: "image/png"}
+	var src image.Image
+	var original bytes.Buffer
+	switch contentType {
+	case "image/jpeg":
+		decoded, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, errImageInvalid
+		}
+		src = applyOrientation(decoded, jpegOrientation(data))
+		out.Width, out.Height = src.Bounds().Dx(), src.Bounds().Dy()
+		out.ThumbType = "image/jpeg"
+		if err := jpeg.Encode(&original, src, &jpeg.Options{Quality: 90}); err != nil {
+			return nil, err
+		}
+	case "image/png":
+		decoded, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, errImageInvalid
+		}
+		src = decoded
+		if err := png.Encode(&original, src); err != nil {
+			return nil, err
+		}
+	case "image/gif":
+		if cfg.Width*cfg.Height*maxAnimationFrames > maxAnimationPixels {
+			return nil, errImageTooLarge
+		}
+		anim, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil || len(anim.Image) == 0 {
+			return nil, errImageInvalid
+		}
+		if len(anim.Image) > maxAnimationFrames {
+			return nil, errAnimationTooLong
+		}
+		// The thumbnail shows the first frame.
+		src = anim.Image[0]
+		if err := gif.EncodeAll(&original, anim); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errImageUnsupported
+	}
+	out.Original = original.Bytes()
+
+	thumb := scaleImage(src, thumbnailSize)
+	var buf bytes.Buffer
+	if out.ThumbType == "image/jpeg" {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, thumb)
+	}
+	if err != nil {
+		return nil, err
+	}
+	out.Thumb = buf.Bytes()
+	out.ThumbWidth, out.ThumbHeight = thumb.Bounds().Dx(), thumb.Bounds().Dy()
+	return out, nil
+}
+
+// scaleImage box-filters src down so neither side exceeds limit, keeping the
+// aspect ratio. Smaller images are copied at their own size. The standard
+// library has no resampler and golang.org/x/image isn't a dependency, so
+// the filter lives here.
+func scaleImage(src image.Image, limit int) *image.NRGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > limit || h > limit {
+		if w >= h {
+			tw, th = limit, h*limit/w
+		} else {
+			tw, th = w*limit/h, limit
+		}
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := b.Min.Y+y*h/th, b.Min.Y+(y+1)*h/th
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < tw; x++ {
+			x0, x1 := b.Min.X+x*w/tw, b.Min.X+(x+1)*w/tw
+			if x1 == x0 {
+				x1++
+			}
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation (1-8) of a JPEG, or 1 when it
+// has none. It only walks the segment headers before the image data.
+func jpegOrientation(data []byte) int {
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end < i+4 || end > len(data) {
+			break
+		}
+		if marker == 0xE1 {
+			if o := exifOrientation(data[i+4 : end]); o != 0 {
+				return o
+			}
+		}
+		i = end
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag (1-8) from an APP1 payload, or
+// returns 0 if there isn't one.
+func exifOrientation(payload []byte) int {
+	if len(payload) < 14 || string(payload[:6]) != "Exif\x00\x00" {
+		return 0
+	}
+	tiff := payload[6:]
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// applyOrientation turns src upright according to an EXIF orientation, as
+// a browser would when displaying it.
+func applyOrientation(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, src.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
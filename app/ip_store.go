@@ -0,0 +1,244 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+type posterIPKey struct{}
+
+// withPosterIP returns r's context carrying the client address createPost
+// records, or r's context unchanged when recording is off or the address
+// doesn't parse.
+func withPosterIP(r *http.Request) context.Context {
+	if !recordPostIPs {
+		return r.Context()
+	}
+	ip := net.ParseIP(clientIP(r))
+	if ip == nil {
+		return r.Context()
+	}
+	return context.WithValue(r.Context(), posterIPKey{}, ip.String())
+}
+
+func posterIP(ctx context.Context) string {
+	ip, _ := ctx.Value(posterIPKey{}).(string)
+	return ip
+}
+
+// ipKey is the form of an address kept in posts.ip_key: its 16-byte form in
+// hex, so IPv4 and IPv6 addresses sort together and a range is a BETWEEN.
+// It's empty when raw doesn't parse.
+func ipKey(raw string) string {
+	ip := net.ParseIP(raw)
+	if ip == nil {
+		return ""
+	}
+	return hex.EncodeToString(ip.To16())
+}
+
+// networkKeyRange returns the lowest and highest ip_key inside network.
+func networkKeyRange(network *net.IPNet) (string, string) {
+	ip := network.IP.To16()
+	mask := network.Mask
+	if len(mask) == net.IPv4len {
+		mask = append(net.CIDRMask(96, 128)[:12:12], mask...)
+	}
+	lo := make(net.IP, net.IPv6len)
+	hi := make(net.IP, net.IPv6len)
+	for i := range lo {
+		lo[i] = ip[i] & mask[i]
+		hi[i] = ip[i] | ^mask[i]
+	}
+	return hex.EncodeToString(lo), hex.EncodeToString(hi)
+}
+
+// ensurePostsIPKeyColumn adds posts.ip_key and fills it in for posts recorded
+// before it existed. The key is computed here rather than in SQL, so the
+// backfill reads the addresses first and then updates them.
+func ensurePostsIPKeyColumn(db *sql.DB) error {
+	if err := ensureColumns(db, "posts", "ip_key TEXT"); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS posts_ip_key_idx ON posts(ip_key)`); err != nil {
+		return err
+	}
+	rows, err := db.Query(`SELECT DISTINCT ip FROM posts WHERE ip IS NOT NULL AND ip_key IS NULL`)
+	if err != nil {
+		return err
+	}
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			rows.Close()
+			return err
+		}
+		ips = append(ips, ip)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		key := ipKey(ip)
+		if key == "" {
+			log.Warnf("Leaving unparseable post address %q out of address lookups", ip)
+			continue
+		}
+		if _, err := db.Exec(`UPDATE posts SET ip = $1, ip_key = $2 WHERE ip = $3 AND ip_key IS NULL`,
+			net.ParseIP(ip).String(), key, ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postIPsInNetwork lists the distinct recorded post addresses inside network.
+func postIPsInNetwork(ctx context.Context, db *sql.DB, network *net.IPNet) ([]string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	lo, hi := networkKeyRange(network)
+	rows, err := db.QueryContext(ctx, `
+		SELECT DISTINCT ip, ip_key FROM posts
+		WHERE ip_key BETWEEN $1 AND $2
+		ORDER BY ip_key`, lo, hi)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ips []string
+	for rows.Next() {
+		var ip, key string
+		if err := rows.Scan(&ip, &key); err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ips, nil
+}
+
+// getPostsByNetwork returns one page of posts from addresses inside network,
+// newest first, and how many there are in total.
+func getPostsByNetwork(ctx context.Context, db *sql.DB, network *net.IPNet, limit, offset int) ([]*IPPost, int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	lo, hi := networkKeyRange(network)
+
+	var total int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM posts WHERE ip_key BETWEEN $1 AND $2`, lo, hi).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT p.id, p.ip, COALESCE(p.author, ''), p.content, p.created, p.deleted_at,
+			t.id, t.title, b.id, b.name
+		FROM posts p
+		JOIN threads t ON t.id = p.thread_id
+		JOIN boards b ON b.id = t.board_id
+		WHERE p.ip_key BETWEEN $1 AND $2
+		ORDER BY p.created DESC, p.id DESC
+		LIMIT $3 OFFSET $4`, lo, hi, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	posts := []*IPPost{}
+	for rows.Next() {
+		var p IPPost
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&p.ID, &p.IP, &p.Author, &p.Content, &p.Created, &deletedAt,
+			&p.ThreadID, &p.ThreadTitle, &p.BoardID, &p.BoardName); err != nil {
+			return nil, 0, err
+		}
+		p.IsDeleted = deletedAt.Valid
+		posts = append(posts, &p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return posts, total, nil
+}
+
+// getLivePostIDsByNetwork lists up to limit of the oldest posts from
+// addresses inside network that haven't been deleted.
+func getLivePostIDsByNetwork(ctx context.Context, db *sql.DB, network *net.IPNet, limit int) ([]int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	lo, hi := networkKeyRange(network)
+	rows, err := db.QueryContext(ctx, `
+		SELECT id FROM posts
+		WHERE deleted_at IS NULL AND ip_key BETWEEN $1 AND $2
+		ORDER BY id
+		LIMIT $3`, lo, hi, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// modActionNetworkBan is the mod_actions action for a moderator ban.
+const modActionNetworkBan = "network.ban"
+
+// banNetwork adds network to the moderator bans, which block writes the same
+// way JANK_BLOCKED_NETWORKS does, and logs the ban in mod_actions. Banning a
+// range again updates its reason.
+func banNetwork(ctx context.Context, db *sql.DB, network *net.IPNet, reason, bannedBy string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	return inTx(ctx, db, func(tx dbConn) error {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO network_bans (network, reason, banned_by, created)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (network) DO UPDATE SET reason = excluded.reason, banned_by = excluded.banned_by`,
+			network.String(), reason, bannedBy, time.Now()); err != nil {
+			return err
+		}
+		return recordModAction(ctx, tx, bannedBy, modActionNetworkBan, 0, 0, fmt.Sprintf("%s: %s", network, reason))
+	})
+}
+
+// getBannedNetworks lists every moderator ban.
+func getBannedNetworks(ctx context.Context, db *sql.DB) ([]*net.IPNet, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `SELECT network FROM network_bans ORDER BY network`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var networks []*net.IPNet
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		network, err := parseNetwork(raw)
+		if err != nil {
+			log.Warnf("Skipping invalid network ban %q: %v", raw, err)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks, rows.Err()
+}
@@ -1,13 +1,24 @@
 package app
 
 import (
+	"context"
 	"database/sql"
 	"strings"
 	"time"
 )
 
-func getKlaxon(db *sql.DB) (*Klaxon, error) {
-	row := db.QueryRow(`SELECT id, tone, emoji, message, updated_at FROM klaxons WHERE id = 1`)
+const (
+	klaxonActionSet   = "set"
+	klaxonActionClear = "clear"
+)
+
+// klaxonHistoryShown is how many past changes the klaxon admin page lists.
+const klaxonHistoryShown = 50
+
+func getKlaxon(ctx context.Context, db *sql.DB) (*Klaxon, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	row := db.QueryRowContext(ctx, `SELECT id, tone, emoji, message, updated_at FROM klaxons WHERE id = 1`)
 	var klaxon Klaxon
 	if err := row.Scan(&klaxon.ID, &klaxon.Tone, &klaxon.Emoji, &klaxon.Message, &klaxon.UpdatedAt); err != nil {
 		if err == sql.ErrNoRows {
@@ -18,17 +29,28 @@ func getKlaxon(db *sql.DB) (*Klaxon, error) {
 	return &klaxon, nil
 }
 
-func saveKlaxon(db *sql.DB, tone, emoji, message string, updatedAt time.Time) error {
+// saveKlaxon sets the klaxon, or clears it when message is empty, and
+// records the change and who made it in klaxon_history.
+func saveKlaxon(ctx context.Context, db *sql.DB, tone, emoji, message, actor string, updatedAt time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	tone = normalizeKlaxonTone(tone)
 	emoji = strings.TrimSpace(emoji)
 	message = strings.TrimSpace(message)
 
-	if message == "" {
-		_, err := db.Exec(`DELETE FROM klaxons WHERE id = 1`)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	_, err := db.Exec(
+	action := klaxonActionSet
+	if message == "" {
+		action, tone, emoji = klaxonActionClear, "", ""
+		if _, err := tx.ExecContext(ctx, `DELETE FROM klaxons WHERE id = 1`); err != nil {
+			return err
+		}
+	} else if _, err := tx.ExecContext(ctx,
 		`INSERT INTO klaxons (id, tone, emoji, message, updated_at)
 		VALUES (1, $1, $2, $3, $4)
 		ON CONFLICT(id) DO UPDATE SET
@@ -37,8 +59,45 @@ func saveKlaxon(db *sql.DB, tone, emoji, message string, updatedAt time.Time) er
 			message = excluded.message,
 			updated_at = excluded.updated_at`,
 		tone, emoji, message, updatedAt,
-	)
-	return err
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO klaxon_history (action, tone, emoji, message, actor, created)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		action, tone, emoji, message, actor, updatedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// getKlaxonHistory lists the most recent klaxon changes, newest first.
+func getKlaxonHistory(ctx context.Context, db *sql.DB, limit int) ([]*KlaxonChange, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, action, COALESCE(tone, ''), COALESCE(emoji, ''), COALESCE(message, ''), actor, created
+		FROM klaxon_history
+		ORDER BY created DESC, id DESC
+		LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []*KlaxonChange{}
+	for rows.Next() {
+		var change KlaxonChange
+		if err := rows.Scan(&change.ID, &change.Action, &change.Tone, &change.Emoji, &change.Message, &change.Actor, &change.Created); err != nil {
+			return nil, err
+		}
+		changes = append(changes, &change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return changes, nil
 }
 
 func normalizeKlaxonTone(tone string) string {
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+// maxLoginHistory is how many sign-ins are kept per user; older ones are
+// dropped as new ones arrive.
+const maxLoginHistory = 20
+
+// maxLoginUserAgent caps the stored user agent, in bytes.
+const maxLoginUserAgent = 256
+
+// recordLogin sets username's last login and appends a history row,
+// trimming the history to maxLoginHistory.
+func recordLogin(ctx context.Context, db *sql.DB, username, ip, userAgent string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	if len(userAgent) > maxLoginUserAgent {
+		userAgent = userAgent[:maxLoginUserAgent]
+	}
+	now := time.Now()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET last_login = $1 WHERE username = $2`, now, username); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO login_history (username, created, ip, user_agent)
+		VALUES ($1, $2, $3, $4)`,
+		username, now, ip, userAgent); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM login_history
+		WHERE username = $1 AND id NOT IN (
+			SELECT id FROM login_history WHERE username = $1 ORDER BY created DESC, id DESC LIMIT $2
+		)`, username, maxLoginHistory); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// getLoginHistory lists username's recent sign-ins, newest first.
+func getLoginHistory(ctx context.Context, db *sql.DB, username string) ([]*LoginRecord, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `
+		SELECT created, COALESCE(ip, ''), COALESCE(user_agent, '')
+		FROM login_history
+		WHERE username = $1
+		ORDER BY created DESC, id DESC
+		LIMIT $2`, username, maxLoginHistory)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logins := []*LoginRecord{}
+	for rows.Next() {
+		var login LoginRecord
+		if err := rows.Scan(&login.Created, &login.IP, &login.UserAgent); err != nil {
+			return nil, err
+		}
+		logins = append(logins, &login)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return logins, nil
+}
+
+// noteLogin records a successful sign-in from r. A failure is logged but
+// doesn't stop the login.
+func noteLogin(r *http.Request, username string) {
+	if err := recordLogin(r.Context(), db, username, clientIP(r), r.UserAgent()); err != nil {
+		log.Errorf("Failed to record login for %s: %v", username, err)
+	}
+}
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// vacuumDatabase compacts the database and refreshes planner statistics.
+// It runs without the per-query timeout since a full VACUUM can take a while.
+func vacuumDatabase(ctx context.Context, db *sql.DB) (string, error) {
+	var stmt string
+	switch dbDriver {
+	case "sqlite3":
+		stmt = "VACUUM"
+	case "pgx":
+		stmt = "VACUUM ANALYZE"
+	default:
+		return "", fmt.Errorf("unsupported database driver %q", dbDriver)
+	}
+	if _, err := db.ExecContext(ctx, stmt); err != nil {
+		return stmt, err
+	}
+	return stmt, nil
+}
+
+// backupSQLite writes a consistent copy of the SQLite database to path.
+// The destination must not already exist.
+func backupSQLite(ctx context.Context, db *sql.DB, path string) error {
+	if dbDriver != "sqlite3" {
+		return fmt.Errorf("backup via VACUUM INTO requires sqlite")
+	}
+	_, err := db.ExecContext(ctx, `VACUUM INTO $1`, path)
+	return err
+}
+
+// recountBatchSize is how many threads each recount statement covers, so a
+// live site only ever waits on short writes.
+const recountBatchSize = 500
+
+// recountResult reports how many rows recountAggregates had to correct.
+type recountResult struct {
+	ThreadsFixed int `json:"threads_fixed"`
+	BoardsFixed  int `json:"boards_fixed"`
+}
+
+// recountAggregates rewrites the denormalized columns from the tables they
+// summarize: each thread's last_bump becomes its newest non-sage post (or its
+// creation time), and each board's post_counter is raised to its highest
+// per-board post number so new posts can't reuse one. Rows that are already
+// right are left alone, and threads are rewritten in id batches between
+// which normal traffic carries on.
+func recountAggregates(ctx context.Context, db *sql.DB) (recountResult, error) {
+	var result recountResult
+	var maxID sql.NullInt64
+	if err := db.QueryRowContext(ctx, `SELECT MAX(id) FROM threads`).Scan(&maxID); err != nil {
+		return result, err
+	}
+	const lastBump = `COALESCE((
+			SELECT MAX(p.created) FROM posts p
+			WHERE p.thread_id = threads.id AND (p.email IS NULL OR p.email <> 'sage')
+		), threads.created)`
+	for lo := int64(0); lo < maxID.Int64; lo += recountBatchSize {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		batchCtx, cancel := withQueryTimeout(ctx)
+		res, err := db.ExecContext(batchCtx, `
+			UPDATE threads SET last_bump = `+lastBump+`
+			WHERE id > $1 AND id <= $2 AND last_bump IS DISTINCT FROM `+lastBump,
+			lo, lo+recountBatchSize)
+		cancel()
+		if err != nil {
+			return result, err
+		}
+		fixed, err := res.RowsAffected()
+		if err != nil {
+			return result, err
+		}
+		result.ThreadsFixed += int(fixed)
+	}
+
+	boardCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	res, err := db.ExecContext(boardCtx, `
+		UPDATE boards SET post_counter = (
+			SELECT MAX(p.board_number) FROM posts p
+			JOIN threads t ON t.id = p.thread_id
+			WHERE t.board_id = boards.id
+		)
+		WHERE post_counter < COALESCE((
+			SELECT MAX(p.board_number) FROM posts p
+			JOIN threads t ON t.id = p.thread_id
+			WHERE t.board_id = boards.id
+		), 0)`)
+	if err != nil {
+		return result, err
+	}
+	fixed, err := res.RowsAffected()
+	if err != nil {
+		return result, err
+	}
+	result.BoardsFixed = int(fixed)
+	return result, nil
+}
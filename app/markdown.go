@@ -2,34 +2,541 @@ package app
 
 import (
 	"bytes"
+	"fmt"
+	"html"
 	"html/template"
+	"net/url"
+	"regexp"
 	"strings"
 
 	"github.com/microcosm-cc/bluemonday"
 	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/extension"
-	"github.com/yuin/goldmark/renderer/html"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// gfm is GitHub Flavored Markdown with table alignment written as align
+// attributes, which the sanitizer keeps, rather than inline styles, which
+// it strips.
+var gfm = goldmark.WithExtensions(
+	extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+	extension.Strikethrough,
+	extension.Linkify,
+	extension.TaskList,
+	spoilers,
 )
 
 var markdownRenderer = goldmark.New(
-	goldmark.WithExtensions(
-		extension.GFM,
+	gfm,
+	goldmark.WithParserOptions(
+		parser.WithASTTransformers(util.Prioritized(markdownClasses{}, 100)),
+	),
+	goldmark.WithRendererOptions(
+		gmhtml.WithHardWraps(),
+	),
+)
+
+// postMarkdownRenderer is markdownRenderer plus heading anchors, so a
+// thread's table of contents can link into its opening post.
+var postMarkdownRenderer = goldmark.New(
+	gfm,
+	goldmark.WithParserOptions(
+		parser.WithAutoHeadingID(),
+		parser.WithASTTransformers(util.Prioritized(markdownClasses{}, 100)),
 	),
 	goldmark.WithRendererOptions(
-		html.WithHardWraps(),
+		gmhtml.WithHardWraps(),
 	),
 )
 
-var mdPolicy = bluemonday.UGCPolicy()
+var mdPolicy = newMarkdownPolicy()
+
+// markdownClassPattern matches the classes markdownClasses adds; users
+// can't set any others.
+var markdownClassPattern = regexp.MustCompile(`^(md-table|task-list|task-list-item)$`)
+
+// newMarkdownPolicy is bluemonday's UGC policy plus what rendered GFM needs:
+// table cell alignment, read-only task list checkboxes, the classes
+// markdownClasses adds, and focusable spoiler spans.
+func newMarkdownPolicy() *bluemonday.Policy {
+	policy := bluemonday.UGCPolicy()
+	// externalLinks adds rel itself, and only to links that leave the site.
+	policy.RequireNoFollowOnLinks(false)
+	policy.AllowAttrs("align").Matching(regexp.MustCompile(`^(left|center|right)$`)).OnElements("th", "td")
+	policy.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	policy.AllowAttrs("checked", "disabled").OnElements("input")
+	policy.AllowAttrs("class").Matching(markdownClassPattern).OnElements("table", "ul", "ol", "li")
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^spoiler$`)).OnElements("span")
+	policy.AllowAttrs("tabindex").Matching(regexp.MustCompile(`^0$`)).OnElements("span")
+	return policy
+}
+
+// markdownClasses tags tables and task lists with classes the stylesheet
+// hooks into.
+type markdownClasses struct{}
+
+func (markdownClasses) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	_ = ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node.Kind() {
+		case east.KindTable:
+			node.SetAttributeString("class", []byte("md-table"))
+		case east.KindTaskCheckBox:
+			// The checkbox sits in the item's first paragraph or text block.
+			if item := node.Parent().Parent(); item != nil && item.Kind() == ast.KindListItem {
+				item.SetAttributeString("class", []byte("task-list-item"))
+				if list := item.Parent(); list != nil {
+					list.SetAttributeString("class", []byte("task-list"))
+				}
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+}
 
 func renderMarkdown(input string) template.HTML {
+	return convertMarkdown(markdownRenderer, input)
+}
+
+func convertMarkdown(md goldmark.Markdown, input string, opts ...parser.ParseOption) template.HTML {
+	return finishMarkdown(sanitizedMarkdown(md, input, opts...))
+}
+
+// sanitizedMarkdown renders input and runs it through the sanitizer. The
+// result depends only on input and the renderer, so it's what gets stored
+// in posts.rendered_content.
+func sanitizedMarkdown(md goldmark.Markdown, input string, opts ...parser.ParseOption) string {
 	if strings.TrimSpace(input) == "" {
-		return template.HTML("")
+		return ""
 	}
 	var buf bytes.Buffer
-	if err := markdownRenderer.Convert([]byte(input), &buf); err != nil {
-		return template.HTML(template.HTMLEscapeString(input))
+	if err := md.Convert([]byte(input), &buf, opts...); err != nil {
+		return template.HTMLEscapeString(input)
+	}
+	return string(mdPolicy.SanitizeBytes(buf.Bytes()))
+}
+
+// finishMarkdown applies the passes that depend on configuration, the image
+// policy and the base URL, to sanitized HTML. They run on every render so a
+// settings change reaches stored posts too.
+func finishMarkdown(sanitized string) template.HTML {
+	return template.HTML(externalLinks(constrainImages(sanitized)))
+}
+
+// externalLinkAttrs keep search engines from crediting the site for links
+// users post, and open them in a new tab without giving the target a handle
+// on this page.
+const externalLinkAttrs = ` rel="nofollow ugc noopener" target="_blank"`
+
+var sanitizedLinkPattern = regexp.MustCompile(`<a href="([^"]*)"`)
+
+// externalLinks marks links to other sites in sanitized HTML with
+// externalLinkAttrs. Relative links and links to the base URL's host are
+// left alone.
+func externalLinks(sanitized string) string {
+	return sanitizedLinkPattern.ReplaceAllStringFunc(sanitized, func(tag string) string {
+		href := html.UnescapeString(sanitizedLinkPattern.FindStringSubmatch(tag)[1])
+		if !isExternalLink(href) {
+			return tag
+		}
+		return tag + externalLinkAttrs
+	})
+}
+
+func isExternalLink(href string) bool {
+	target, err := url.Parse(href)
+	if err != nil || target.Host == "" {
+		return false
+	}
+	if target.Scheme != "" && target.Scheme != "http" && target.Scheme != "https" {
+		return false
+	}
+	if site, err := url.Parse(baseURL); err == nil && strings.EqualFold(target.Host, site.Host) {
+		return false
+	}
+	return true
+}
+
+// ------------------- Images -------------------
+
+// Remote image policies for markdown images hosted on other sites.
+const (
+	remoteImagesAllow = "allow"
+	remoteImagesProxy = "proxy"
+	remoteImagesBlock = "block"
+)
+
+// ImageConfig bounds markdown images and decides what happens to ones
+// hosted elsewhere, which would otherwise tell their host who read the post.
+type ImageConfig struct {
+	// Remote is "allow", "proxy", or "block".
+	Remote string
+	// Proxy is the image proxy URL, with {url} where the escaped image URL
+	// goes, such as "https://camo.example.com/?url={url}".
+	Proxy string
+	// MaxWidth and MaxHeight cap the displayed size, in CSS pixels.
+	MaxWidth  int
+	MaxHeight int
+}
+
+func defaultImageConfig() ImageConfig {
+	return ImageConfig{Remote: remoteImagesAllow, MaxWidth: 640, MaxHeight: 480}
+}
+
+// loadImageConfig reads JANK_REMOTE_IMAGES, JANK_IMAGE_PROXY,
+// JANK_IMAGE_MAX_WIDTH, and JANK_IMAGE_MAX_HEIGHT. Proxy mode without a
+// usable proxy URL blocks remote images rather than loading them directly.
+func loadImageConfig(l *configLoader) ImageConfig {
+	cfg := defaultImageConfig()
+	switch raw := strings.ToLower(getenvTrim("JANK_REMOTE_IMAGES")); raw {
+	case "":
+	case remoteImagesAllow, remoteImagesProxy, remoteImagesBlock:
+		cfg.Remote = raw
+	default:
+		l.invalid("JANK_REMOTE_IMAGES", raw, cfg.Remote)
+	}
+	cfg.Proxy = getenvTrim("JANK_IMAGE_PROXY")
+	if cfg.Remote == remoteImagesProxy {
+		if proxy, err := url.Parse(cfg.Proxy); err != nil || !strings.Contains(cfg.Proxy, "{url}") ||
+			(proxy.Scheme != "http" && proxy.Scheme != "https") {
+			l.addf("JANK_REMOTE_IMAGES is proxy but JANK_IMAGE_PROXY %q is not an http(s) URL with {url}; blocking remote images", cfg.Proxy)
+			cfg.Remote = remoteImagesBlock
+		}
+	}
+	cfg.MaxWidth = l.int("JANK_IMAGE_MAX_WIDTH", cfg.MaxWidth)
+	cfg.MaxHeight = l.int("JANK_IMAGE_MAX_HEIGHT", cfg.MaxHeight)
+	return cfg
+}
+
+var sanitizedImagePattern = regexp.MustCompile(`<img ([^>]*?)/?>`)
+
+var imageAttrPattern = regexp.MustCompile(`([a-z]+)="([^"]*)"`)
+
+// constrainImages applies markdownImages to the images in sanitized HTML.
+// Every kept image gets the md-image class, lazy loading, and the size cap;
+// remote ones are also proxied, or reduced to their alt text when blocked.
+func constrainImages(sanitized string) string {
+	return sanitizedImagePattern.ReplaceAllStringFunc(sanitized, func(tag string) string {
+		var src, alt, title string
+		for _, attr := range imageAttrPattern.FindAllStringSubmatch(sanitizedImagePattern.FindStringSubmatch(tag)[1], -1) {
+			switch attr[1] {
+			case "src":
+				src = attr[2]
+			case "alt":
+				alt = attr[2]
+			case "title":
+				title = attr[2]
+			}
+		}
+		if isExternalLink(html.UnescapeString(src)) {
+			switch markdownImages.Remote {
+			case remoteImagesBlock:
+				if alt == "" {
+					alt = "image"
+				}
+				return "[" + alt + "]"
+			case remoteImagesProxy:
+				src = html.EscapeString(strings.ReplaceAll(markdownImages.Proxy, "{url}", url.QueryEscape(html.UnescapeString(src))))
+			}
+		}
+		img := fmt.Sprintf(`<img class="md-image" src="%s" alt="%s"`, src, alt)
+		if title != "" {
+			img += fmt.Sprintf(` title="%s"`, title)
+		}
+		return img + fmt.Sprintf(` loading="lazy" style="max-width: min(100%%, %dpx); max-height: %dpx">`,
+			markdownImages.MaxWidth, markdownImages.MaxHeight)
+	})
+}
+
+// ------------------- Spoilers -------------------
+
+// kindSpoiler is the AST kind of ||spoiler|| text.
+var kindSpoiler = ast.NewNodeKind("Spoiler")
+
+// spoilerNode hides its children until the reader hovers, taps, or tabs to
+// it.
+type spoilerNode struct {
+	ast.BaseInline
+}
+
+func (n *spoilerNode) Kind() ast.NodeKind { return kindSpoiler }
+
+func (n *spoilerNode) Dump(source []byte, level int) { ast.DumpHelper(n, source, level, nil, nil) }
+
+// spoilerDelimiters pairs || runs the way ~~ pairs for strikethrough, so
+// emphasis and links still work inside a spoiler and code spans, which are
+// parsed first, keep their pipes.
+type spoilerDelimiters struct{}
+
+func (spoilerDelimiters) IsDelimiter(b byte) bool { return b == '|' }
+
+func (spoilerDelimiters) CanOpenCloser(opener, closer *parser.Delimiter) bool {
+	return opener.Char == closer.Char
+}
+
+func (spoilerDelimiters) OnMatch(consumes int) ast.Node { return &spoilerNode{} }
+
+type spoilerParser struct{}
+
+func (spoilerParser) Trigger() []byte { return []byte{'|'} }
+
+func (spoilerParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	before := block.PrecendingCharacter()
+	line, segment := block.PeekLine()
+	node := parser.ScanDelimiter(line, before, 2, spoilerDelimiters{})
+	if node == nil || node.OriginalLength != 2 || before == '|' {
+		return nil
+	}
+	node.Segment = segment.WithStop(segment.Start + node.OriginalLength)
+	block.Advance(node.OriginalLength)
+	pc.PushDelimiter(node)
+	return node
+}
+
+type spoilerRenderer struct{}
+
+func (spoilerRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindSpoiler, func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			_, _ = w.WriteString(`<span class="spoiler" tabindex="0">`)
+		} else {
+			_, _ = w.WriteString(`</span>`)
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+type spoilerExtension struct{}
+
+// spoilers adds ||spoiler|| syntax.
+var spoilers goldmark.Extender = spoilerExtension{}
+
+func (spoilerExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(util.Prioritized(spoilerParser{}, 500)))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(spoilerRenderer{}, 500)))
+}
+
+// headingIDs gives a post's headings slug anchors under a per-post prefix,
+// so equal headings in different posts don't share an id.
+type headingIDs struct {
+	prefix string
+	used   map[string]bool
+}
+
+func newHeadingIDs(postID int) *headingIDs {
+	return &headingIDs{prefix: fmt.Sprintf("post-%d-", postID), used: make(map[string]bool)}
+}
+
+func (ids *headingIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	slug := slugify(string(value))
+	if slug == "" {
+		slug = "section"
+	}
+	id := ids.prefix + slug
+	for i := 1; ids.used[id]; i++ {
+		id = fmt.Sprintf("%s%s-%d", ids.prefix, slug, i)
+	}
+	ids.used[id] = true
+	return []byte(id)
+}
+
+func (ids *headingIDs) Put(value []byte) {
+	ids.used[string(value)] = true
+}
+
+// slugify lowercases value and joins its ASCII letters and digits with dashes.
+func slugify(value string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(value) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
+
+func postParseContext(post *Post) parser.ParseOption {
+	return parser.WithContext(parser.NewContext(parser.WithIDs(newHeadingIDs(post.ID))))
+}
+
+// postHeadings lists a post's markdown headings with the anchors
+// renderPostContent gives them.
+func postHeadings(post *Post) []TOCEntry {
+	source := []byte(post.Content)
+	doc := postMarkdownRenderer.Parser().Parse(text.NewReader(source), postParseContext(post))
+	var entries []TOCEntry
+	_ = ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := node.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		var anchor string
+		if id, ok := heading.AttributeString("id"); ok {
+			if value, ok := id.([]byte); ok {
+				anchor = string(value)
+			}
+		}
+		entries = append(entries, TOCEntry{
+			Level:  heading.Level,
+			Text:   headingText(heading, source),
+			Anchor: anchor,
+		})
+		return ast.WalkSkipChildren, nil
+	})
+	return entries
+}
+
+// headingText joins the plain text under a heading, dropping markup.
+func headingText(node ast.Node, source []byte) string {
+	var b strings.Builder
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		switch n := child.(type) {
+		case *ast.Text:
+			b.Write(n.Segment.Value(source))
+		case *ast.String:
+			b.Write(n.Value)
+		default:
+			b.WriteString(headingText(child, source))
+		}
+	}
+	return b.String()
+}
+
+// stripMarkdown returns the plain text of a markdown document, with block
+// boundaries as spaces, for excerpts that shouldn't show raw markup.
+func stripMarkdown(input string) string {
+	source := []byte(input)
+	doc := markdownRenderer.Parser().Parse(text.NewReader(source))
+	var b strings.Builder
+	_ = ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			if node.Type() == ast.TypeBlock {
+				b.WriteByte(' ')
+			}
+			return ast.WalkContinue, nil
+		}
+		switch n := node.(type) {
+		case *ast.Text:
+			b.Write(n.Segment.Value(source))
+			if n.SoftLineBreak() || n.HardLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(n.Value)
+		case *ast.AutoLink:
+			b.Write(n.URL(source))
+			return ast.WalkSkipChildren, nil
+		case *spoilerNode:
+			b.WriteString("[spoiler]")
+			return ast.WalkSkipChildren, nil
+		case *ast.CodeBlock, *ast.FencedCodeBlock, *ast.HTMLBlock:
+			lines := n.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				segment := lines.At(i)
+				b.Write(segment.Value(source))
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// threadTOC builds a table of contents from the opening post's headings,
+// or nil when there aren't at least two to jump between.
+func threadTOC(thread *Thread) []TOCEntry {
+	if len(thread.Posts) == 0 || thread.Posts[0].IsDeleted {
+		return nil
+	}
+	entries := postHeadings(thread.Posts[0])
+	if len(entries) < 2 {
+		return nil
+	}
+	return entries
+}
+
+// escapedCrossReferencePattern is crossReferencePattern as it appears in
+// sanitized HTML.
+var escapedCrossReferencePattern = regexp.MustCompile(`&gt;&gt;&gt;/[A-Za-z0-9_-]+/\d+|&gt;&gt;\d+/\d+`)
+
+// htmlCodePattern matches the code spans and blocks in sanitized HTML,
+// whose text is shown as written rather than linked.
+var htmlCodePattern = regexp.MustCompile(`(?s)<pre[\s>].*?</pre>|<code[\s>].*?</code>`)
+
+// replaceOutsideCode is pattern.ReplaceAllStringFunc applied only to the
+// parts of html outside code spans and blocks.
+func replaceOutsideCode(html string, pattern *regexp.Regexp, repl func(string) string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range htmlCodePattern.FindAllStringIndex(html, -1) {
+		b.WriteString(pattern.ReplaceAllStringFunc(html[last:loc[0]], repl))
+		b.WriteString(html[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	b.WriteString(pattern.ReplaceAllStringFunc(html[last:], repl))
+	return b.String()
+}
+
+// markdownRenderVersion is stored with each post's rendered HTML. Bump it
+// when a renderer or sanitizer change should reach existing posts: reads
+// render stale ones through postRenderCache without writing them back, and
+// the rerender maintenance task rewrites the stored HTML.
+const markdownRenderVersion = 1
+
+// renderPostMarkdown is the sanitized HTML stored for post.
+func renderPostMarkdown(post *Post) string {
+	return sanitizedMarkdown(postMarkdownRenderer, post.Content, postParseContext(post))
+}
+
+// renderPostContent renders a post's markdown with heading anchors and turns
+// its resolved cross-thread references into links. Unresolved ones, and
+// any inside code, stay plain text. Stored HTML is used when it's current;
+// otherwise the markdown HTML comes from postRenderCache. Links are applied
+// fresh, since their targets can be deleted.
+func renderPostContent(post *Post) template.HTML {
+	render := func() template.HTML {
+		return finishMarkdown(renderPostMarkdown(post))
+	}
+	var rendered template.HTML
+	switch {
+	case storeRenderedPosts && post.RenderVersion == markdownRenderVersion:
+		rendered = finishMarkdown(post.RenderedContent)
+	case post.ID > 0:
+		rendered = postRenderCache.render(post.ID, post.Content, render)
+	default:
+		rendered = render()
+	}
+	if len(post.Links) == 0 {
+		return rendered
+	}
+	links := make(map[string]*PostLink, len(post.Links))
+	for _, link := range post.Links {
+		links[template.HTMLEscapeString(link.Ref)] = link
 	}
-	safe := mdPolicy.SanitizeBytes(buf.Bytes())
-	return template.HTML(safe)
+	linked := replaceOutsideCode(string(rendered), escapedCrossReferencePattern, func(match string) string {
+		link, ok := links[match]
+		if !ok {
+			return match
+		}
+		return fmt.Sprintf(`<a class="post-quote post-crosslink" href="%s" title="%s" data-preview="%s">%s</a>`,
+			template.HTMLEscapeString(link.Path),
+			template.HTMLEscapeString(link.Title),
+			template.HTMLEscapeString(link.Preview),
+			match)
+	})
+	return template.HTML(linked)
 }
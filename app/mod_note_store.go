@@ -0,0 +1,76 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// maxModNoteLength caps a moderator note, in characters.
+const maxModNoteLength = 2000
+
+// createUserModNote appends a moderator's private note about username.
+func createUserModNote(ctx context.Context, db *sql.DB, username, body, author string) (*UserModNote, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	now := time.Now()
+	var id int
+	if dbDriver == "pgx" {
+		err := db.QueryRowContext(ctx, `
+			INSERT INTO user_mod_notes (username, body, author, created)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id`,
+			username, body, author, now).Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		result, err := db.ExecContext(ctx, `
+			INSERT INTO user_mod_notes (username, body, author, created)
+			VALUES ($1, $2, $3, $4)`,
+			username, body, author, now)
+		if err != nil {
+			return nil, err
+		}
+		insertID, err := result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		id = int(insertID)
+	}
+	return &UserModNote{
+		ID:       id,
+		Username: username,
+		Body:     body,
+		Author:   author,
+		Created:  now,
+	}, nil
+}
+
+// getUserModNotes lists the notes about username, oldest first.
+func getUserModNotes(ctx context.Context, db *sql.DB, username string) ([]*UserModNote, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, username, body, author, created
+		FROM user_mod_notes
+		WHERE username = $1
+		ORDER BY created, id`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []*UserModNote{}
+	for rows.Next() {
+		var n UserModNote
+		if err := rows.Scan(&n.ID, &n.Username, &n.Body, &n.Author, &n.Created); err != nil {
+			return nil, err
+		}
+		notes = append(notes, &n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"encoding/json"
 	"math/big"
 	"time"
 )
@@ -9,18 +10,150 @@ import (
 
 // Board represents a message board.
 type Board struct {
-	ID          int       `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Threads     []*Thread `json:"threads,omitempty"`
+	ID            int       `json:"id"`
+	Name          string    `json:"name"`
+	Description   string    `json:"description"`
+	Rules         string    `json:"rules,omitempty"`
+	Visibility    string    `json:"visibility"`
+	PostNumbering string    `json:"post_numbering"`
+	AnonName      string    `json:"anon_name,omitempty"`
+	RequireTitle  bool      `json:"require_title"`
+	NecroDays     *int      `json:"necro_days,omitempty"`
+	RetentionDays *int      `json:"retention_days,omitempty"`
+	Threads       []*Thread `json:"threads,omitempty"`
+}
+
+// defaultAnonName labels authorless posts on boards that don't set their own.
+const defaultAnonName = "Anonymous"
+
+// AnonLabel is the name b's authorless posts are shown under.
+func (b *Board) AnonLabel() string {
+	if b.AnonName != "" {
+		return b.AnonName
+	}
+	return defaultAnonName
+}
+
+// Board visibility settings. Restricted boards are only shown to their
+// members and moderators.
+const (
+	boardVisibilityPublic     = "public"
+	boardVisibilityRestricted = "restricted"
+)
+
+// Board post number formats for the thread view: the site-wide post ID, a
+// count of posts on the board, or no number at all.
+const (
+	postNumberingGlobal = "global"
+	postNumberingBoard  = "board"
+	postNumberingHidden = "hidden"
+)
+
+// BoardInfo is the public metadata for a board, including its rendered rules.
+type BoardInfo struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Rules       string `json:"rules"`
+	RulesHTML   string `json:"rules_html"`
+	ThreadCount int    `json:"thread_count"`
+}
+
+// BoardSummary is the slimmed-down board served by /boards/minimal, for
+// clients that only need to build navigation.
+type BoardSummary struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Slug        string `json:"slug"`
+	ThreadCount int    `json:"thread_count"`
+}
+
+// Identity is who /whoami says the caller is. Via is "bearer" or "cookie".
+type Identity struct {
+	Username string   `json:"username"`
+	Roles    []string `json:"roles"`
+	Via      string   `json:"via"`
 }
 
 // User represents a forum user.
 type User struct {
-	ID           int       `json:"id"`
-	Username     string    `json:"username"`
-	PasswordHash string    `json:"-"`
-	Created      time.Time `json:"created"`
+	ID           int        `json:"id"`
+	Username     string     `json:"username"`
+	PasswordHash string     `json:"-"`
+	Created      time.Time  `json:"created"`
+	LastLogin    *time.Time `json:"last_login,omitempty"`
+}
+
+// LoginRecord is one successful sign-in, kept so users can spot access they
+// don't recognize.
+type LoginRecord struct {
+	Created   time.Time `json:"created"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+}
+
+// UserSummary is a row on the moderator's user management page.
+type UserSummary struct {
+	ID           int
+	Username     string
+	Created      time.Time
+	DisabledAt   *time.Time
+	PostCount    int
+	WarningCount int
+}
+
+// UserWarning is a moderator's warning to a user, usually about one post.
+type UserWarning struct {
+	ID             int        `json:"id"`
+	Username       string     `json:"username"`
+	PostID         *int       `json:"post_id,omitempty"`
+	ThreadID       int        `json:"thread_id,omitempty"`
+	Reason         string     `json:"reason"`
+	IssuedBy       string     `json:"issued_by"`
+	Created        time.Time  `json:"created"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+}
+
+// UserModNote is a private moderator note about an account. Notes are
+// append-only.
+type UserModNote struct {
+	ID       int       `json:"id"`
+	Username string    `json:"username"`
+	Body     string    `json:"body"`
+	Author   string    `json:"author"`
+	Created  time.Time `json:"created"`
+}
+
+// IPPost is a post found by address, with the thread and board it's on.
+type IPPost struct {
+	ID          int       `json:"id"`
+	IP          string    `json:"ip"`
+	Author      string    `json:"author"`
+	Content     string    `json:"content"`
+	Created     time.Time `json:"created"`
+	IsDeleted   bool      `json:"is_deleted"`
+	ThreadID    int       `json:"thread_id"`
+	ThreadTitle string    `json:"thread_title"`
+	BoardID     int       `json:"board_id"`
+	BoardName   string    `json:"board_name"`
+}
+
+// Attachment is an uploaded image and its thumbnail. URLs come from the
+// AttachmentStore when the attachment is loaded and aren't stored.
+type Attachment struct {
+	ID              int       `json:"id"`
+	Key             string    `json:"key"`
+	URL             string    `json:"url"`
+	ContentType     string    `json:"content_type"`
+	Size            int       `json:"size"`
+	Width           int       `json:"width"`
+	Height          int       `json:"height"`
+	ThumbnailKey    string    `json:"thumbnail_key"`
+	ThumbnailURL    string    `json:"thumbnail_url"`
+	ThumbnailWidth  int       `json:"thumbnail_width"`
+	ThumbnailHeight int       `json:"thumbnail_height"`
+	UploadedBy      string    `json:"uploaded_by"`
+	Created         time.Time `json:"created"`
 }
 
 // Thread represents a discussion thread on a board.
@@ -35,6 +168,22 @@ type Thread struct {
 	LastBump   time.Time `json:"-"`
 	CardTags   []string  `json:"-"`
 	Excerpt    string    `json:"excerpt,omitempty"`
+	Omitted    int       `json:"omitted,omitempty"`
+
+	AcceptedPostID  *int `json:"accepted_post_id,omitempty"`
+	SlowModeSeconds int  `json:"slow_mode_seconds,omitempty"`
+	IsLocked        bool `json:"is_locked,omitempty"`
+	IsSticky        bool `json:"is_sticky,omitempty"`
+}
+
+// ThreadPreview holds the opening post and most recent replies of a thread.
+type ThreadPreview struct {
+	OP      *Post
+	Tail    []*Post
+	Omitted int
+	Total   int
+	// LiveReplies counts the replies that haven't been deleted.
+	LiveReplies int
 }
 
 // ThreadSearchResult represents a thread search hit with board context.
@@ -47,6 +196,34 @@ type ThreadSearchResult struct {
 	Created   time.Time
 }
 
+// RecentThread is a newly created thread with board context, used for feeds.
+type RecentThread struct {
+	ID        int
+	BoardID   int
+	BoardName string
+	Title     string
+	Author    string
+	Tags      []string
+	Created   time.Time
+}
+
+// TrendingThread is a thread ranked by how many posts it got recently.
+type TrendingThread struct {
+	ID          int    `json:"id"`
+	BoardID     int    `json:"board_id"`
+	BoardName   string `json:"board_name"`
+	Title       string `json:"title"`
+	Author      string `json:"author"`
+	RecentPosts int    `json:"recent_posts"`
+}
+
+// ThreadPage is one cursor-paginated slice of a board's threads in bump order.
+type ThreadPage struct {
+	Threads    []*Thread `json:"threads"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	HasMore    bool      `json:"has_more"`
+}
+
 // CardTree represents a scoped tree of cards with annotations.
 type CardTree struct {
 	ID          int             `json:"id"`
@@ -58,9 +235,19 @@ type CardTree struct {
 	CreatedAt   time.Time       `json:"created_at"`
 	UpdatedAt   time.Time       `json:"updated_at"`
 	IsPrimary   bool            `json:"is_primary"`
+	NodeCount   int             `json:"node_count,omitempty"`
 	Nodes       []*CardTreeNode `json:"nodes,omitempty"`
 }
 
+// CardTreePage is one page of a user's card trees.
+type CardTreePage struct {
+	Trees   []*CardTree `json:"trees"`
+	Total   int         `json:"total"`
+	Limit   int         `json:"limit"`
+	Offset  int         `json:"offset"`
+	HasMore bool        `json:"has_more"`
+}
+
 // CardTreeNode represents a card in a tree with optional annotations.
 type CardTreeNode struct {
 	ID          int                   `json:"id"`
@@ -89,6 +276,33 @@ type CardTreeAnnotation struct {
 	CreatedAt    time.Time `json:"created_at"`
 }
 
+// CardTreeGraph is a card tree as nodes and parent->child edges, the shape
+// graph and visualization libraries take.
+type CardTreeGraph struct {
+	TreeID int                 `json:"tree_id"`
+	Title  string              `json:"title"`
+	Nodes  []CardTreeGraphNode `json:"nodes"`
+	Edges  []CardTreeGraphEdge `json:"edges"`
+}
+
+// CardTreeGraphNode is one card in a CardTreeGraph.
+type CardTreeGraphNode struct {
+	ID          int    `json:"id"`
+	Label       string `json:"label"`
+	ParentID    *int   `json:"parent_id"`
+	Position    int    `json:"position"`
+	Depth       int    `json:"depth"`
+	Indent      int    `json:"indent"`
+	Annotations int    `json:"annotations"`
+}
+
+// CardTreeGraphEdge links a node to its parent.
+type CardTreeGraphEdge struct {
+	ID     string `json:"id"`
+	Source int    `json:"source"`
+	Target int    `json:"target"`
+}
+
 // Post represents an individual post in a thread.
 type Post struct {
 	ID            int         `json:"id"`
@@ -96,21 +310,74 @@ type Post struct {
 	Content       string      `json:"content"`
 	Created       time.Time   `json:"created"`
 	Number        *big.Int    `json:"number"`
+	BoardNumber   int         `json:"board_number,omitempty"`
 	Flair         string      `json:"flair"`
+	Badge         string      `json:"badge,omitempty"`
+	Score         int         `json:"score"`
+	MyVote        int         `json:"-"`
+	Email         string      `json:"-"`
 	Trees         []*CardTree `json:"trees,omitempty"`
+	Links         []*PostLink `json:"links,omitempty"`
 	IsDeleted     bool        `json:"-"`
+	IsYou         bool        `json:"-"`
+	RepliesToYou  bool        `json:"-"`
+	QuoteCount    int         `json:"-"`
+	IsAccepted    bool        `json:"-"`
+	Collapsible   bool        `json:"-"`
+	Preview       string      `json:"-"`
 	DeletedAt     *time.Time  `json:"-"`
 	DeletedBy     string      `json:"-"`
 	DeletedReason string      `json:"-"`
+	// RenderedContent is the stored sanitized HTML of Content, current when
+	// RenderVersion is markdownRenderVersion.
+	RenderedContent string `json:"-"`
+	RenderVersion   int    `json:"-"`
+}
+
+// MarshalJSON leaves out the score when voting is turned off.
+func (p Post) MarshalJSON() ([]byte, error) {
+	type post Post
+	if postVotesEnabled {
+		return json.Marshal(post(p))
+	}
+	return json.Marshal(struct {
+		post
+		Score *int `json:"score,omitempty"`
+	}{post: post(p)})
+}
+
+// PostLink is a resolved cross-thread reference in a post, with enough of
+// the target to show a preview tooltip.
+type PostLink struct {
+	Ref      string `json:"ref"`
+	URL      string `json:"url"`
+	Path     string `json:"-"`
+	ThreadID int    `json:"thread_id"`
+	PostID   int    `json:"post_id,omitempty"`
+	Title    string `json:"title"`
+	Author   string `json:"author,omitempty"`
+	Preview  string `json:"preview"`
 }
 
 // Klaxon represents a site-wide announcement banner.
 type Klaxon struct {
-	ID        int
-	Tone      string
-	Emoji     string
-	Message   string
-	UpdatedAt time.Time
+	ID        int       `json:"id"`
+	Tone      string    `json:"tone"`
+	Emoji     string    `json:"emoji"`
+	Message   string    `json:"message"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// KlaxonChange is one entry in the append-only klaxon history: a banner
+// being set ("set") or cleared ("clear") by Actor.
+type KlaxonChange struct {
+	ID      int
+	Action  string
+	Tone    string
+	Emoji   string
+	Message string
+	Actor   string
+	Created time.Time
 }
 
 // ------------------- Template Data -------------------
@@ -121,12 +388,15 @@ type IndexViewData struct {
 	Title       string
 	Description string
 	Boards      []*Board
+	Trending    []*TrendingThread
 }
 
 // BoardViewData holds data for the board.html template.
 type BoardViewData struct {
 	AuthViewData
-	Board *Board
+	Board       *Board
+	Sort        string
+	SortOptions []string
 }
 
 // ThreadViewData holds data for the thread.html template.
@@ -137,13 +407,34 @@ type ThreadViewData struct {
 	LastBump              time.Time
 	BumpCooldownRemaining int
 	NecroWarning          bool
+	NecroThreshold        time.Duration
 	ReportCategories      []string
+	ShowPostEmail         bool
+	AcceptedPost          *Post
+	CanAcceptAnswer       bool
+	VotesEnabled          bool
+	Sort                  string
+	TOC                   []TOCEntry
+	SlowModeInterval      string
+	SlowModeRemaining     int
+	CanSetSlowMode        bool
+	PostNumbering         string
+	// AnonName labels authorless posts and the anonymous reply option.
+	AnonName string
+}
+
+// TOCEntry is one heading in a thread's table of contents.
+type TOCEntry struct {
+	Level  int
+	Text   string
+	Anchor string
 }
 
 // NewThreadViewData holds data for the new_thread.html template.
 type NewThreadViewData struct {
 	AuthViewData
-	BoardID int
+	BoardID      int
+	RequireTitle bool
 }
 
 // SearchViewData holds data for the search page.
@@ -156,17 +447,22 @@ type SearchViewData struct {
 // ProfileViewData holds data for the profile.html template.
 type ProfileViewData struct {
 	AuthViewData
-	User    *User
-	Threads []*ProfileThread
-	Posts   []*ProfilePost
+	User      *User
+	Threads   []*ProfileThread
+	Posts     []*ProfilePost
+	TreeCount int
+	Logins    []*LoginRecord
 }
 
 // PublicProfileViewData holds data for the public profile page.
 type PublicProfileViewData struct {
 	AuthViewData
-	User    *User
-	Threads []*ProfileThread
-	Posts   []*ProfilePost
+	User      *User
+	Threads   []*ProfileThread
+	Posts     []*ProfilePost
+	TreeCount int
+	// ModNotes is only loaded for moderators.
+	ModNotes []*UserModNote
 }
 
 // UserLookupViewData holds data for the username lookup page.
@@ -199,19 +495,28 @@ type ErrorViewData struct {
 
 // ProfileThread is a lightweight thread view for profiles.
 type ProfileThread struct {
-	ID      int
-	BoardID int
-	Title   string
-	Created time.Time
+	ID      int       `json:"id"`
+	BoardID int       `json:"board_id"`
+	Title   string    `json:"title"`
+	Created time.Time `json:"created"`
 }
 
 // ProfilePost is a lightweight post view for profiles.
 type ProfilePost struct {
-	ID          int
-	ThreadID    int
-	ThreadTitle string
-	Content     string
-	Created     time.Time
+	ID          int       `json:"id"`
+	ThreadID    int       `json:"thread_id"`
+	ThreadTitle string    `json:"thread_title"`
+	Content     string    `json:"content"`
+	Created     time.Time `json:"created"`
+}
+
+// UserExport is the bundle served by /profile/export.
+type UserExport struct {
+	ExportedAt time.Time        `json:"exported_at"`
+	User       *User            `json:"user"`
+	Threads    []*ProfileThread `json:"threads"`
+	Posts      []*ProfilePost   `json:"posts"`
+	Trees      []*CardTree      `json:"trees"`
 }
 
 // AuthViewData holds shared auth template values.
@@ -222,6 +527,9 @@ type AuthViewData struct {
 	IsModerator     bool
 	SearchQuery     string
 	Klaxon          *Klaxon
+	CSPNonce        string
+	// Warnings are the signed-in user's unacknowledged moderator warnings.
+	Warnings []*UserWarning
 }
 
 // Report represents a moderation report.
@@ -257,10 +565,50 @@ type ModReportsViewData struct {
 	Reports []*ModReport
 }
 
+// ReportCount is one row of a report breakdown, such as a category or board.
+type ReportCount struct {
+	Label string
+	Count int
+}
+
+// ReportStats summarizes the report queue for the moderator dashboard.
+type ReportStats struct {
+	OpenCount        int
+	ByCategory       []ReportCount
+	ByBoard          []ReportCount
+	OldestOpen       *time.Time
+	Window           time.Duration
+	OpenedInWindow   int
+	ResolvedInWindow int
+	AvgResolution    time.Duration
+}
+
+// ModDashboardViewData holds data for the moderator dashboard.
+type ModDashboardViewData struct {
+	AuthViewData
+	Stats         *ReportStats
+	WindowDays    int
+	OldestOpenAge string
+	AvgResolution string
+}
+
+// ModIPViewData holds data for the moderator IP lookup.
+type ModIPViewData struct {
+	AuthViewData
+	Network   string
+	Addresses []string
+	Posts     []*IPPost
+	Total     int
+	Banned    bool
+	PrevURL   string
+	NextURL   string
+}
+
 // KlaxonAdminViewData holds data for the klaxon admin page.
 type KlaxonAdminViewData struct {
 	AuthViewData
 	Klaxon  *Klaxon
+	History []*KlaxonChange
 	Error   string
 	Success string
 }
@@ -286,6 +634,12 @@ type CardTreeViewData struct {
 	SourceURL   string
 }
 
+// UserAdminListViewData holds data for the user admin page.
+type UserAdminListViewData struct {
+	AuthViewData
+	Users []*UserSummary
+}
+
 // BoardAdminListViewData holds data for the board admin list page.
 type BoardAdminListViewData struct {
 	AuthViewData
@@ -295,7 +649,8 @@ type BoardAdminListViewData struct {
 // BoardAdminFormViewData holds data for the board create/edit page.
 type BoardAdminFormViewData struct {
 	AuthViewData
-	Board  *Board
-	Error  string
-	IsEdit bool
+	Board   *Board
+	Members string
+	Error   string
+	IsEdit  bool
 }
@@ -0,0 +1,206 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// networkPolicy lists address ranges whose visitors can read but not write
+// (Tor exits, datacenter ranges) and trusted ranges exempt from that.
+type networkPolicy struct {
+	Blocked []*net.IPNet
+	Allowed []*net.IPNet
+}
+
+type networkPolicyStatus struct {
+	Blocked int `json:"blocked"`
+	Allowed int `json:"allowed"`
+}
+
+// activeNetworkPolicy is swapped whole on reload; nil blocks nothing.
+var activeNetworkPolicy atomic.Pointer[networkPolicy]
+
+// loadNetworkPolicy reads the blocked and allowed ranges from
+// JANK_BLOCKED_NETWORKS / JANK_ALLOWED_NETWORKS (comma-separated) and the
+// files named by JANK_BLOCKED_NETWORKS_FILE / JANK_ALLOWED_NETWORKS_FILE
+// (one per line, # comments), plus the ranges moderators banned from
+// /mod/ip.
+func loadNetworkPolicy() (*networkPolicy, error) {
+	blocked, err := loadNetworks("JANK_BLOCKED_NETWORKS", "JANK_BLOCKED_NETWORKS_FILE")
+	if err != nil {
+		return nil, err
+	}
+	if db != nil {
+		banned, err := getBannedNetworks(context.Background(), db)
+		if err != nil {
+			return nil, fmt.Errorf("network bans: %w", err)
+		}
+		blocked = append(blocked, banned...)
+	}
+	allowed, err := loadNetworks("JANK_ALLOWED_NETWORKS", "JANK_ALLOWED_NETWORKS_FILE")
+	if err != nil {
+		return nil, err
+	}
+	return &networkPolicy{Blocked: blocked, Allowed: allowed}, nil
+}
+
+// reloadNetworkPolicy loads the lists again and makes them active. On error
+// the previous lists stay in place.
+func reloadNetworkPolicy() (*networkPolicy, error) {
+	policy, err := loadNetworkPolicy()
+	if err != nil {
+		return nil, err
+	}
+	activeNetworkPolicy.Store(policy)
+	return policy, nil
+}
+
+func loadNetworks(listKey, fileKey string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(getenvTrim(listKey), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		network, err := parseNetwork(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", listKey, err)
+		}
+		networks = append(networks, network)
+	}
+	path := getenvTrim(fileKey)
+	if path == "" {
+		return networks, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileKey, err)
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		if hash := strings.IndexByte(line, '#'); hash >= 0 {
+			line = line[:hash]
+		}
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		network, err := parseNetwork(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, i+1, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// parseNetwork accepts a CIDR range or a single address.
+func parseNetwork(entry string) (*net.IPNet, error) {
+	if strings.Contains(entry, "/") {
+		_, network, err := net.ParseCIDR(entry)
+		return network, err
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", entry)
+	}
+	bits := 8 * net.IPv6len
+	if v4 := ip.To4(); v4 != nil {
+		ip, bits = v4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// loadTrustedProxies reads JANK_TRUSTED_PROXIES, the comma-separated ranges
+// or addresses of reverse proxies allowed to set X-Forwarded-For.
+func loadTrustedProxies(l *configLoader) []*net.IPNet {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(getenvTrim("JANK_TRUSTED_PROXIES"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		network, err := parseNetwork(entry)
+		if err != nil {
+			l.addf("invalid JANK_TRUSTED_PROXIES entry %q; skipping it", entry)
+			continue
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// blocks reports whether addr is in a blocked range and no allowed range.
+func (p *networkPolicy) blocks(addr string) bool {
+	if p == nil || len(p.Blocked) == 0 {
+		return false
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	return containsIP(p.Blocked, ip) && !containsIP(p.Allowed, ip)
+}
+
+// blocksRequest checks both the connecting peer and, behind a trusted
+// proxy, the forwarded client, so blocking a proxy's own address still
+// works.
+func (p *networkPolicy) blocksRequest(r *http.Request) bool {
+	peer, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	return p.blocks(peer) || p.blocks(clientIP(r))
+}
+
+// networkPolicyMiddleware refuses writes from blocked networks. Signing in
+// and out still works, and moderators are never blocked.
+func networkPolicyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isWriteMethod(r.Method) && !readOnlyExemptPaths[r.URL.Path] &&
+			activeNetworkPolicy.Load().blocksRequest(r) && !isModerator(requestUsername(r)) {
+			log.Warnf("Refused %s %s from blocked network %s", r.Method, r.URL.Path, clientIP(r))
+			if isAPIPath(r.URL.Path) {
+				respondJSONError(w, http.StatusForbidden, "posting is not allowed from your network")
+				return
+			}
+			renderErrorPage(w, r, http.StatusForbidden, "Posting Blocked", "Posting isn't allowed from your network.", "/")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// networkPolicyHandler reports how many ranges are loaded (moderator only).
+// POST reloads the lists from the environment and files.
+func networkPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireModerator(w, r) {
+		return
+	}
+	policy := activeNetworkPolicy.Load()
+	if r.Method == http.MethodPost {
+		reloaded, err := reloadNetworkPolicy()
+		if err != nil {
+			log.Errorf("Failed to reload network lists: %v", err)
+			http.Error(w, "Failed to reload network lists: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		policy = reloaded
+		log.Infof("Reloaded network lists: %d blocked, %d allowed", len(policy.Blocked), len(policy.Allowed))
+	}
+	var status networkPolicyStatus
+	if policy != nil {
+		status = networkPolicyStatus{Blocked: len(policy.Blocked), Allowed: len(policy.Allowed)}
+	}
+	respondJSON(w, r, status)
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OriginConfig controls the cross-site check on form submissions. Browsers
+// send Origin (or at least Referer) with a POST, so a form posted from
+// another site shows up as a foreign origin before any handler runs.
+type OriginConfig struct {
+	Check bool
+	// Trusted lists extra origins, such as "https://forum.example.com",
+	// besides the request's own host and the base URL.
+	Trusted []string
+	// RequireHeader refuses writes that carry neither Origin nor Referer.
+	RequireHeader bool
+}
+
+func defaultOriginConfig() OriginConfig {
+	return OriginConfig{Check: true}
+}
+
+// loadOriginConfig reads JANK_ORIGIN_CHECK, JANK_TRUSTED_ORIGINS
+// (comma-separated), and JANK_REQUIRE_ORIGIN.
+func loadOriginConfig(l *configLoader) OriginConfig {
+	cfg := defaultOriginConfig()
+	cfg.Check = l.bool("JANK_ORIGIN_CHECK", cfg.Check)
+	cfg.RequireHeader = l.bool("JANK_REQUIRE_ORIGIN", cfg.RequireHeader)
+	for _, entry := range strings.Split(getenvTrim("JANK_TRUSTED_ORIGINS"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		origin, ok := parseOrigin(entry)
+		if !ok {
+			l.addf("invalid JANK_TRUSTED_ORIGINS entry %q; skipping it", entry)
+			continue
+		}
+		cfg.Trusted = append(cfg.Trusted, origin)
+	}
+	return cfg
+}
+
+// parseOrigin reduces an Origin or Referer value to its lowercase
+// scheme://host form.
+func parseOrigin(raw string) (string, bool) {
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", false
+	}
+	return strings.ToLower(parsed.Scheme + "://" + parsed.Host), true
+}
+
+// sameSiteRequest reports whether r came from a page on this site: its
+// Origin (or Referer, when Origin is missing) names the request's host, the
+// base URL, or a trusted origin.
+func (c OriginConfig) sameSiteRequest(r *http.Request) bool {
+	raw := r.Header.Get("Origin")
+	if raw == "" {
+		raw = r.Referer()
+	}
+	if raw == "" {
+		return !c.RequireHeader
+	}
+	origin, ok := parseOrigin(raw)
+	if !ok {
+		return false
+	}
+	if _, host, _ := strings.Cut(origin, "://"); strings.EqualFold(host, r.Host) {
+		return true
+	}
+	if base, ok := parseOrigin(baseURL); ok && origin == base {
+		return true
+	}
+	for _, trusted := range c.Trusted {
+		if origin == trusted {
+			return true
+		}
+	}
+	return false
+}
+
+// originCheckMiddleware refuses cross-site writes to the HTML pages. API
+// paths and requests with an Authorization header are left alone, since a
+// cross-site form can't set that header.
+func originCheckMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if originPolicy.Check && isWriteMethod(r.Method) && !isAPIPath(r.URL.Path) &&
+			r.Header.Get("Authorization") == "" && !originPolicy.sameSiteRequest(r) {
+			log.Warnf("Refused cross-site %s %s from origin %q (referer %q)", r.Method, r.URL.Path, r.Header.Get("Origin"), r.Referer())
+			renderErrorPage(w, r, http.StatusForbidden, "Request Blocked", "That form was sent from another site, so we didn't act on it.", "/")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
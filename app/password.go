@@ -0,0 +1,58 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ------------------- Password Strength -------------------
+
+// maxPasswordLength is the longest password signup accepts, in bytes:
+// bcrypt refuses to hash anything longer.
+const maxPasswordLength = 72
+
+var (
+	errPasswordTooLong = fmt.Errorf("password too long; use at most %d bytes", maxPasswordLength)
+	errPasswordCommon  = errors.New("password too common; pick something harder to guess")
+)
+
+// commonPasswords are passwords common enough that guessing them is the
+// first thing an attacker tries. Entries are lowercase and at least six
+// characters, since shorter ones fail the length check anyway.
+var commonPasswords = map[string]bool{
+	"123456": true, "1234567": true, "12345678": true, "123456789": true,
+	"1234567890": true, "0123456789": true, "987654321": true, "111111": true,
+	"000000": true, "121212": true, "123123": true, "654321": true,
+	"666666": true, "696969": true, "112233": true, "11111111": true,
+	"88888888": true, "12341234": true, "123qwe": true, "1q2w3e": true,
+	"1q2w3e4r": true, "1q2w3e4r5t": true, "qwerty": true, "qwerty123": true,
+	"qwertyuiop": true, "asdfgh": true, "asdfghjkl": true, "zxcvbnm": true,
+	"qazwsx": true, "1qaz2wsx": true, "password": true, "password1": true,
+	"password12": true, "password123": true, "passw0rd": true, "p@ssw0rd": true,
+	"p@ssword": true, "iloveyou": true, "princess": true, "sunshine": true,
+	"football": true, "baseball": true, "basketball": true, "superman": true,
+	"batman": true, "starwars": true, "pokemon": true, "computer": true,
+	"internet": true, "welcome": true, "welcome1": true, "letmein": true,
+	"trustno1": true, "monkey": true, "dragon": true, "master": true,
+	"shadow": true, "michael": true, "jennifer": true, "jordan23": true,
+	"charlie": true, "freedom": true, "whatever": true, "abc123": true,
+	"abcdef": true, "abcd1234": true, "aa123456": true, "changeme": true,
+	"secret": true, "admin123": true, "administrator": true, "qwe123": true,
+	"mustang": true, "hello123": true, "loveme": true, "liverpool": true,
+}
+
+// checkPassword returns why password is unacceptable, or nil. Messages are
+// meant to be shown to the person choosing it.
+func checkPassword(password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password too short; use at least %d characters", minPasswordLength)
+	}
+	if len(password) > maxPasswordLength {
+		return errPasswordTooLong
+	}
+	if rejectCommonPasswords && commonPasswords[strings.ToLower(password)] {
+		return errPasswordCommon
+	}
+	return nil
+}
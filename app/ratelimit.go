@@ -4,7 +4,6 @@ import (
 	"net"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -14,38 +13,29 @@ type rateLimitEntry struct {
 }
 
 type RateLimiter struct {
-	mu      sync.Mutex
-	entries map[string]rateLimitEntry
+	entries *ttlStore[rateLimitEntry]
 }
 
 func NewRateLimiter() *RateLimiter {
 	return &RateLimiter{
-		entries: make(map[string]rateLimitEntry),
+		entries: newTTLStore[rateLimitEntry](),
 	}
 }
 
 func (rl *RateLimiter) Allow(ip string, maxCount int, window time.Duration) bool {
-	now := time.Now()
-
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	entry, ok := rl.entries[ip]
-	if !ok || now.Sub(entry.WindowStart) >= window {
-		rl.entries[ip] = rateLimitEntry{
-			Count:       1,
-			WindowStart: now,
+	allowed := false
+	rl.entries.Update(ip, window, func(entry rateLimitEntry, ok bool) (rateLimitEntry, bool) {
+		if !ok {
+			allowed = true
+			return rateLimitEntry{Count: 1, WindowStart: time.Now()}, false
 		}
-		return true
-	}
-
-	if entry.Count >= maxCount {
-		return false
-	}
-
-	entry.Count++
-	rl.entries[ip] = entry
-	return true
+		if entry.Count < maxCount {
+			entry.Count++
+			allowed = true
+		}
+		return entry, true
+	})
+	return allowed
 }
 
 var authLimiter = NewRateLimiter()
@@ -64,20 +54,30 @@ func authRateLimitMiddleware(maxCount int, window time.Duration) func(http.Handl
 	}
 }
 
+// clientIP is the address a request came from: the connecting peer, or,
+// when the peer is one of trustedProxies, the X-Forwarded-For hop that
+// proxy added. Hops are walked from the right past each trusted proxy, so a
+// client can't pick its address by sending its own header. Addresses are
+// returned in their canonical form.
 func clientIP(r *http.Request) string {
-	if xff := strings.TrimSpace(r.Header.Get("X-Forwarded-For")); xff != "" {
-		parts := strings.Split(xff, ",")
-		if len(parts) > 0 {
-			first := strings.TrimSpace(parts[0])
-			if first != "" {
-				return first
-			}
-		}
+	addr := strings.TrimSpace(r.RemoteAddr)
+	if host, _, err := net.SplitHostPort(addr); err == nil && host != "" {
+		addr = host
 	}
-
-	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
-	if err == nil && host != "" {
-		return host
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return addr
+	}
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0 && containsIP(trustedProxies, ip); i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
 	}
-	return strings.TrimSpace(r.RemoteAddr)
+	return ip.String()
 }
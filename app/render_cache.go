@@ -0,0 +1,112 @@
+package app
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"html/template"
+	"sync"
+)
+
+// ------------------- Rendered Post Cache -------------------
+
+// renderCacheKey identifies one version of a post: an edit changes the hash,
+// so stale HTML is never served even before the old entry is evicted.
+type renderCacheKey struct {
+	postID int
+	hash   [sha256.Size]byte
+}
+
+type renderCacheEntry struct {
+	key  renderCacheKey
+	html template.HTML
+}
+
+// renderCache keeps the sanitized markdown HTML of the most recently shown
+// posts, evicting the least recently used past its capacity.
+type renderCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[renderCacheKey]*list.Element
+	byPost   map[int][]renderCacheKey
+	hits     int
+	misses   int
+}
+
+func newRenderCache(capacity int) *renderCache {
+	return &renderCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[renderCacheKey]*list.Element),
+		byPost:   make(map[int][]renderCacheKey),
+	}
+}
+
+// render returns the cached HTML for postID's content, calling fn and
+// caching its result on a miss.
+func (c *renderCache) render(postID int, content string, fn func() template.HTML) template.HTML {
+	key := renderCacheKey{postID: postID, hash: sha256.Sum256([]byte(content))}
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		c.hits++
+		c.mu.Unlock()
+		return elem.Value.(*renderCacheEntry).html
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	// Rendering happens unlocked; two requests racing on the same post both
+	// render it, and the second store is a no-op.
+	rendered := fn()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return rendered
+	}
+	c.entries[key] = c.order.PushFront(&renderCacheEntry{key: key, html: rendered})
+	c.byPost[postID] = append(c.byPost[postID], key)
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+	return rendered
+}
+
+// forget drops every cached version of postIDs.
+func (c *renderCache) forget(postIDs ...int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, postID := range postIDs {
+		for _, key := range c.byPost[postID] {
+			if elem, ok := c.entries[key]; ok {
+				c.remove(elem)
+			}
+		}
+	}
+}
+
+// remove unlinks elem; the caller holds c.mu.
+func (c *renderCache) remove(elem *list.Element) {
+	key := c.order.Remove(elem).(*renderCacheEntry).key
+	delete(c.entries, key)
+	keys := c.byPost[key.postID]
+	for i, k := range keys {
+		if k == key {
+			keys = append(keys[:i], keys[i+1:]...)
+			break
+		}
+	}
+	if len(keys) == 0 {
+		delete(c.byPost, key.postID)
+	} else {
+		c.byPost[key.postID] = keys
+	}
+}
+
+// stats returns the hit and miss counts since the cache was created.
+func (c *renderCache) stats() (hits, misses int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
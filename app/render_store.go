@@ -0,0 +1,74 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+)
+
+// rerenderBatchSize is how many posts each rerender pass loads at once.
+const rerenderBatchSize = 500
+
+// storePostRender renders post and saves the HTML with the current
+// markdownRenderVersion.
+func storePostRender(ctx context.Context, db dbConn, post *Post) error {
+	post.RenderedContent = renderPostMarkdown(post)
+	post.RenderVersion = markdownRenderVersion
+	_, err := db.ExecContext(ctx, `UPDATE posts SET rendered_content = $1, render_version = $2 WHERE id = $3`,
+		post.RenderedContent, post.RenderVersion, post.ID)
+	return err
+}
+
+// rerenderStalePosts backfills rendered HTML for every live post stored
+// before the current markdownRenderVersion, a batch at a time, and returns
+// how many it rewrote.
+func rerenderStalePosts(ctx context.Context, db *sql.DB) (int, error) {
+	rerendered, afterID := 0, 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return rerendered, err
+		}
+		posts, err := getStaleRenderPosts(ctx, db, afterID)
+		if err != nil {
+			return rerendered, err
+		}
+		if len(posts) == 0 {
+			return rerendered, nil
+		}
+		for _, post := range posts {
+			batchCtx, cancel := withQueryTimeout(ctx)
+			err := storePostRender(batchCtx, db, post)
+			cancel()
+			if err != nil {
+				return rerendered, err
+			}
+			rerendered++
+			afterID = post.ID
+		}
+	}
+}
+
+// getStaleRenderPosts loads the next batch of live posts after afterID whose
+// stored HTML is out of date.
+func getStaleRenderPosts(ctx context.Context, db *sql.DB, afterID int) ([]*Post, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, content FROM posts
+		WHERE id > $1 AND deleted_at IS NULL AND render_version <> $2
+		ORDER BY id
+		LIMIT $3`, afterID, markdownRenderVersion, rerenderBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []*Post
+	for rows.Next() {
+		var post Post
+		if err := rows.Scan(&post.ID, &post.Content); err != nil {
+			return nil, err
+		}
+		posts = append(posts, &post)
+	}
+	return posts, rows.Err()
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// getReportStats summarizes the report queue for the moderator dashboard:
+// open reports by category and board, the oldest open report, and how many
+// reports were filed and resolved in the last window.
+func getReportStats(ctx context.Context, db *sql.DB, window time.Duration) (*ReportStats, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	stats := &ReportStats{Window: window}
+	var err error
+
+	stats.ByCategory, err = queryReportCounts(ctx, db, `
+		SELECT category, COUNT(*)
+		FROM reports
+		WHERE resolved_at IS NULL
+		GROUP BY category
+		ORDER BY COUNT(*) DESC, category`)
+	if err != nil {
+		return nil, err
+	}
+	for _, count := range stats.ByCategory {
+		stats.OpenCount += count.Count
+	}
+	stats.ByBoard, err = queryReportCounts(ctx, db, `
+		SELECT b.name, COUNT(*)
+		FROM reports r
+		JOIN posts p ON p.id = r.post_id
+		JOIN threads t ON t.id = p.thread_id
+		JOIN boards b ON b.id = t.board_id
+		WHERE r.resolved_at IS NULL
+		GROUP BY b.name
+		ORDER BY COUNT(*) DESC, b.name`)
+	if err != nil {
+		return nil, err
+	}
+
+	var oldest time.Time
+	err = db.QueryRowContext(ctx, `SELECT created FROM reports WHERE resolved_at IS NULL ORDER BY created LIMIT 1`).Scan(&oldest)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == nil {
+		stats.OldestOpen = &oldest
+	}
+
+	since := time.Now().Add(-window)
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM reports WHERE created > $1`, since).Scan(&stats.OpenedInWindow); err != nil {
+		return nil, err
+	}
+	// Resolution times are averaged here rather than in SQL because SQLite
+	// and Postgres disagree on timestamp arithmetic.
+	rows, err := db.QueryContext(ctx, `SELECT created, resolved_at FROM reports WHERE resolved_at > $1`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var total time.Duration
+	for rows.Next() {
+		var created, resolved time.Time
+		if err := rows.Scan(&created, &resolved); err != nil {
+			return nil, err
+		}
+		stats.ResolvedInWindow++
+		total += resolved.Sub(created)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if stats.ResolvedInWindow > 0 {
+		stats.AvgResolution = total / time.Duration(stats.ResolvedInWindow)
+	}
+	return stats, nil
+}
+
+func queryReportCounts(ctx context.Context, db *sql.DB, query string) ([]ReportCount, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []ReportCount
+	for rows.Next() {
+		var count ReportCount
+		if err := rows.Scan(&count.Label, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
@@ -0,0 +1,213 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ------------------- Retention -------------------
+
+// retentionActor is the mod_actions actor for the janitor's deletions.
+const retentionActor = "janitor"
+
+// modActionRetentionPurge is the mod_actions action for a thread the
+// janitor deleted.
+const modActionRetentionPurge = "retention.purge"
+
+// retentionBatchSize caps how many threads one board gives up per pass, so
+// a long backlog is cleared over several runs instead of one long one.
+const retentionBatchSize = 200
+
+type expiredThread struct {
+	ID      int
+	BoardID int
+	Board   string
+	Title   string
+}
+
+// boardRetention is how long a thread on a board with the given
+// retention_days may go without a post; zero keeps threads forever.
+func boardRetention(days *int) time.Duration {
+	if days != nil {
+		return time.Duration(*days) * 24 * time.Hour
+	}
+	return retentionAge
+}
+
+// getExpiredThreads lists threads, sticky ones aside, whose newest post (or
+// creation, for an empty thread) is older than their board's retention.
+func getExpiredThreads(ctx context.Context, db *sql.DB, now time.Time) ([]*expiredThread, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `SELECT id, name, retention_days FROM boards ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	type boardCutoff struct {
+		id     int
+		name   string
+		cutoff time.Time
+	}
+	var boards []boardCutoff
+	for rows.Next() {
+		var b boardCutoff
+		var days sql.NullInt64
+		if err := rows.Scan(&b.id, &b.name, &days); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		var override *int
+		if days.Valid {
+			d := int(days.Int64)
+			override = &d
+		}
+		if age := boardRetention(override); age > 0 {
+			b.cutoff = now.Add(-age)
+			boards = append(boards, b)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var expired []*expiredThread
+	for _, b := range boards {
+		rows, err := db.QueryContext(ctx, `
+			SELECT t.id, t.title
+			FROM threads t
+			LEFT JOIN (
+				SELECT thread_id, MAX(created) AS last_post
+				FROM posts
+				GROUP BY thread_id
+			) ps ON ps.thread_id = t.id
+			WHERE t.board_id = $1 AND t.is_sticky = $2 AND COALESCE(ps.last_post, t.created) < $3
+			ORDER BY t.id
+			LIMIT $4`, b.id, false, b.cutoff, retentionBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			thread := &expiredThread{BoardID: b.id, Board: b.name}
+			if err := rows.Scan(&thread.ID, &thread.Title); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			expired = append(expired, thread)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return expired, nil
+}
+
+// purgeThread hard-deletes a thread with its posts, their votes, reports,
+// and card trees, and records the deletion in mod_actions. Warnings and
+// annotations elsewhere that pointed at its posts are kept without the link.
+func purgeThread(ctx context.Context, db *sql.DB, thread *expiredThread, actor, detail string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM posts WHERE thread_id = $1`, thread.ID)
+	if err != nil {
+		return err
+	}
+	var postIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		postIDs = append(postIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	const threadPosts = `SELECT id FROM posts WHERE thread_id = $1`
+	const threadTrees = `SELECT id FROM card_trees
+		WHERE (scope_type = 'thread' AND scope_id = $1)
+			OR (scope_type = 'post' AND scope_id IN (` + threadPosts + `))`
+	for _, stmt := range []string{
+		`DELETE FROM post_votes WHERE post_id IN (` + threadPosts + `)`,
+		`DELETE FROM reports WHERE post_id IN (` + threadPosts + `)`,
+		`UPDATE user_warnings SET post_id = NULL WHERE post_id IN (` + threadPosts + `)`,
+		`UPDATE card_tree_annotations SET source_post_id = NULL WHERE source_post_id IN (` + threadPosts + `)`,
+		`DELETE FROM card_tree_annotations WHERE node_id IN (SELECT id FROM card_tree_nodes WHERE tree_id IN (` + threadTrees + `))`,
+		`DELETE FROM card_tree_nodes WHERE tree_id IN (` + threadTrees + `)`,
+		`DELETE FROM card_trees WHERE id IN (` + threadTrees + `)`,
+		`DELETE FROM posts WHERE thread_id = $1`,
+		`DELETE FROM threads WHERE id = $1`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, thread.ID); err != nil {
+			return err
+		}
+	}
+	if err := recordModAction(ctx, tx, actor, modActionRetentionPurge, thread.BoardID, thread.ID, detail); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	postRenderCache.forget(postIDs...)
+	return nil
+}
+
+// recordModAction appends an entry to the moderation log.
+func recordModAction(ctx context.Context, db dbConn, actor, action string, boardID, threadID int, detail string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO mod_actions (actor, action, board_id, thread_id, detail, created)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		actor, action, nullableID(boardID), nullableID(threadID), detail, time.Now())
+	return err
+}
+
+// nullableID stores a zero ID as NULL, for mod actions such as network bans
+// that aren't tied to a board or thread.
+func nullableID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// purgeExpiredThreads deletes every thread past its board's retention and
+// returns how many went. A thread that fails to delete is logged and left
+// for the next run.
+func purgeExpiredThreads(ctx context.Context, db *sql.DB, now time.Time) (int, error) {
+	expired, err := getExpiredThreads(ctx, db, now)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, thread := range expired {
+		detail := fmt.Sprintf("thread %d %q on %s passed its retention", thread.ID, thread.Title, thread.Board)
+		if err := purgeThread(ctx, db, thread, retentionActor, detail); err != nil {
+			log.Errorf("Failed to purge expired thread %d: %v", thread.ID, err)
+			continue
+		}
+		log.Infof("Retention purged %s", detail)
+		purged++
+	}
+	return purged, nil
+}
+
+// retentionWorker purges expired threads every interval.
+func retentionWorker(interval time.Duration) Worker {
+	return every("retention", interval, func(ctx context.Context, now time.Time) {
+		purged, err := purgeExpiredThreads(ctx, db, now)
+		if err != nil {
+			log.Errorf("Retention janitor failed: %v", err)
+		} else if purged > 0 {
+			log.Infof("Retention janitor purged %d threads", purged)
+		}
+	})
+}
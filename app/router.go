@@ -1,6 +1,9 @@
 package app
 
 import (
+	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -8,55 +11,266 @@ import (
 
 func buildRouter() *mux.Router {
 	r := mux.NewRouter()
+	r.Use(authReadMiddleware)
+	r.Use(readOnlyMiddleware)
+	r.Use(networkPolicyMiddleware)
+	r.Use(originCheckMiddleware)
+	r.Use(headAsGet)
+	r.NotFoundHandler = notFoundHandler(r)
+	r.MethodNotAllowedHandler = methodNotAllowedHandler(r)
 
 	// HTML pages
-	r.HandleFunc("/", serveIndex).Methods("GET")
-	r.HandleFunc("/view/board/{boardID:[0-9]+}", serveBoardView).Methods("GET")
+	r.HandleFunc("/", serveIndex).Methods("GET", "HEAD")
+	r.HandleFunc("/view/board/{boardID:[0-9]+}", serveBoardView).Methods("GET", "HEAD")
+	r.HandleFunc("/view/board/{boardID:[0-9]+}-{slug}", serveBoardView).Methods("GET", "HEAD")
+	r.HandleFunc("/b/{slug}", serveBoardBySlug).Methods("GET", "HEAD")
+	r.HandleFunc("/view/board/{boardID:[0-9]+}/feed.xml", serveBoardFeed).Methods("GET", "HEAD")
 	r.HandleFunc("/view/board/newthread/{boardID:[0-9]+}", serveNewThread).Methods("GET", "POST")
-	r.HandleFunc("/view/thread/{threadID:[0-9]+}", serveThreadView).Methods("GET")
+	r.HandleFunc("/view/thread/{threadID:[0-9]+}", serveThreadView).Methods("GET", "HEAD")
+	r.HandleFunc("/view/thread/{threadID:[0-9]+}-{slug}", serveThreadView).Methods("GET", "HEAD")
 	r.HandleFunc("/view/thread/{threadID:[0-9]+}/post", serveThreadView).Methods("POST")
+	r.HandleFunc("/view/thread/{threadID:[0-9]+}/since/{postID:[0-9]+}", threadPostsSinceHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/view/thread/{threadID:[0-9]+}/accept", acceptAnswerHandler).Methods("POST")
+	r.HandleFunc("/view/thread/{threadID:[0-9]+}/slowmode", slowModeHandler).Methods("POST")
 	r.HandleFunc("/report/post/{postID:[0-9]+}", reportPostHandler).Methods("POST")
+	r.HandleFunc("/vote/post/{postID:[0-9]+}", votePostHandler).Methods("POST")
 	r.HandleFunc("/mod/reports", serveModReports).Methods("GET")
+	r.HandleFunc("/mod/dashboard", serveModDashboard).Methods("GET")
 	r.HandleFunc("/mod/boards", serveBoardAdminList).Methods("GET")
 	r.HandleFunc("/mod/boards/new", serveBoardAdminCreate).Methods("GET", "POST")
 	r.HandleFunc("/mod/boards/{boardID:[0-9]+}/edit", serveBoardAdminEdit).Methods("GET", "POST")
 	r.HandleFunc("/mod/boards/{boardID:[0-9]+}/delete", serveBoardAdminDelete).Methods("POST")
+	r.HandleFunc("/mod/users", serveUserAdminList).Methods("GET")
+	r.HandleFunc("/mod/users/{username}/disable", disableUserHandler).Methods("POST")
+	r.HandleFunc("/mod/users/{username}/password", resetUserPasswordHandler).Methods("POST")
+	r.HandleFunc("/mod/users/{username}/delete", deleteUserHandler).Methods("POST")
+	r.HandleFunc("/mod/users/{username}/notes", userModNotesHandler).Methods("GET", "POST")
 	r.HandleFunc("/mod/klaxon", serveKlaxonAdmin).Methods("GET", "POST")
 	r.HandleFunc("/mod/reports/{reportID:[0-9]+}/resolve", resolveReportHandler).Methods("POST")
 	r.HandleFunc("/mod/posts/{postID:[0-9]+}/delete", deletePostHandler).Methods("POST")
+	r.HandleFunc("/mod/posts/bulk-delete", bulkDeletePostsHandler).Methods("POST")
+	r.HandleFunc("/mod/ip/ban", banIPHandler).Methods("POST")
+	r.HandleFunc("/mod/ip/delete", deleteIPPostsHandler).Methods("POST")
+	r.HandleFunc("/mod/ip/{ip}", serveModIP).Methods("GET")
+	r.HandleFunc("/mod/ip/{ip}/{bits:[0-9]+}", serveModIP).Methods("GET")
+	r.HandleFunc("/mod/posts/{postID:[0-9]+}/badge", setPostBadgeHandler).Methods("POST")
+	r.HandleFunc("/mod/posts/{postID:[0-9]+}/warn", warnPostHandler).Methods("POST")
+	r.HandleFunc("/mod/threads/{threadID:[0-9]+}/lock", lockThreadHandler).Methods("POST")
+	r.HandleFunc("/mod/threads/{threadID:[0-9]+}/sticky", stickyThreadHandler).Methods("POST")
+	r.HandleFunc("/warnings/{warningID:[0-9]+}/ack", acknowledgeWarningHandler).Methods("POST")
+	r.HandleFunc("/mod/maintenance/vacuum", vacuumHandler).Methods("POST")
+	r.HandleFunc("/mod/maintenance/recount", recountHandler).Methods("POST")
+	r.HandleFunc("/mod/maintenance/rerender", rerenderHandler).Methods("POST")
+	r.HandleFunc("/mod/maintenance/backup", backupHandler).Methods("GET")
+	r.HandleFunc("/mod/maintenance/readonly", readOnlyHandler).Methods("GET", "POST")
+	r.HandleFunc("/mod/maintenance/networks", networkPolicyHandler).Methods("GET", "POST")
 	r.HandleFunc("/logout", serveLogout).Methods("POST", "GET")
 	r.HandleFunc("/profile", serveProfile).Methods("GET")
 	r.HandleFunc("/profile/trees", serveUserTrees).Methods("GET")
+	r.HandleFunc("/profile/export", serveProfileExport).Methods("GET")
+	r.HandleFunc("/profile/delete", deleteAccountHandler).Methods("POST")
 	r.HandleFunc("/user", serveUserLookup).Methods("GET", "POST")
 	r.HandleFunc("/user/{username}", servePublicProfile).Methods("GET")
 	r.HandleFunc("/search", serveSearch).Methods("GET")
-	r.HandleFunc("/view/tree/{treeID:[0-9]+}", serveCardTreeView).Methods("GET")
-	r.HandleFunc("/favicon.ico", serveFaviconRedirect).Methods("GET")
-	r.HandleFunc("/favicon.svg", serveFavicon).Methods("GET")
+	r.HandleFunc("/view/tree/{treeID:[0-9]+}", serveCardTreeView).Methods("GET", "HEAD")
+	r.HandleFunc("/feed.xml", serveGlobalFeed).Methods("GET", "HEAD")
+	r.HandleFunc("/feed/tag/{tag:[^/]+}.xml", serveTagFeed).Methods("GET", "HEAD")
+	r.HandleFunc("/attachments/{key}", serveAttachment).Methods("GET", "HEAD")
+	r.HandleFunc("/favicon.ico", serveFaviconRedirect).Methods("GET", "HEAD")
+	r.HandleFunc("/favicon.svg", serveFavicon).Methods("GET", "HEAD")
+
+	// Versioned JSON API. New API work should land here.
+	apiV1 := r.PathPrefix(apiV1Prefix).Subrouter()
+	registerAPIRoutes(apiV1)
+	apiV1Auth := apiV1.PathPrefix("/auth").Subrouter()
+	apiV1Auth.Use(authRateLimitMiddleware(10, 15*time.Minute))
+	registerAPIAuthRoutes(apiV1Auth)
 
 	authRoutes := r.PathPrefix("").Subrouter()
 	authRoutes.Use(authRateLimitMiddleware(10, 15*time.Minute))
 	authRoutes.HandleFunc("/login", serveLogin).Methods("GET", "POST")
 	authRoutes.HandleFunc("/signup", serveSignup).Methods("GET", "POST")
-	authRoutes.HandleFunc("/auth/token", authTokenHandler).Methods("POST")
-	authRoutes.HandleFunc("/auth/signup", authSignupHandler).Methods("POST")
-
-	// REST API endpoints
-	r.HandleFunc("/boards", boardsHandler).Methods("GET", "POST")
-	r.HandleFunc("/boards/{boardID:[0-9]+}", boardHandler).Methods("GET")
-	r.HandleFunc("/boards/{boardID:[0-9]+}/trees", boardTreesHandler).Methods("GET", "POST")
-	r.HandleFunc("/threads/{boardID:[0-9]+}", threadsHandler).Methods("GET", "POST")
-	r.HandleFunc("/threads/{threadID:[0-9]+}/trees", threadTreesHandler).Methods("GET", "POST")
+
+	// Unversioned REST paths are deprecated aliases of /api/v1.
+	legacyAuth := authRoutes.PathPrefix("/auth").Subrouter()
+	legacyAuth.Use(deprecatedAPIAlias)
+	registerAPIAuthRoutes(legacyAuth)
+
+	legacyAPI := r.PathPrefix("").Subrouter()
+	legacyAPI.Use(deprecatedAPIAlias)
+	registerAPIRoutes(legacyAPI)
+	// The minimal board list was first published under /api without a
+	// version; keep that path answering alongside /api/v1 and /boards.
+	r.HandleFunc("/api/boards/minimal", boardsMinimalHandler).Methods("GET", "HEAD")
+
+	// Short board codes (/test/, /test/thread/5) and their 4chan-style JSON.
+	// Registered after the fixed pages and API routes so a board name can't
+	// shadow them.
+	r.HandleFunc("/{boardName:[A-Za-z0-9_-]+}/catalog.json", chanCatalogHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/{boardName:[A-Za-z0-9_-]+}/thread/{threadID:[0-9]+}.json", chanThreadHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/{boardName:[A-Za-z0-9_-]+}/", serveBoardByName).Methods("GET", "HEAD")
+	r.HandleFunc("/{boardName:[A-Za-z0-9_-]+}/thread/{threadID:[0-9]+}", serveBoardThreadByName).Methods("GET", "HEAD")
+
+	// Registered last so it only answers OPTIONS for paths no route claims.
+	r.Methods(http.MethodOptions).Handler(optionsHandler(r))
+
+	return r
+}
+
+const apiV1Prefix = "/api/v1"
+
+// registerAPIRoutes registers the REST endpoints relative to r so the same
+// handlers serve both /api/v1 and the legacy root paths.
+func registerAPIRoutes(r *mux.Router) {
+	r.HandleFunc("/boards", boardsHandler).Methods("GET", "HEAD", "POST")
+	r.HandleFunc("/boards/minimal", boardsMinimalHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/boards/{boardID:[0-9]+}", boardHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/boards/{boardID:[0-9]+}/info", boardInfoHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/boards/{boardID:[0-9]+}/threads", boardThreadsHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/boards/{boardID:[0-9]+}/trees", boardTreesHandler).Methods("GET", "HEAD", "POST")
+	r.HandleFunc("/threads/{boardID:[0-9]+}", threadsHandler).Methods("GET", "HEAD", "POST")
+	r.HandleFunc("/threads/{threadID:[0-9]+}/trees", threadTreesHandler).Methods("GET", "HEAD", "POST")
 	r.HandleFunc("/posts/{boardID:[0-9]+}/{threadID:[0-9]+}", postsHandler).Methods("POST")
 	r.HandleFunc("/posts/{postID:[0-9]+}/delete", postDeleteHandler).Methods("POST")
+	r.HandleFunc("/posts/{postID:[0-9]+}/vote", postVoteHandler).Methods("POST")
 	r.HandleFunc("/reports", reportsHandler).Methods("GET", "POST")
+	r.HandleFunc("/attachments", attachmentUploadHandler).Methods("POST")
 	r.HandleFunc("/reports/{reportID:[0-9]+}/resolve", reportResolveHandler).Methods("POST")
-	r.HandleFunc("/trees/{treeID:[0-9]+}", treeHandler).Methods("GET")
+	r.HandleFunc("/trees/{treeID:[0-9]+}", treeHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/trees/{treeID:[0-9]+}/graph.json", treeGraphHandler).Methods("GET", "HEAD")
 	r.HandleFunc("/trees/{treeID:[0-9]+}/nodes", treeNodesHandler).Methods("POST")
+	r.HandleFunc("/trees/{treeID:[0-9]+}/nodes/batch", treeNodesBatchHandler).Methods("POST")
 	r.HandleFunc("/trees/{treeID:[0-9]+}/nodes/{nodeID:[0-9]+}", treeNodeHandler).Methods("PATCH", "DELETE")
 	r.HandleFunc("/trees/{treeID:[0-9]+}/nodes/{nodeID:[0-9]+}/annotations", treeNodeAnnotationsHandler).Methods("POST")
 	r.HandleFunc("/trees/{treeID:[0-9]+}/nodes/{nodeID:[0-9]+}/annotations/{annotationID:[0-9]+}", treeNodeAnnotationHandler).Methods("DELETE")
+	r.HandleFunc("/users/{username}/trees", userTreesHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/trending", trendingHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/whoami", whoamiHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/klaxon", klaxonHandler).Methods("GET", "HEAD")
 	r.HandleFunc("/delete/board/{boardID:[0-9]+}", deleteBoardHandler).Methods("DELETE")
+}
 
-	return r
+// registerAPIAuthRoutes registers the token endpoints on a router already
+// scoped to /auth.
+func registerAPIAuthRoutes(r *mux.Router) {
+	r.HandleFunc("/token", authTokenHandler).Methods("POST")
+	r.HandleFunc("/signup", authSignupHandler).Methods("POST")
+}
+
+// deprecatedAPIAlias marks responses from unversioned API paths and points
+// clients at the /api/v1 equivalent.
+func deprecatedAPIAlias(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", apiV1Prefix, r.URL.Path))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isAPIPath(path string) bool {
+	return path == "/api" || strings.HasPrefix(path, "/api/")
+}
+
+// allowedMethods lists the methods registered for the request's path.
+// mux loses method mismatches inside prefixed subrouters, so unmatched
+// requests are re-checked against every route here.
+func allowedMethods(router *mux.Router, req *http.Request) []string {
+	var methods []string
+	seen := make(map[string]bool)
+	_ = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		routeMethods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range routeMethods {
+			if method == http.MethodOptions || seen[method] {
+				continue
+			}
+			probe := req.Clone(req.Context())
+			probe.Method = method
+			if route.Match(probe, &mux.RouteMatch{}) {
+				seen[method] = true
+				methods = append(methods, method)
+			}
+		}
+		return nil
+	})
+	return methods
+}
+
+// notFoundHandler answers unmatched paths with JSON under /api and the HTML
+// error page elsewhere, falling back to 405 when the path exists for other methods.
+func notFoundHandler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if methods := allowedMethods(router, r); len(methods) > 0 {
+			writeMethodNotAllowed(w, r, methods)
+			return
+		}
+		if isAPIPath(r.URL.Path) {
+			respondJSONError(w, http.StatusNotFound, "not found")
+			return
+		}
+		renderErrorPage(w, r, http.StatusNotFound, "Not Found", "That page does not exist.", "/")
+	})
+}
+
+// optionsHandler advertises the methods registered for a path via Allow,
+// which also answers CORS preflight requests.
+func optionsHandler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods := allowedMethods(router, r)
+		if len(methods) == 0 {
+			router.NotFoundHandler.ServeHTTP(w, r)
+			return
+		}
+		allow := strings.Join(methods, ", ")
+		w.Header().Set("Allow", allow)
+		if r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", allow)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func methodNotAllowedHandler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods := allowedMethods(router, r)
+		if len(methods) == 0 {
+			// Only the catch-all OPTIONS route matched the path.
+			router.NotFoundHandler.ServeHTTP(w, r)
+			return
+		}
+		writeMethodNotAllowed(w, r, methods)
+	})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, r *http.Request, methods []string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	if isAPIPath(r.URL.Path) {
+		respondJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	renderErrorPage(w, r, http.StatusMethodNotAllowed, "Method Not Allowed", "That action isn't supported here.", "/")
+}
+
+// headAsGet serves HEAD requests with the matching GET handler, keeping the
+// headers and dropping the body.
+func headAsGet(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		getReq := r.Clone(r.Context())
+		getReq.Method = http.MethodGet
+		next.ServeHTTP(headResponseWriter{w}, getReq)
+	})
+}
+
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w headResponseWriter) Write(p []byte) (int, error) {
+	return len(p), nil
 }
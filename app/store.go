@@ -1,11 +1,13 @@
 package app
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"math/big"
 	"regexp"
@@ -13,11 +15,54 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
+	sqlite3 "github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // ------------------- Database & Utility -------------------
 
+// dbConn is the query surface shared by *sql.DB and *sql.Tx, for store calls
+// that may run inside a transaction.
+type dbConn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// inTx runs fn in a transaction, committing if it returns nil. When db is
+// already a transaction, fn runs in it and the caller commits.
+func inTx(ctx context.Context, db dbConn, fn func(tx dbConn) error) error {
+	pool, ok := db.(*sql.DB)
+	if !ok {
+		return fn(db)
+	}
+	tx, err := pool.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// queryTimeout bounds each store call so a slow query can't hold a request open.
+var queryTimeout = 5 * time.Second
+
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, queryTimeout)
+}
+
+// isQueryTimeout reports whether a store error was caused by the query timeout.
+func isQueryTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
 // migrate creates the necessary tables if they don't exist.
 func migrate(db *sql.DB) error {
 	switch dbDriver {
@@ -35,14 +80,24 @@ func migrateSQLite(db *sql.DB) error {
 	CREATE TABLE IF NOT EXISTS boards (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		name TEXT NOT NULL,
-		description TEXT
+		description TEXT,
+		rules TEXT,
+		visibility TEXT NOT NULL DEFAULT 'public',
+		post_numbering TEXT NOT NULL DEFAULT 'global',
+		post_counter INTEGER NOT NULL DEFAULT 0,
+		anon_name TEXT,
+		require_title BOOLEAN NOT NULL DEFAULT 1,
+		necro_days INTEGER,
+		retention_days INTEGER
 	);`
 	usersStmt := `
 	CREATE TABLE IF NOT EXISTS users (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		username TEXT NOT NULL UNIQUE,
 		password_hash TEXT NOT NULL,
-		created DATETIME NOT NULL
+		created DATETIME NOT NULL,
+		disabled_at DATETIME,
+		last_login DATETIME
 	);`
 	threadsStmt := `
 	CREATE TABLE IF NOT EXISTS threads (
@@ -52,6 +107,11 @@ func migrateSQLite(db *sql.DB) error {
 		author TEXT,
 		tags TEXT,
 		created DATETIME NOT NULL,
+		last_bump DATETIME,
+		accepted_post_id INTEGER,
+		slow_mode_seconds INTEGER NOT NULL DEFAULT 0,
+		is_locked BOOLEAN NOT NULL DEFAULT 0,
+		is_sticky BOOLEAN NOT NULL DEFAULT 0,
 		FOREIGN KEY (board_id) REFERENCES boards(id)
 	);`
 	postsStmt := `
@@ -66,6 +126,12 @@ func migrateSQLite(db *sql.DB) error {
 		deleted_at DATETIME,
 		deleted_by TEXT,
 		deleted_reason TEXT,
+		email TEXT,
+		badge TEXT,
+		board_number INTEGER,
+		ip TEXT,
+		ip_key TEXT,
+		posted_by TEXT,
 		FOREIGN KEY (thread_id) REFERENCES threads(id)
 	);`
 	reportsStmt := `
@@ -90,6 +156,90 @@ func migrateSQLite(db *sql.DB) error {
 		message TEXT,
 		updated_at DATETIME NOT NULL
 	);`
+	klaxonHistoryStmt := `
+	CREATE TABLE IF NOT EXISTS klaxon_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		action TEXT NOT NULL,
+		tone TEXT,
+		emoji TEXT,
+		message TEXT,
+		actor TEXT NOT NULL,
+		created DATETIME NOT NULL
+	);`
+	modActionsStmt := `
+	CREATE TABLE IF NOT EXISTS mod_actions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		actor TEXT NOT NULL,
+		action TEXT NOT NULL,
+		board_id INTEGER,
+		thread_id INTEGER,
+		detail TEXT,
+		created DATETIME NOT NULL
+	);`
+	boardMembersStmt := `
+	CREATE TABLE IF NOT EXISTS board_members (
+		board_id INTEGER NOT NULL,
+		username TEXT NOT NULL,
+		PRIMARY KEY (board_id, username),
+		FOREIGN KEY (board_id) REFERENCES boards(id) ON DELETE CASCADE
+	);`
+	userWarningsStmt := `
+	CREATE TABLE IF NOT EXISTS user_warnings (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL,
+		post_id INTEGER,
+		reason TEXT NOT NULL,
+		issued_by TEXT NOT NULL,
+		created DATETIME NOT NULL,
+		acknowledged_at DATETIME,
+		FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE SET NULL
+	);`
+	userModNotesStmt := `
+	CREATE TABLE IF NOT EXISTS user_mod_notes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL,
+		body TEXT NOT NULL,
+		author TEXT NOT NULL,
+		created DATETIME NOT NULL
+	);`
+	networkBansStmt := `
+	CREATE TABLE IF NOT EXISTS network_bans (
+		network TEXT PRIMARY KEY,
+		reason TEXT NOT NULL,
+		banned_by TEXT NOT NULL,
+		created DATETIME NOT NULL
+	);`
+	loginHistoryStmt := `
+	CREATE TABLE IF NOT EXISTS login_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL,
+		created DATETIME NOT NULL,
+		ip TEXT,
+		user_agent TEXT
+	);`
+	attachmentsStmt := `
+	CREATE TABLE IF NOT EXISTS attachments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		storage_key TEXT NOT NULL UNIQUE,
+		content_type TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		width INTEGER NOT NULL,
+		height INTEGER NOT NULL,
+		thumb_key TEXT NOT NULL,
+		thumb_width INTEGER NOT NULL,
+		thumb_height INTEGER NOT NULL,
+		uploaded_by TEXT NOT NULL,
+		created DATETIME NOT NULL
+	);`
+	postVotesStmt := `
+	CREATE TABLE IF NOT EXISTS post_votes (
+		post_id INTEGER NOT NULL,
+		username TEXT NOT NULL,
+		value INTEGER NOT NULL,
+		created DATETIME NOT NULL,
+		PRIMARY KEY (post_id, username),
+		FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
+	);`
 	cardTreesStmt := `
 	CREATE TABLE IF NOT EXISTS card_trees (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -151,6 +301,27 @@ func migrateSQLite(db *sql.DB) error {
 	if err := ensurePostModerationColumns(db); err != nil {
 		return err
 	}
+	if err := ensureColumns(db, "posts", "email TEXT", "badge TEXT", "ip TEXT", "rendered_content TEXT", "render_version INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensurePostsPostedByColumn(db); err != nil {
+		return err
+	}
+	if err := ensureThreadsLastBumpColumn(db); err != nil {
+		return err
+	}
+	if err := ensureColumns(db, "threads", "accepted_post_id INTEGER", "slow_mode_seconds INTEGER NOT NULL DEFAULT 0", "is_locked BOOLEAN NOT NULL DEFAULT 0", "is_sticky BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumns(db, "boards", "rules TEXT", "visibility TEXT NOT NULL DEFAULT 'public'", "anon_name TEXT", "require_title BOOLEAN NOT NULL DEFAULT 1", "necro_days INTEGER", "retention_days INTEGER"); err != nil {
+		return err
+	}
+	if err := ensureBoardPostNumbers(db); err != nil {
+		return err
+	}
+	if err := ensureColumns(db, "users", "disabled_at DATETIME", "last_login DATETIME"); err != nil {
+		return err
+	}
 	if _, err := db.Exec(cardTreesStmt); err != nil {
 		return err
 	}
@@ -169,9 +340,52 @@ func migrateSQLite(db *sql.DB) error {
 	if _, err := db.Exec(reportsIndexStmt); err != nil {
 		return err
 	}
+	if _, err := db.Exec(postVotesStmt); err != nil {
+		return err
+	}
+	if _, err := db.Exec(boardMembersStmt); err != nil {
+		return err
+	}
+	if _, err := db.Exec(userWarningsStmt); err != nil {
+		return err
+	}
+	if _, err := db.Exec(userModNotesStmt); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS user_mod_notes_username_idx ON user_mod_notes(username, created)`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS posts_ip_idx ON posts(ip)`); err != nil {
+		return err
+	}
+	if err := ensurePostsIPKeyColumn(db); err != nil {
+		return err
+	}
+	if _, err := db.Exec(networkBansStmt); err != nil {
+		return err
+	}
+	if _, err := db.Exec(loginHistoryStmt); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS login_history_username_idx ON login_history(username, created)`); err != nil {
+		return err
+	}
 	if _, err := db.Exec(klaxonsStmt); err != nil {
 		return err
 	}
+	if _, err := db.Exec(klaxonHistoryStmt); err != nil {
+		return err
+	}
+	if _, err := db.Exec(modActionsStmt); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS mod_actions_created_idx ON mod_actions(created)`); err != nil {
+		return err
+	}
+	ensureBoardNameIndex(db)
+	if _, err := db.Exec(attachmentsStmt); err != nil {
+		return err
+	}
 	if err := ensureSearchTables(db); err != nil {
 		return err
 	}
@@ -183,14 +397,24 @@ func migratePostgres(db *sql.DB) error {
 	CREATE TABLE IF NOT EXISTS boards (
 		id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
 		name TEXT NOT NULL,
-		description TEXT
+		description TEXT,
+		rules TEXT,
+		visibility TEXT NOT NULL DEFAULT 'public',
+		post_numbering TEXT NOT NULL DEFAULT 'global',
+		post_counter INTEGER NOT NULL DEFAULT 0,
+		anon_name TEXT,
+		require_title BOOLEAN NOT NULL DEFAULT TRUE,
+		necro_days INTEGER,
+		retention_days INTEGER
 	);`
 	usersStmt := `
 	CREATE TABLE IF NOT EXISTS users (
 		id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
 		username TEXT NOT NULL UNIQUE,
 		password_hash TEXT NOT NULL,
-		created TIMESTAMP NOT NULL
+		created TIMESTAMP NOT NULL,
+		disabled_at TIMESTAMP,
+		last_login TIMESTAMP
 	);`
 	threadsStmt := `
 	CREATE TABLE IF NOT EXISTS threads (
@@ -199,7 +423,12 @@ func migratePostgres(db *sql.DB) error {
 		title TEXT NOT NULL,
 		author TEXT,
 		tags TEXT,
-		created TIMESTAMP NOT NULL
+		created TIMESTAMP NOT NULL,
+		last_bump TIMESTAMP,
+		accepted_post_id INTEGER,
+		slow_mode_seconds INTEGER NOT NULL DEFAULT 0,
+		is_locked BOOLEAN NOT NULL DEFAULT FALSE,
+		is_sticky BOOLEAN NOT NULL DEFAULT FALSE
 	);`
 	postsStmt := `
 	CREATE TABLE IF NOT EXISTS posts (
@@ -212,7 +441,13 @@ func migratePostgres(db *sql.DB) error {
 		flair TEXT,
 		deleted_at TIMESTAMP,
 		deleted_by TEXT,
-		deleted_reason TEXT
+		deleted_reason TEXT,
+		email TEXT,
+		badge TEXT,
+		board_number INTEGER,
+		ip TEXT,
+		ip_key TEXT,
+		posted_by TEXT
 	);`
 	reportsStmt := `
 	CREATE TABLE IF NOT EXISTS reports (
@@ -235,6 +470,87 @@ func migratePostgres(db *sql.DB) error {
 		message TEXT,
 		updated_at TIMESTAMP NOT NULL
 	);`
+	klaxonHistoryStmt := `
+	CREATE TABLE IF NOT EXISTS klaxon_history (
+		id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		action TEXT NOT NULL,
+		tone TEXT,
+		emoji TEXT,
+		message TEXT,
+		actor TEXT NOT NULL,
+		created TIMESTAMP NOT NULL
+	);`
+	modActionsStmt := `
+	CREATE TABLE IF NOT EXISTS mod_actions (
+		id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		actor TEXT NOT NULL,
+		action TEXT NOT NULL,
+		board_id INTEGER,
+		thread_id INTEGER,
+		detail TEXT,
+		created TIMESTAMP NOT NULL
+	);`
+	boardMembersStmt := `
+	CREATE TABLE IF NOT EXISTS board_members (
+		board_id INTEGER NOT NULL REFERENCES boards(id) ON DELETE CASCADE,
+		username TEXT NOT NULL,
+		PRIMARY KEY (board_id, username)
+	);`
+	userWarningsStmt := `
+	CREATE TABLE IF NOT EXISTS user_warnings (
+		id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		username TEXT NOT NULL,
+		post_id INTEGER REFERENCES posts(id) ON DELETE SET NULL,
+		reason TEXT NOT NULL,
+		issued_by TEXT NOT NULL,
+		created TIMESTAMP NOT NULL,
+		acknowledged_at TIMESTAMP
+	);`
+	userModNotesStmt := `
+	CREATE TABLE IF NOT EXISTS user_mod_notes (
+		id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		username TEXT NOT NULL,
+		body TEXT NOT NULL,
+		author TEXT NOT NULL,
+		created TIMESTAMP NOT NULL
+	);`
+	networkBansStmt := `
+	CREATE TABLE IF NOT EXISTS network_bans (
+		network TEXT PRIMARY KEY,
+		reason TEXT NOT NULL,
+		banned_by TEXT NOT NULL,
+		created TIMESTAMP NOT NULL
+	);`
+	loginHistoryStmt := `
+	CREATE TABLE IF NOT EXISTS login_history (
+		id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		username TEXT NOT NULL,
+		created TIMESTAMP NOT NULL,
+		ip TEXT,
+		user_agent TEXT
+	);`
+	attachmentsStmt := `
+	CREATE TABLE IF NOT EXISTS attachments (
+		id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		storage_key TEXT NOT NULL UNIQUE,
+		content_type TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		width INTEGER NOT NULL,
+		height INTEGER NOT NULL,
+		thumb_key TEXT NOT NULL,
+		thumb_width INTEGER NOT NULL,
+		thumb_height INTEGER NOT NULL,
+		uploaded_by TEXT NOT NULL,
+		created TIMESTAMP NOT NULL
+	);`
+	postVotesStmt := `
+	CREATE TABLE IF NOT EXISTS post_votes (
+		post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+		username TEXT NOT NULL,
+		value INTEGER NOT NULL,
+		created TIMESTAMP NOT NULL,
+		PRIMARY KEY (post_id, username)
+	);`
 	cardTreesStmt := `
 	CREATE TABLE IF NOT EXISTS card_trees (
 		id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
@@ -292,6 +608,27 @@ func migratePostgres(db *sql.DB) error {
 	if err := ensurePostModerationColumns(db); err != nil {
 		return err
 	}
+	if err := ensureColumns(db, "posts", "email TEXT", "badge TEXT", "ip TEXT", "rendered_content TEXT", "render_version INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensurePostsPostedByColumn(db); err != nil {
+		return err
+	}
+	if err := ensureThreadsLastBumpColumn(db); err != nil {
+		return err
+	}
+	if err := ensureColumns(db, "threads", "accepted_post_id INTEGER", "slow_mode_seconds INTEGER NOT NULL DEFAULT 0", "is_locked BOOLEAN NOT NULL DEFAULT FALSE", "is_sticky BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+		return err
+	}
+	if err := ensureColumns(db, "boards", "rules TEXT", "visibility TEXT NOT NULL DEFAULT 'public'", "anon_name TEXT", "require_title BOOLEAN NOT NULL DEFAULT TRUE", "necro_days INTEGER", "retention_days INTEGER"); err != nil {
+		return err
+	}
+	if err := ensureBoardPostNumbers(db); err != nil {
+		return err
+	}
+	if err := ensureColumns(db, "users", "disabled_at TIMESTAMP", "last_login TIMESTAMP"); err != nil {
+		return err
+	}
 	if _, err := db.Exec(cardTreesStmt); err != nil {
 		return err
 	}
@@ -310,9 +647,52 @@ func migratePostgres(db *sql.DB) error {
 	if _, err := db.Exec(reportsIndexStmt); err != nil {
 		return err
 	}
+	if _, err := db.Exec(postVotesStmt); err != nil {
+		return err
+	}
+	if _, err := db.Exec(boardMembersStmt); err != nil {
+		return err
+	}
+	if _, err := db.Exec(userWarningsStmt); err != nil {
+		return err
+	}
+	if _, err := db.Exec(userModNotesStmt); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS user_mod_notes_username_idx ON user_mod_notes(username, created)`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS posts_ip_idx ON posts(ip)`); err != nil {
+		return err
+	}
+	if err := ensurePostsIPKeyColumn(db); err != nil {
+		return err
+	}
+	if _, err := db.Exec(networkBansStmt); err != nil {
+		return err
+	}
+	if _, err := db.Exec(loginHistoryStmt); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS login_history_username_idx ON login_history(username, created)`); err != nil {
+		return err
+	}
 	if _, err := db.Exec(klaxonsStmt); err != nil {
 		return err
 	}
+	if _, err := db.Exec(klaxonHistoryStmt); err != nil {
+		return err
+	}
+	if _, err := db.Exec(modActionsStmt); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS mod_actions_created_idx ON mod_actions(created)`); err != nil {
+		return err
+	}
+	ensureBoardNameIndex(db)
+	if _, err := db.Exec(attachmentsStmt); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -452,18 +832,29 @@ func buildFTSQuery(input string) string {
 	return strings.Join(terms, " AND ")
 }
 
-// seedData inserts a default board if none exist.
-func seedData(db *sql.DB) error {
+// seedData inserts the configured boards when seeding is enabled and none exist.
+func seedData(db *sql.DB, config SeedConfig) error {
+	if !config.Enabled {
+		log.Info("Seeding disabled; skipping seed data")
+		return nil
+	}
 	var count int
 	err := db.QueryRow("SELECT COUNT(*) FROM boards").Scan(&count)
 	if err != nil {
 		return err
 	}
-	if count == 0 {
-		_, err := db.Exec(`INSERT INTO boards (name, description) VALUES ($1, $2)`, "/test/", "A test board.")
-		if err != nil {
+	if count > 0 {
+		return nil
+	}
+	for _, board := range config.Boards {
+		name := strings.TrimSpace(board.Name)
+		if name == "" {
+			continue
+		}
+		if _, err := db.Exec(`INSERT INTO boards (name, description) VALUES ($1, $2)`, name, strings.TrimSpace(board.Description)); err != nil {
 			return err
 		}
+		log.Infof("Seeded board %s", name)
 	}
 	return nil
 }
@@ -554,29 +945,150 @@ func ensurePostModerationColumns(db *sql.DB) error {
 	return nil
 }
 
+// ensureColumns adds any of the given "name TYPE" columns missing from table.
+func ensureColumns(db *sql.DB, table string, columns ...string) error {
+	for _, column := range columns {
+		if dbDriver == "pgx" {
+			if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s`, table, column)); err != nil {
+				return err
+			}
+			continue
+		}
+		_, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s`, table, column))
+		if err == nil {
+			continue
+		}
+		lower := strings.ToLower(err.Error())
+		if strings.Contains(lower, "duplicate column") || strings.Contains(lower, "already exists") {
+			continue
+		}
+		return err
+	}
+	return nil
+}
+
+// ensurePostsPostedByColumn adds posts.posted_by, the account behind each
+// post even when it was posted anonymously, and backfills it from author.
+func ensurePostsPostedByColumn(db *sql.DB) error {
+	if err := ensureColumns(db, "posts", "posted_by TEXT"); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS posts_posted_by_idx ON posts(posted_by, created)`); err != nil {
+		return err
+	}
+	_, err := db.Exec(`UPDATE posts SET posted_by = author WHERE posted_by IS NULL AND author <> ''`)
+	return err
+}
+
+// ensureThreadsLastBumpColumn adds threads.last_bump and backfills it from
+// each thread's newest post.
+func ensureThreadsLastBumpColumn(db *sql.DB) error {
+	column := "last_bump DATETIME"
+	if dbDriver == "pgx" {
+		column = "last_bump TIMESTAMP"
+	}
+	if err := ensureColumns(db, "threads", column); err != nil {
+		return err
+	}
+	_, err := db.Exec(`
+		UPDATE threads
+		SET last_bump = COALESCE((SELECT MAX(p.created) FROM posts p WHERE p.thread_id = threads.id), created)
+		WHERE last_bump IS NULL`)
+	return err
+}
+
+// ensureBoardPostNumbers adds per-board post numbering and numbers existing
+// posts in id order within each board.
+func ensureBoardPostNumbers(db *sql.DB) error {
+	if err := ensureColumns(db, "boards", "post_numbering TEXT NOT NULL DEFAULT 'global'", "post_counter INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumns(db, "posts", "board_number INTEGER"); err != nil {
+		return err
+	}
+	result, err := db.Exec(`
+		UPDATE posts
+		SET board_number = (
+			SELECT COUNT(*)
+			FROM posts p
+			JOIN threads t ON t.id = p.thread_id
+			WHERE t.board_id = (SELECT board_id FROM threads WHERE id = posts.thread_id)
+				AND p.id <= posts.id
+		)
+		WHERE board_number IS NULL`)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return err
+	}
+	_, err = db.Exec(`
+		UPDATE boards
+		SET post_counter = COALESCE((
+			SELECT MAX(p.board_number)
+			FROM posts p
+			JOIN threads t ON t.id = p.thread_id
+			WHERE t.board_id = boards.id
+		), 0)`)
+	return err
+}
+
 // ensureSeedUser creates a default user when none exists for the configured username.
-func ensureSeedUser(db *sql.DB, username, password string) error {
+// An existing user's password is never overwritten.
+func ensureSeedUser(ctx context.Context, db *sql.DB, username, password string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	if username == "" || password == "" {
 		return nil
 	}
-	if userExists(db, username) {
+	if userExists(ctx, db, username) {
+		if !authenticateUser(ctx, db, username, password) {
+			log.Infof("User %q already exists with a different password; keeping the stored password", username)
+		}
 		return nil
 	}
-	_, err := createUser(db, username, password)
+	_, err := createUser(ctx, db, username, password)
 	return err
 }
 
-// createBoard inserts a new board into the database.
-func createBoard(db *sql.DB, name, description string) (*Board, error) {
+var (
+	errBoardNotFound     = errors.New("board not found")
+	errBoardNameTaken    = errors.New("board name already taken")
+	errBoardLimitReached = errors.New("board limit reached")
+)
+
+// createBoard inserts a new board into the database, refusing names another
+// board already has and, when maxBoards is set, boards past the cap.
+func createBoard(ctx context.Context, db *sql.DB, name, description string) (*Board, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	if maxBoards > 0 {
+		var count int
+		if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM boards`).Scan(&count); err != nil {
+			return nil, err
+		}
+		if count >= maxBoards {
+			return nil, errBoardLimitReached
+		}
+	}
+	if _, err := getBoardByName(ctx, db, name, false); err == nil {
+		return nil, errBoardNameTaken
+	} else if !errors.Is(err, errBoardNotFound) {
+		return nil, err
+	}
 	var id int
 	if dbDriver == "pgx" {
-		err := db.QueryRow(`INSERT INTO boards (name, description) VALUES ($1, $2) RETURNING id`, name, description).Scan(&id)
-		if err != nil {
+		err := db.QueryRowContext(ctx, `INSERT INTO boards (name, description) VALUES ($1, $2) RETURNING id`, name, description).Scan(&id)
+		if isUniqueViolation(err) {
+			return nil, errBoardNameTaken
+		} else if err != nil {
 			return nil, err
 		}
 	} else {
-		result, err := db.Exec(`INSERT INTO boards (name, description) VALUES ($1, $2)`, name, description)
-		if err != nil {
+		result, err := db.ExecContext(ctx, `INSERT INTO boards (name, description) VALUES ($1, $2)`, name, description)
+		if isUniqueViolation(err) {
+			return nil, errBoardNameTaken
+		} else if err != nil {
 			return nil, err
 		}
 		insertID, err := result.LastInsertId()
@@ -586,17 +1098,43 @@ func createBoard(db *sql.DB, name, description string) (*Board, error) {
 		id = int(insertID)
 	}
 	return &Board{
-		ID:          id,
-		Name:        name,
-		Description: description,
-		Threads:     []*Thread{},
+		ID:            id,
+		Name:          name,
+		Description:   description,
+		Visibility:    boardVisibilityPublic,
+		PostNumbering: postNumberingGlobal,
+		RequireTitle:  true,
+		Threads:       []*Thread{},
 	}, nil
 }
 
+// ensureBoardNameIndex makes board names unique, ignoring surrounding
+// whitespace. A database that already holds duplicate names keeps working
+// without the index, with a warning to rename them.
+func ensureBoardNameIndex(db *sql.DB) {
+	if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS boards_name_key ON boards ((TRIM(name)))`); err != nil {
+		log.Warnf("Board names aren't unique, so duplicates can't be refused until they're renamed: %v", err)
+	}
+}
+
+// isUniqueViolation reports whether err is a unique constraint failure.
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
 // updateBoardByID updates a board's name and description.
-func updateBoardByID(db *sql.DB, boardID int, name, description string) error {
-	result, err := db.Exec(`UPDATE boards SET name = $1, description = $2 WHERE id = $3`, name, description, boardID)
-	if err != nil {
+func updateBoardByID(ctx context.Context, db *sql.DB, boardID int, name, description string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result, err := db.ExecContext(ctx, `UPDATE boards SET name = $1, description = $2 WHERE id = $3`, name, description, boardID)
+	if isUniqueViolation(err) {
+		return errBoardNameTaken
+	} else if err != nil {
 		return err
 	}
 	affected, err := result.RowsAffected()
@@ -609,29 +1147,222 @@ func updateBoardByID(db *sql.DB, boardID int, name, description string) error {
 	return nil
 }
 
-// getAllBoards retrieves all boards from the database.
-func getAllBoards(db *sql.DB) ([]*Board, error) {
-	rows, err := db.Query(`SELECT id, name, description FROM boards`)
+// setBoardRules replaces a board's posting rules (markdown).
+func setBoardRules(ctx context.Context, db *sql.DB, boardID int, rules string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result, err := db.ExecContext(ctx, `UPDATE boards SET rules = $1 WHERE id = $2`, rules, boardID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("board not found")
+	}
+	return nil
+}
 
-	var boards []*Board
+// normalizePostNumbering maps unknown or empty post number formats to global.
+func normalizePostNumbering(format string) string {
+	switch format = strings.ToLower(strings.TrimSpace(format)); format {
+	case postNumberingBoard, postNumberingHidden:
+		return format
+	default:
+		return postNumberingGlobal
+	}
+}
+
+// setBoardPostNumbering sets how a board's thread views number posts.
+func setBoardPostNumbering(ctx context.Context, db *sql.DB, boardID int, format string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result, err := db.ExecContext(ctx, `UPDATE boards SET post_numbering = $1 WHERE id = $2`, normalizePostNumbering(format), boardID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("board not found")
+	}
+	return nil
+}
+
+// maxAnonNameLength caps a board's anonymous author label.
+const maxAnonNameLength = 32
+
+// normalizeAnonName trims a board's anonymous author label and caps its
+// length. Empty leaves anonymous posts without an author.
+func normalizeAnonName(name string) string {
+	name = strings.TrimSpace(name)
+	if runes := []rune(name); len(runes) > maxAnonNameLength {
+		name = strings.TrimSpace(string(runes[:maxAnonNameLength]))
+	}
+	return name
+}
+
+// setBoardAnonName sets the author label given to anonymous posts on a board.
+func setBoardAnonName(ctx context.Context, db *sql.DB, boardID int, name string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result, err := db.ExecContext(ctx, `UPDATE boards SET anon_name = $1 WHERE id = $2`, normalizeAnonName(name), boardID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("board not found")
+	}
+	return nil
+}
+
+// setBoardRequireTitle sets whether new threads on a board need a title.
+func setBoardRequireTitle(ctx context.Context, db *sql.DB, boardID int, required bool) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result, err := db.ExecContext(ctx, `UPDATE boards SET require_title = $1 WHERE id = $2`, required, boardID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("board not found")
+	}
+	return nil
+}
+
+// setBoardNecroDays sets how many days without a bump mark a thread on a
+// board as necro; nil uses the site-wide threshold and 0 never warns.
+func setBoardNecroDays(ctx context.Context, db *sql.DB, boardID int, days *int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result, err := db.ExecContext(ctx, `UPDATE boards SET necro_days = $1 WHERE id = $2`, days, boardID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("board not found")
+	}
+	return nil
+}
+
+// setBoardRetentionDays sets how many days a thread on a board may sit
+// without a post before the retention janitor deletes it; nil uses the
+// site-wide setting and 0 keeps threads forever.
+func setBoardRetentionDays(ctx context.Context, db *sql.DB, boardID int, days *int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result, err := db.ExecContext(ctx, `UPDATE boards SET retention_days = $1 WHERE id = $2`, days, boardID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("board not found")
+	}
+	return nil
+}
+
+// nextBoardPostNumber advances the counter of the board threadID is on and
+// returns the new value. Every post gets one, whatever the board's format, so
+// switching to per-board numbering later needs no backfill.
+func nextBoardPostNumber(ctx context.Context, db dbConn, threadID int) (sql.NullInt64, error) {
+	var number sql.NullInt64
+	err := db.QueryRowContext(ctx, `
+		UPDATE boards
+		SET post_counter = post_counter + 1
+		WHERE id = (SELECT board_id FROM threads WHERE id = $1)
+		RETURNING post_counter`, threadID).Scan(&number)
+	if err == sql.ErrNoRows {
+		return number, nil
+	}
+	return number, err
+}
+
+// countThreadsByBoardID counts the threads on a board.
+func countThreadsByBoardID(ctx context.Context, db *sql.DB, boardID int) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	var count int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM threads WHERE board_id = $1`, boardID).Scan(&count)
+	return count, err
+}
+
+// getBoardThreadCounts counts the threads on every board in one query.
+// Boards without threads are left out.
+func getBoardThreadCounts(ctx context.Context, db *sql.DB) (map[int]int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `SELECT board_id, COUNT(*) FROM threads GROUP BY board_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[int]int{}
+	for rows.Next() {
+		var boardID, count int
+		if err := rows.Scan(&boardID, &count); err != nil {
+			return nil, err
+		}
+		counts[boardID] = count
+	}
+	return counts, rows.Err()
+}
+
+// getAllBoards retrieves all boards from the database.
+func getAllBoards(ctx context.Context, db *sql.DB) ([]*Board, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `SELECT id, name, description, visibility, require_title FROM boards`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var boards []*Board
 	for rows.Next() {
 		var b Board
-		if err := rows.Scan(&b.ID, &b.Name, &b.Description); err != nil {
+		var visibility sql.NullString
+		if err := rows.Scan(&b.ID, &b.Name, &b.Description, &visibility, &b.RequireTitle); err != nil {
 			return nil, err
 		}
+		b.Visibility = normalizeBoardVisibility(visibility.String)
 		boards = append(boards, &b)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 	return boards, nil
 }
 
-func searchBoards(db *sql.DB, query string, limit int) ([]*Board, error) {
+// searchBoards finds boards matching query. Restricted boards viewer can't
+// see are filtered out before the limit applies.
+func searchBoards(ctx context.Context, db *sql.DB, viewer, query string, limit int) ([]*Board, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	if strings.TrimSpace(query) == "" {
 		return []*Board{}, nil
 	}
+	visible, visibleArgs := visibleBoardClause("b.id", viewer, 2)
+	limitParam := fmt.Sprintf("LIMIT $%d", len(visibleArgs)+2)
 	var rows *sql.Rows
 	var err error
 	if dbDriver == "sqlite3" && sqliteFTSAvailable {
@@ -639,29 +1370,29 @@ func searchBoards(db *sql.DB, query string, limit int) ([]*Board, error) {
 		if ftsQuery == "" {
 			return []*Board{}, nil
 		}
-		rows, err = db.Query(`
+		rows, err = db.QueryContext(ctx, `
 			SELECT b.id, b.name, b.description
 			FROM boards_fts
 			JOIN boards b ON b.id = boards_fts.rowid
-			WHERE boards_fts MATCH $1
+			WHERE boards_fts MATCH $1 AND `+visible+`
 			ORDER BY bm25(boards_fts)
-			LIMIT $2`, ftsQuery, limit)
+			`+limitParam, append(append([]interface{}{ftsQuery}, visibleArgs...), limit)...)
 	} else if dbDriver == "sqlite3" {
 		like := "%" + query + "%"
-		rows, err = db.Query(`
-			SELECT id, name, description
-			FROM boards
-			WHERE name LIKE $1 COLLATE NOCASE OR description LIKE $1 COLLATE NOCASE
-			ORDER BY id DESC
-			LIMIT $2`, like, limit)
+		rows, err = db.QueryContext(ctx, `
+			SELECT b.id, b.name, b.description
+			FROM boards b
+			WHERE (b.name LIKE $1 COLLATE NOCASE OR b.description LIKE $1 COLLATE NOCASE) AND `+visible+`
+			ORDER BY b.id DESC
+			`+limitParam, append(append([]interface{}{like}, visibleArgs...), limit)...)
 	} else {
 		like := "%" + query + "%"
-		rows, err = db.Query(`
-			SELECT id, name, description
-			FROM boards
-			WHERE name ILIKE $1 OR description ILIKE $1
-			ORDER BY id DESC
-			LIMIT $2`, like, limit)
+		rows, err = db.QueryContext(ctx, `
+			SELECT b.id, b.name, b.description
+			FROM boards b
+			WHERE (b.name ILIKE $1 OR b.description ILIKE $1) AND `+visible+`
+			ORDER BY b.id DESC
+			`+limitParam, append(append([]interface{}{like}, visibleArgs...), limit)...)
 	}
 	if err != nil {
 		return nil, err
@@ -676,22 +1407,41 @@ func searchBoards(db *sql.DB, query string, limit int) ([]*Board, error) {
 		}
 		boards = append(boards, &b)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 	return boards, nil
 }
 
 // getBoardByID retrieves a specific board by ID, optionally loading its threads.
-func getBoardByID(db *sql.DB, boardID int, loadThreads bool) (*Board, error) {
+func getBoardByID(ctx context.Context, db *sql.DB, boardID int, loadThreads bool) (*Board, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	var b Board
-	err := db.QueryRow(`SELECT id, name, description FROM boards WHERE id = $1`, boardID).
-		Scan(&b.ID, &b.Name, &b.Description)
+	var rules, visibility, postNumbering, anonName sql.NullString
+	var necroDays, retentionDays sql.NullInt64
+	err := db.QueryRowContext(ctx, `SELECT id, name, description, rules, visibility, post_numbering, anon_name, require_title, necro_days, retention_days FROM boards WHERE id = $1`, boardID).
+		Scan(&b.ID, &b.Name, &b.Description, &rules, &visibility, &postNumbering, &anonName, &b.RequireTitle, &necroDays, &retentionDays)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("board not found")
+		return nil, errBoardNotFound
 	} else if err != nil {
 		return nil, err
 	}
+	b.Rules = rules.String
+	b.Visibility = normalizeBoardVisibility(visibility.String)
+	b.PostNumbering = normalizePostNumbering(postNumbering.String)
+	b.AnonName = anonName.String
+	if necroDays.Valid {
+		days := int(necroDays.Int64)
+		b.NecroDays = &days
+	}
+	if retentionDays.Valid {
+		days := int(retentionDays.Int64)
+		b.RetentionDays = &days
+	}
 
 	if loadThreads {
-		threads, err := getThreadsByBoardID(db, boardID, true)
+		threads, err := getThreadsByBoardID(ctx, db, boardID, defaultThreadSort, true)
 		if err != nil {
 			return nil, err
 		}
@@ -700,17 +1450,63 @@ func getBoardByID(db *sql.DB, boardID int, loadThreads bool) (*Board, error) {
 	return &b, nil
 }
 
-func userExists(db *sql.DB, username string) bool {
+// getBoardByName retrieves a board by its exact name (e.g. "/test/"),
+// optionally loading its threads.
+func getBoardByName(ctx context.Context, db *sql.DB, name string, loadThreads bool) (*Board, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	var boardID int
+	err := db.QueryRowContext(ctx, `SELECT id FROM boards WHERE name = $1 ORDER BY id LIMIT 1`, strings.TrimSpace(name)).Scan(&boardID)
+	if err == sql.ErrNoRows {
+		return nil, errBoardNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return getBoardByID(ctx, db, boardID, loadThreads)
+}
+
+// getBoardByCode resolves a short board code ("test") to the board named
+// "/test/", falling back to one named just "test".
+func getBoardByCode(ctx context.Context, db *sql.DB, code string) (*Board, error) {
+	code = strings.Trim(strings.TrimSpace(code), "/")
+	if code == "" {
+		return nil, errBoardNotFound
+	}
+	board, err := getBoardByName(ctx, db, "/"+code+"/", false)
+	if errors.Is(err, errBoardNotFound) {
+		return getBoardByName(ctx, db, code, false)
+	}
+	return board, err
+}
+
+// userActive reports whether username exists and isn't disabled, for
+// checking sessions and tokens.
+func userActive(ctx context.Context, db *sql.DB, username string) bool {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	var id int
+	err := db.QueryRowContext(ctx, `SELECT id FROM users WHERE username = $1 AND disabled_at IS NULL`, username).Scan(&id)
+	return err == nil
+}
+
+func userExists(ctx context.Context, db *sql.DB, username string) bool {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	var id int
-	err := db.QueryRow(`SELECT id FROM users WHERE username = $1`, username).Scan(&id)
+	err := db.QueryRowContext(ctx, `SELECT id FROM users WHERE username = $1`, username).Scan(&id)
 	if err == sql.ErrNoRows {
 		return false
 	}
 	return err == nil
 }
 
-func createUser(db *sql.DB, username, password string) (*User, error) {
-	if userExists(db, username) {
+func createUser(ctx context.Context, db *sql.DB, username, password string) (*User, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	if username == deletedUsername {
+		return nil, fmt.Errorf("username is reserved")
+	}
+	if userExists(ctx, db, username) {
 		return nil, fmt.Errorf("username already exists")
 	}
 	passwordHash, err := hashPassword(password)
@@ -720,12 +1516,12 @@ func createUser(db *sql.DB, username, password string) (*User, error) {
 	now := time.Now()
 	var id int
 	if dbDriver == "pgx" {
-		err := db.QueryRow(`INSERT INTO users (username, password_hash, created) VALUES ($1, $2, $3) RETURNING id`, username, passwordHash, now).Scan(&id)
+		err := db.QueryRowContext(ctx, `INSERT INTO users (username, password_hash, created) VALUES ($1, $2, $3) RETURNING id`, username, passwordHash, now).Scan(&id)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		result, err := db.Exec(`INSERT INTO users (username, password_hash, created) VALUES ($1, $2, $3)`, username, passwordHash, now)
+		result, err := db.ExecContext(ctx, `INSERT INTO users (username, password_hash, created) VALUES ($1, $2, $3)`, username, passwordHash, now)
 		if err != nil {
 			return nil, err
 		}
@@ -743,42 +1539,58 @@ func createUser(db *sql.DB, username, password string) (*User, error) {
 	}, nil
 }
 
-func getUserPasswordHash(db *sql.DB, username string) (string, error) {
+// getUserPasswordHash returns the stored hash for an account that can log in;
+// disabled accounts are treated as missing.
+func getUserPasswordHash(ctx context.Context, db *sql.DB, username string) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	var passwordHash string
-	err := db.QueryRow(`SELECT password_hash FROM users WHERE username = $1`, username).Scan(&passwordHash)
+	err := db.QueryRowContext(ctx, `SELECT password_hash FROM users WHERE username = $1 AND disabled_at IS NULL`, username).Scan(&passwordHash)
 	if err != nil {
 		return "", err
 	}
 	return passwordHash, nil
 }
 
-func getUserByUsername(db *sql.DB, username string) (*User, error) {
+func getUserByUsername(ctx context.Context, db *sql.DB, username string) (*User, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	var user User
-	err := db.QueryRow(`SELECT id, username, password_hash, created FROM users WHERE username = $1`, username).
-		Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Created)
+	var lastLogin sql.NullTime
+	err := db.QueryRowContext(ctx, `SELECT id, username, password_hash, created, last_login FROM users WHERE username = $1`, username).
+		Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Created, &lastLogin)
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("user not found")
 	}
 	if err != nil {
 		return nil, err
 	}
+	if lastLogin.Valid {
+		user.LastLogin = &lastLogin.Time
+	}
 	return &user, nil
 }
 
-func getThreadsByAuthor(db *sql.DB, username string) ([]*ProfileThread, error) {
-	rows, err := db.Query(`
+// getThreadsByAuthor lists the threads username started, newest first,
+// leaving out those on restricted boards viewer can't see.
+func getThreadsByAuthor(ctx context.Context, db *sql.DB, username, viewer string) ([]*ProfileThread, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	visible, visibleArgs := visibleBoardClause("t.board_id", viewer, 3)
+	args := append([]interface{}{username, username}, visibleArgs...)
+	rows, err := db.QueryContext(ctx, `
 		SELECT t.id, t.board_id, t.title, t.created
 		FROM threads t
 		LEFT JOIN (
-			SELECT thread_id, MIN(created) AS first_created
+			SELECT thread_id, MIN(id) AS first_id
 			FROM posts
 			GROUP BY thread_id
 		) fp ON fp.thread_id = t.id
-		LEFT JOIN posts fp_post
-			ON fp_post.thread_id = t.id AND fp_post.created = fp.first_created
-		WHERE t.author = $1
-			OR ((t.author IS NULL OR t.author = '') AND fp_post.author = $2)
-		ORDER BY t.created DESC`, username, username)
+		LEFT JOIN posts fp_post ON fp_post.id = fp.first_id
+		WHERE (t.author = $1
+			OR ((t.author IS NULL OR t.author = '') AND fp_post.author = $2))
+			AND `+visible+`
+		ORDER BY t.created DESC, t.id DESC`, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -792,16 +1604,25 @@ func getThreadsByAuthor(db *sql.DB, username string) ([]*ProfileThread, error) {
 		}
 		threads = append(threads, &t)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 	return threads, nil
 }
 
-func getPostsByAuthor(db *sql.DB, username string) ([]*ProfilePost, error) {
-	rows, err := db.Query(`
+// getPostsByAuthor lists username's posts, newest first, leaving out those
+// on restricted boards viewer can't see.
+func getPostsByAuthor(ctx context.Context, db *sql.DB, username, viewer string) ([]*ProfilePost, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	visible, visibleArgs := visibleBoardClause("threads.board_id", viewer, 2)
+	args := append([]interface{}{username}, visibleArgs...)
+	rows, err := db.QueryContext(ctx, `
 		SELECT posts.id, posts.thread_id, threads.title, posts.content, posts.created, posts.deleted_at
 		FROM posts
 		JOIN threads ON posts.thread_id = threads.id
-		WHERE posts.author = $1
-		ORDER BY posts.created DESC`, username)
+		WHERE posts.author = $1 AND `+visible+`
+		ORDER BY posts.created DESC, posts.id DESC`, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -819,14 +1640,19 @@ func getPostsByAuthor(db *sql.DB, username string) ([]*ProfilePost, error) {
 		}
 		posts = append(posts, &p)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 	return posts, nil
 }
 
-func authenticateUser(db *sql.DB, username, password string) bool {
+func authenticateUser(ctx context.Context, db *sql.DB, username, password string) bool {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	if username == "" || password == "" {
 		return false
 	}
-	passwordHash, err := getUserPasswordHash(db, username)
+	passwordHash, err := getUserPasswordHash(ctx, db, username)
 	if err != nil {
 		return false
 	}
@@ -834,23 +1660,25 @@ func authenticateUser(db *sql.DB, username, password string) bool {
 }
 
 // createThread inserts a new thread into the database.
-func createThread(db *sql.DB, boardID int, title, author string, tags []string) (*Thread, error) {
+func createThread(ctx context.Context, db dbConn, boardID int, title, author string, tags []string) (*Thread, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	now := time.Now()
 	var id int
 	tagString := strings.Join(normalizeTags(tags), ",")
 	if dbDriver == "pgx" {
-		err := db.QueryRow(`
-		INSERT INTO threads (board_id, title, author, tags, created) 
-		VALUES ($1, $2, $3, $4, $5)
+		err := db.QueryRowContext(ctx, `
+		INSERT INTO threads (board_id, title, author, tags, created, last_bump) 
+		VALUES ($1, $2, $3, $4, $5, $5)
 		RETURNING id`,
 			boardID, title, author, tagString, now).Scan(&id)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		result, err := db.Exec(`
-		INSERT INTO threads (board_id, title, author, tags, created) 
-		VALUES ($1, $2, $3, $4, $5)`,
+		result, err := db.ExecContext(ctx, `
+		INSERT INTO threads (board_id, title, author, tags, created, last_bump) 
+		VALUES ($1, $2, $3, $4, $5, $5)`,
 			boardID, title, author, tagString, now)
 		if err != nil {
 			return nil, err
@@ -863,40 +1691,74 @@ func createThread(db *sql.DB, boardID int, title, author string, tags []string)
 		id = int(insertID)
 	}
 	return &Thread{
-		ID:      id,
-		Title:   title,
-		Author:  author,
-		Posts:   []*Post{},
-		Created: now,
-		Tags:    normalizeTags(tags),
+		ID:       id,
+		Title:    title,
+		Author:   author,
+		Posts:    []*Post{},
+		Created:  now,
+		LastBump: now,
+		Tags:     normalizeTags(tags),
 	}, nil
 }
 
-// getThreadsByBoardID retrieves all threads for a specific board, optionally loading their posts.
-func getThreadsByBoardID(db *sql.DB, boardID int, loadPosts bool) ([]*Thread, error) {
-	rows, err := db.Query(`
-		SELECT id, title, author, tags, created
-		FROM threads
-		WHERE board_id = $1
-		ORDER BY created DESC`, boardID)
+// threadListSelect selects the columns read by scanThreadListRows, with reply
+// counts aggregated from posts.
+const threadListSelect = `
+		SELECT t.id, t.title, t.author, t.tags, t.created, ` + threadBumpKey + ` AS bumped,
+			CASE WHEN ps.post_count > 0 THEN ps.post_count - 1 ELSE 0 END AS reply_count, t.is_sticky
+		FROM threads t
+		LEFT JOIN (
+			SELECT thread_id, COUNT(*) AS post_count, MAX(created) AS last_post
+			FROM posts
+			GROUP BY thread_id
+		) ps ON ps.thread_id = t.id`
+
+// threadBumpKey is a listed thread's bump time. It falls back to the newest
+// post for threads whose last_bump was never set.
+const threadBumpKey = "COALESCE(t.last_bump, ps.last_post, t.created)"
+
+const threadBumpOrder = threadBumpKey + " DESC, t.id DESC"
+
+// threadSortOrders maps the board listing sort keys to ORDER BY clauses.
+var threadSortOrders = map[string]string{
+	"bump":    threadBumpOrder,
+	"new":     "t.created DESC, t.id DESC",
+	"replies": "reply_count DESC, " + threadBumpOrder,
+	"tags":    "CASE WHEN t.tags IS NULL OR t.tags = '' THEN 1 ELSE 0 END, t.tags ASC, " + threadBumpOrder,
+}
+
+const defaultThreadSort = "bump"
+
+// getThreadsByBoardID retrieves all threads for a specific board in the given
+// sort order (see threadSortOrders), stickies first, optionally loading their
+// posts. Reply
+// counts come from an aggregate over posts, so listings don't need to load
+// post bodies.
+func getThreadsByBoardID(ctx context.Context, db *sql.DB, boardID int, sortKey string, loadPosts bool) ([]*Thread, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	order, ok := threadSortOrders[sortKey]
+	if !ok {
+		order = threadSortOrders[defaultThreadSort]
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		%s
+		WHERE t.board_id = $1
+		ORDER BY t.is_sticky DESC, %s`, threadListSelect, order), boardID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var threads []*Thread
-	for rows.Next() {
-		var t Thread
-		var author sql.NullString
-		var tagString sql.NullString
-		if err := rows.Scan(&t.ID, &t.Title, &author, &tagString, &t.Created); err != nil {
-			return nil, err
-		}
-		t.Author = author.String
-		t.Tags = tagsFromString(tagString.String)
+	threads, err := scanThreadListRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
 
-		if loadPosts {
-			posts, err := getPostsByThreadID(db, t.ID)
+	if loadPosts {
+		for _, t := range threads {
+			posts, err := getPostsByThreadID(ctx, db, t.ID)
 			if err != nil {
 				return nil, err
 			}
@@ -909,15 +1771,205 @@ func getThreadsByBoardID(db *sql.DB, boardID int, loadPosts bool) ([]*Thread, er
 				break
 			}
 		}
+	}
+	return threads, nil
+}
+
+// getThreadsAfterCursor returns up to limit threads on a board in bump order,
+// starting after the (last bump, id) cursor position when one is given.
+func getThreadsAfterCursor(ctx context.Context, db *sql.DB, boardID int, after *threadCursor, limit int) ([]*Thread, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := threadListSelect + `
+		WHERE t.board_id = $1`
+	args := []interface{}{boardID}
+	if after != nil {
+		query += `
+		AND (` + threadBumpKey + ` < $2 OR (` + threadBumpKey + ` = $2 AND t.id < $3))`
+		args = append(args, after.LastBump, after.ID)
+	}
+	query += fmt.Sprintf(`
+		ORDER BY %s
+		LIMIT %d`, threadBumpOrder, limit)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanThreadListRows(rows)
+}
+
+// scanThreadListRows reads thread rows selected with threadListSelect.
+func scanThreadListRows(rows *sql.Rows) ([]*Thread, error) {
+	var threads []*Thread
+	for rows.Next() {
+		var t Thread
+		var author sql.NullString
+		var tagString sql.NullString
+		var lastBump sqlTime
+		if err := rows.Scan(&t.ID, &t.Title, &author, &tagString, &t.Created, &lastBump, &t.ReplyCount, &t.IsSticky); err != nil {
+			return nil, err
+		}
+		t.LastBump = t.Created
+		if lastBump.Valid {
+			t.LastBump = lastBump.Time
+		}
+		t.Author = author.String
+		t.Tags = tagsFromString(tagString.String)
+		threads = append(threads, &t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return threads, nil
+}
+
+// sqlTime scans a nullable timestamp. SQLite returns computed timestamps,
+// such as a COALESCE of datetime columns, as text, since only table columns
+// carry the declared type the driver converts by.
+type sqlTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+func (t *sqlTime) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*t = sqlTime{}
+		return nil
+	case time.Time:
+		*t = sqlTime{Time: v, Valid: true}
+		return nil
+	case []byte:
+		return t.parse(string(v))
+	case string:
+		return t.parse(v)
+	}
+	return fmt.Errorf("cannot scan %T into a timestamp", value)
+}
+
+func (t *sqlTime) parse(s string) error {
+	s = strings.TrimSuffix(s, "Z")
+	for _, layout := range sqlite3.SQLiteTimestampFormats {
+		if parsed, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			*t = sqlTime{Time: parsed, Valid: true}
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot parse timestamp %q", s)
+}
+
+// getRecentThreads returns the newest threads, optionally limited to one board
+// (boardID > 0) and/or one tag. Restricted boards are left out since the
+// feeds built from this are public.
+func getRecentThreads(ctx context.Context, db *sql.DB, boardID int, tag string, limit int) ([]*RecentThread, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+		SELECT t.id, t.board_id, b.name, t.title, t.author, t.tags, t.created
+		FROM threads t
+		JOIN boards b ON b.id = t.board_id
+		WHERE b.visibility <> $1`
+	args := []any{boardVisibilityRestricted}
+	if boardID > 0 {
+		args = append(args, boardID)
+		query += fmt.Sprintf(" AND t.board_id = $%d", len(args))
+	}
+	if tag != "" {
+		args = append(args, "%,"+tag+",%")
+		query += fmt.Sprintf(" AND (',' || COALESCE(t.tags, '') || ',') LIKE $%d", len(args))
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY t.created DESC, t.id DESC LIMIT $%d", len(args))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var threads []*RecentThread
+	for rows.Next() {
+		var t RecentThread
+		var author sql.NullString
+		var tagString sql.NullString
+		if err := rows.Scan(&t.ID, &t.BoardID, &t.BoardName, &t.Title, &author, &tagString, &t.Created); err != nil {
+			return nil, err
+		}
+		t.Author = author.String
+		t.Tags = tagsFromString(tagString.String)
+		threads = append(threads, &t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return threads, nil
+}
+
+// getTrending ranks threads by post velocity: how many live posts they got
+// within window of now. Threads on restricted boards viewer can't see are
+// filtered out before the limit and offset apply.
+func getTrending(ctx context.Context, db *sql.DB, viewer string, window time.Duration, limit, offset int) ([]*TrendingThread, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	visible, args := visibleBoardClause("t.board_id", viewer, 2)
+	args = append([]interface{}{time.Now().Add(-window)}, args...)
+	rows, err := db.QueryContext(ctx, `
+		SELECT t.id, t.board_id, b.name, t.title, t.author, COUNT(p.id) AS recent_posts
+		FROM posts p
+		JOIN threads t ON t.id = p.thread_id
+		JOIN boards b ON b.id = t.board_id
+		WHERE p.created >= $1 AND p.deleted_at IS NULL AND `+visible+`
+		GROUP BY t.id, t.board_id, b.name, t.title, t.author
+		ORDER BY recent_posts DESC, MAX(p.created) DESC, t.id DESC
+		`+fmt.Sprintf("LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2), append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var threads []*TrendingThread
+	for rows.Next() {
+		var t TrendingThread
+		var author sql.NullString
+		if err := rows.Scan(&t.ID, &t.BoardID, &t.BoardName, &t.Title, &author, &t.RecentPosts); err != nil {
+			return nil, err
+		}
+		t.Author = author.String
 		threads = append(threads, &t)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 	return threads, nil
 }
 
-func searchThreads(db *sql.DB, query string, limit int) ([]*ThreadSearchResult, error) {
+// countTrending counts the threads getTrending ranks for viewer.
+func countTrending(ctx context.Context, db *sql.DB, viewer string, window time.Duration) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	visible, args := visibleBoardClause("t.board_id", viewer, 2)
+	args = append([]interface{}{time.Now().Add(-window)}, args...)
+	var count int
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT p.thread_id)
+		FROM posts p
+		JOIN threads t ON t.id = p.thread_id
+		WHERE p.created >= $1 AND p.deleted_at IS NULL AND `+visible, args...).Scan(&count)
+	return count, err
+}
+
+// searchThreads finds threads whose title, author, tags or live posts match
+// query. Threads on restricted boards viewer can't see are filtered out
+// before the limit applies.
+func searchThreads(ctx context.Context, db *sql.DB, viewer, query string, limit int) ([]*ThreadSearchResult, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	if strings.TrimSpace(query) == "" {
 		return []*ThreadSearchResult{}, nil
 	}
+	visible, visibleArgs := visibleBoardClause("t.board_id", viewer, 2)
+	limitParam := fmt.Sprintf("LIMIT $%d", len(visibleArgs)+2)
 	var rows *sql.Rows
 	var err error
 	if dbDriver == "sqlite3" && sqliteFTSAvailable {
@@ -925,7 +1977,7 @@ func searchThreads(db *sql.DB, query string, limit int) ([]*ThreadSearchResult,
 		if ftsQuery == "" {
 			return []*ThreadSearchResult{}, nil
 		}
-		rows, err = db.Query(`
+		rows, err = db.QueryContext(ctx, `
 			WITH fts_matches AS (
 				SELECT t.id AS thread_id, bm25(threads_fts) AS score
 				FROM threads_fts
@@ -946,15 +1998,16 @@ func searchThreads(db *sql.DB, query string, limit int) ([]*ThreadSearchResult,
 			FROM ranked
 			JOIN threads t ON t.id = ranked.thread_id
 			JOIN boards b ON b.id = t.board_id
-			ORDER BY ranked.score, t.created DESC
-			LIMIT $2`, ftsQuery, limit)
+			WHERE `+visible+`
+			ORDER BY ranked.score, t.created DESC, t.id DESC
+			`+limitParam, append(append([]interface{}{ftsQuery}, visibleArgs...), limit)...)
 	} else if dbDriver == "sqlite3" {
 		like := "%" + query + "%"
-		rows, err = db.Query(`
+		rows, err = db.QueryContext(ctx, `
 			SELECT t.id, t.board_id, b.name, t.title, t.author, t.created
 			FROM threads t
 			JOIN boards b ON b.id = t.board_id
-			WHERE t.title LIKE $1 COLLATE NOCASE
+			WHERE (t.title LIKE $1 COLLATE NOCASE
 				OR t.author LIKE $1 COLLATE NOCASE
 				OR t.tags LIKE $1 COLLATE NOCASE
 				OR EXISTS (
@@ -962,16 +2015,16 @@ func searchThreads(db *sql.DB, query string, limit int) ([]*ThreadSearchResult,
 					WHERE p.thread_id = t.id
 						AND p.deleted_at IS NULL
 						AND p.content LIKE $1 COLLATE NOCASE
-				)
-			ORDER BY t.created DESC
-			LIMIT $2`, like, limit)
+				)) AND `+visible+`
+			ORDER BY t.created DESC, t.id DESC
+			`+limitParam, append(append([]interface{}{like}, visibleArgs...), limit)...)
 	} else {
 		like := "%" + query + "%"
-		rows, err = db.Query(`
+		rows, err = db.QueryContext(ctx, `
 			SELECT t.id, t.board_id, b.name, t.title, t.author, t.created
 			FROM threads t
 			JOIN boards b ON b.id = t.board_id
-			WHERE t.title ILIKE $1
+			WHERE (t.title ILIKE $1
 				OR t.author ILIKE $1
 				OR t.tags ILIKE $1
 				OR EXISTS (
@@ -979,9 +2032,9 @@ func searchThreads(db *sql.DB, query string, limit int) ([]*ThreadSearchResult,
 					WHERE p.thread_id = t.id
 						AND p.deleted_at IS NULL
 						AND p.content ILIKE $1
-				)
-			ORDER BY t.created DESC
-			LIMIT $2`, like, limit)
+				)) AND `+visible+`
+			ORDER BY t.created DESC, t.id DESC
+			`+limitParam, append(append([]interface{}{like}, visibleArgs...), limit)...)
 	}
 	if err != nil {
 		return nil, err
@@ -996,26 +2049,41 @@ func searchThreads(db *sql.DB, query string, limit int) ([]*ThreadSearchResult,
 		}
 		threads = append(threads, &t)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 	return threads, nil
 }
 
 // getThreadByID retrieves a specific thread by ID, along with its posts and board ID.
-func getThreadByID(db *sql.DB, threadID int) (*Thread, int, error) {
+func getThreadByID(ctx context.Context, db *sql.DB, threadID int) (*Thread, int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	var t Thread
 	var boardID int
 	var author sql.NullString
 	var tagString sql.NullString
-	err := db.QueryRow(`SELECT id, board_id, title, author, tags, created FROM threads WHERE id = $1`, threadID).
-		Scan(&t.ID, &boardID, &t.Title, &author, &tagString, &t.Created)
+	var lastBump sql.NullTime
+	var acceptedPostID sql.NullInt64
+	err := db.QueryRowContext(ctx, `SELECT id, board_id, title, author, tags, created, last_bump, accepted_post_id, slow_mode_seconds, is_locked, is_sticky FROM threads WHERE id = $1`, threadID).
+		Scan(&t.ID, &boardID, &t.Title, &author, &tagString, &t.Created, &lastBump, &acceptedPostID, &t.SlowModeSeconds, &t.IsLocked, &t.IsSticky)
 	if err == sql.ErrNoRows {
 		return nil, 0, fmt.Errorf("thread not found")
 	} else if err != nil {
 		return nil, 0, err
 	}
+	t.LastBump = t.Created
+	if lastBump.Valid {
+		t.LastBump = lastBump.Time
+	}
+	if acceptedPostID.Valid {
+		id := int(acceptedPostID.Int64)
+		t.AcceptedPostID = &id
+	}
 	t.Author = author.String
 	t.Tags = tagsFromString(tagString.String)
 
-	posts, err := getPostsByThreadID(db, threadID)
+	posts, err := getPostsByThreadID(ctx, db, threadID)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -1024,52 +2092,268 @@ func getThreadByID(db *sql.DB, threadID int) (*Thread, int, error) {
 	return &t, boardID, nil
 }
 
-// createPost inserts a new post into the database.
-func createPost(db *sql.DB, threadID int, author, content string) (*Post, error) {
-	now := time.Now()
-	number, flair := generateUniqueNumberAndFlair()
-	var id int
-	if dbDriver == "pgx" {
-		err := db.QueryRow(`
-		INSERT INTO posts (thread_id, author, content, created, number, flair) 
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id`,
-			threadID, author, content, now, number.String(), flair).Scan(&id)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		result, err := db.Exec(`
-		INSERT INTO posts (thread_id, author, content, created, number, flair) 
-		VALUES ($1, $2, $3, $4, $5, $6)`,
-			threadID, author, content, now, number.String(), flair)
-		if err != nil {
-			return nil, err
-		}
-		insertID, err := result.LastInsertId()
-		if err != nil {
-			return nil, err
-		}
-		id = int(insertID)
-	}
-	return &Post{
-		ID:      id,
-		Author:  author,
-		Content: content,
-		Created: now,
-		Number:  number,
-		Flair:   flair,
-	}, nil
-}
+// errPostingTooFast is returned by createPost when the author posted less
+// than postCooldown ago.
+var errPostingTooFast = errors.New("you're posting too fast")
 
-// generateUniqueNumberAndFlair generates a unique random large number and assigns a flair based on the number of preceding zeroes.
-func generateUniqueNumberAndFlair() (*big.Int, string) {
-	number, _ := rand.Int(rand.Reader, big.NewInt(1e10))
-	numberStr := fmt.Sprintf("%d", number)
+// errDuplicatePost is returned by createPost when the author repeats their
+// last post in a thread within duplicatePostWindow.
+var errDuplicatePost = errors.New("duplicate post")
 
-	zeroCount := 0
-	for _, char := range numberStr {
-		if char == '0' {
+// duplicatePostWindow is how long an identical repost in the same thread is
+// treated as an accidental double-post.
+const duplicatePostWindow = 10 * time.Minute
+
+// checkPostCooldown returns errPostingTooFast if author's latest post,
+// anonymous or not, is newer than postCooldown.
+func checkPostCooldown(ctx context.Context, db dbConn, author string) error {
+	if postCooldown <= 0 || author == "" {
+		return nil
+	}
+	var last time.Time
+	err := db.QueryRowContext(ctx, `SELECT created FROM posts WHERE posted_by = $1 ORDER BY created DESC LIMIT 1`, author).Scan(&last)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if time.Since(last) < postCooldown {
+		return errPostingTooFast
+	}
+	return nil
+}
+
+// maxPostEmailLength caps a post's email field at the longest valid address.
+const maxPostEmailLength = 254
+
+// errPostEmailTooLong is returned by createPost for an email field longer
+// than maxPostEmailLength.
+var errPostEmailTooLong = fmt.Errorf("email longer than %d characters", maxPostEmailLength)
+
+// errThreadLocked is returned by createPost for replies to a locked thread.
+var errThreadLocked = errors.New("thread is locked")
+
+// checkThreadLocked returns errThreadLocked if the thread is locked and author
+// isn't a moderator.
+func checkThreadLocked(ctx context.Context, db dbConn, threadID int, author string) error {
+	if isModerator(author) {
+		return nil
+	}
+	var locked bool
+	err := db.QueryRowContext(ctx, `SELECT is_locked FROM threads WHERE id = $1`, threadID).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if locked {
+		return errThreadLocked
+	}
+	return nil
+}
+
+// errSlowMode is returned by createPost when the author posted in a slow-mode
+// thread more recently than its interval allows.
+var errSlowMode = errors.New("thread is in slow mode")
+
+// maxSlowModeSeconds caps a thread's slow mode interval at a day.
+const maxSlowModeSeconds = 24 * 60 * 60
+
+// slowModeRemaining reports how long author must wait before posting in the
+// thread again. Moderators are never slowed down.
+func slowModeRemaining(ctx context.Context, db dbConn, threadID int, author string) (time.Duration, error) {
+	if author == "" || isModerator(author) {
+		return 0, nil
+	}
+	var seconds int
+	err := db.QueryRowContext(ctx, `SELECT slow_mode_seconds FROM threads WHERE id = $1`, threadID).Scan(&seconds)
+	if err == sql.ErrNoRows || (err == nil && seconds <= 0) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var last time.Time
+	err = db.QueryRowContext(ctx, `SELECT created FROM posts WHERE thread_id = $1 AND posted_by = $2 ORDER BY created DESC LIMIT 1`, threadID, author).Scan(&last)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	remaining := time.Duration(seconds)*time.Second - time.Since(last)
+	if remaining < 0 {
+		return 0, nil
+	}
+	return remaining, nil
+}
+
+// checkDuplicatePost returns errDuplicatePost if author's previous post in the
+// thread has the same content and is newer than duplicatePostWindow.
+func checkDuplicatePost(ctx context.Context, db dbConn, threadID int, author, content string) error {
+	var lastContent string
+	var lastCreated time.Time
+	err := db.QueryRowContext(ctx, `
+		SELECT content, created FROM posts
+		WHERE thread_id = $1 AND posted_by = $2 AND deleted_at IS NULL
+		ORDER BY created DESC, id DESC
+		LIMIT 1`, threadID, author).Scan(&lastContent, &lastCreated)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(lastContent) == strings.TrimSpace(content) && time.Since(lastCreated) < duplicatePostWindow {
+		return errDuplicatePost
+	}
+	return nil
+}
+
+// anonymousPostKey marks a context whose createPost call should leave the
+// post without an author.
+type anonymousPostKey struct{}
+
+// withAnonymousPost returns ctx asking createPost to post anonymously: the
+// post is stored without an author and shown under its board's anonymous
+// name. author is still kept as posted_by for flood control.
+func withAnonymousPost(ctx context.Context) context.Context {
+	return context.WithValue(ctx, anonymousPostKey{}, true)
+}
+
+func isAnonymousPost(ctx context.Context) bool {
+	anonymous, _ := ctx.Value(anonymousPostKey{}).(bool)
+	return anonymous
+}
+
+// createPost inserts a new post into the database and bumps its thread unless
+// the email field is "sage". Given the pool rather than a transaction, it
+// runs in one of its own, so the checks, insert, and bump can't interleave
+// with another post's.
+func createPost(ctx context.Context, db dbConn, threadID int, author, content, email string) (*Post, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	pool, ok := db.(*sql.DB)
+	if !ok {
+		return insertPost(ctx, db, threadID, author, content, email)
+	}
+	tx, err := pool.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	post, err := insertPost(ctx, tx, threadID, author, content, email)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// insertPost does createPost's work on db.
+func insertPost(ctx context.Context, db dbConn, threadID int, author, content, email string) (*Post, error) {
+	email = strings.TrimSpace(email)
+	if len(email) > maxPostEmailLength {
+		return nil, errPostEmailTooLong
+	}
+	if err := checkPostCooldown(ctx, db, author); err != nil {
+		return nil, err
+	}
+	if err := checkThreadLocked(ctx, db, threadID, author); err != nil {
+		return nil, err
+	}
+	remaining, err := slowModeRemaining(ctx, db, threadID, author)
+	if err != nil {
+		return nil, err
+	}
+	if remaining > 0 {
+		return nil, errSlowMode
+	}
+	if err := checkDuplicatePost(ctx, db, threadID, author, content); err != nil {
+		return nil, err
+	}
+	postedBy := sql.NullString{String: author, Valid: author != ""}
+	if isAnonymousPost(ctx) {
+		author = ""
+	}
+	boardNumber, err := nextBoardPostNumber(ctx, db, threadID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	number, flair := generateUniqueNumberAndFlair()
+	if isSage(email) {
+		email = "sage"
+	}
+	ip := sql.NullString{String: posterIP(ctx)}
+	ip.Valid = ip.String != ""
+	key := sql.NullString{String: ipKey(ip.String)}
+	key.Valid = key.String != ""
+	var id int
+	if dbDriver == "pgx" {
+		err := db.QueryRowContext(ctx, `
+		INSERT INTO posts (thread_id, author, content, created, number, flair, email, board_number, ip, ip_key, posted_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id`,
+			threadID, author, content, now, number.String(), flair, email, boardNumber, ip, key, postedBy).Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		result, err := db.ExecContext(ctx, `
+		INSERT INTO posts (thread_id, author, content, created, number, flair, email, board_number, ip, ip_key, posted_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+			threadID, author, content, now, number.String(), flair, email, boardNumber, ip, key, postedBy)
+		if err != nil {
+			return nil, err
+		}
+		insertID, err := result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		id = int(insertID)
+	}
+	if !isSage(email) {
+		if _, err := db.ExecContext(ctx, `UPDATE threads SET last_bump = $1 WHERE id = $2`, now, threadID); err != nil {
+			return nil, err
+		}
+	}
+	post := &Post{
+		ID:          id,
+		Author:      author,
+		Content:     content,
+		Created:     now,
+		Number:      number,
+		BoardNumber: int(boardNumber.Int64),
+		Flair:       flair,
+		Email:       email,
+	}
+	if storeRenderedPosts {
+		// Heading anchors carry the post ID, so this can't happen before the insert.
+		if err := storePostRender(ctx, db, post); err != nil {
+			return nil, err
+		}
+	}
+	return post, nil
+}
+
+// isSage reports whether a post's email field asks not to bump the thread.
+func isSage(email string) bool {
+	return strings.EqualFold(strings.TrimSpace(email), "sage")
+}
+
+// generateUniqueNumberAndFlair generates a unique random large number and assigns a flair based on the number of preceding zeroes.
+func generateUniqueNumberAndFlair() (*big.Int, string) {
+	number, _ := rand.Int(rand.Reader, big.NewInt(1e10))
+	numberStr := fmt.Sprintf("%d", number)
+
+	zeroCount := 0
+	for _, char := range numberStr {
+		if char == '0' {
 			zeroCount++
 		} else {
 			break
@@ -1096,65 +2380,226 @@ func generateUniqueNumberAndFlair() (*big.Int, string) {
 }
 
 // getPostsByThreadID retrieves all posts for a specific thread.
-func getPostsByThreadID(db *sql.DB, threadID int) ([]*Post, error) {
-	rows, err := db.Query(`
-		SELECT id, author, content, created, number, flair, deleted_at, deleted_by, deleted_reason
+func getPostsByThreadID(ctx context.Context, db *sql.DB, threadID int) ([]*Post, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, author, content, created, number, flair, deleted_at, deleted_by, deleted_reason, email, badge, board_number,
+			rendered_content, render_version
 		FROM posts
 		WHERE thread_id = $1
-		ORDER BY created ASC`, threadID)
+		ORDER BY created ASC, id ASC`, threadID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var posts []*Post
-	for rows.Next() {
-		var p Post
-		var numberStr string
-		var deletedAt sql.NullTime
-		var deletedBy sql.NullString
-		var deletedReason sql.NullString
-		if err := rows.Scan(&p.ID, &p.Author, &p.Content, &p.Created, &numberStr, &p.Flair, &deletedAt, &deletedBy, &deletedReason); err != nil {
-			return nil, err
-		}
-		if deletedAt.Valid {
-			p.IsDeleted = true
-			p.Content = ""
-			p.DeletedAt = &deletedAt.Time
-			p.DeletedBy = deletedBy.String
-			p.DeletedReason = deletedReason.String
-		}
-		p.Number = new(big.Int)
-		p.Number.SetString(numberStr, 10)
-		posts = append(posts, &p)
+	posts, err := scanPostRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := attachPostTreesAndScores(ctx, db, posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// getPostsSince retrieves a thread's visible posts with IDs above afterID, in
+// created order, for clients polling for new replies.
+func getPostsSince(ctx context.Context, db *sql.DB, threadID, afterID int) ([]*Post, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, author, content, created, number, flair, deleted_at, deleted_by, deleted_reason, email, badge, board_number,
+			rendered_content, render_version
+		FROM posts
+		WHERE thread_id = $1 AND id > $2 AND deleted_at IS NULL
+		ORDER BY created ASC, id ASC`, threadID, afterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts, err := scanPostRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := attachPostTreesAndScores(ctx, db, posts); err != nil {
+		return nil, err
 	}
+	return posts, nil
+}
+
+// attachPostTreesAndScores fills in each post's card trees and vote score.
+func attachPostTreesAndScores(ctx context.Context, db *sql.DB, posts []*Post) error {
 	postIDs := make([]int, 0, len(posts))
 	for _, post := range posts {
 		postIDs = append(postIDs, post.ID)
 	}
-	treesByPostID, err := getCardTreesByScopeIDs(db, "post", postIDs, true)
+	treesByPostID, err := getCardTreesByScopeIDs(ctx, db, "post", postIDs, true)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	scores := map[int]int{}
+	if postVotesEnabled {
+		if scores, err = getPostScores(ctx, db, postIDs); err != nil {
+			return err
+		}
 	}
 	for _, post := range posts {
 		post.Trees = treesByPostID[post.ID]
+		post.Score = scores[post.ID]
+	}
+	return nil
+}
+
+// scanPostRows reads post rows selected with the standard post column list.
+func scanPostRows(rows *sql.Rows) ([]*Post, error) {
+	var posts []*Post
+	for rows.Next() {
+		p, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 	return posts, nil
 }
 
-func createCardTree(db *sql.DB, scopeType string, scopeID int, title, description, createdBy string, isPrimary bool) (*CardTree, error) {
+// scanPost scans the current row's post columns, in the order the post
+// selects list them, followed by any extra columns into extra.
+func scanPost(rows *sql.Rows, extra ...interface{}) (*Post, error) {
+	var p Post
+	var numberStr string
+	var deletedAt sql.NullTime
+	var deletedBy sql.NullString
+	var deletedReason sql.NullString
+	var email sql.NullString
+	var badge sql.NullString
+	var boardNumber sql.NullInt64
+	var rendered sql.NullString
+	dest := []interface{}{&p.ID, &p.Author, &p.Content, &p.Created, &numberStr, &p.Flair, &deletedAt, &deletedBy, &deletedReason, &email, &badge, &boardNumber,
+		&rendered, &p.RenderVersion}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	p.RenderedContent = rendered.String
+	p.Email = email.String
+	p.Badge = badge.String
+	p.BoardNumber = int(boardNumber.Int64)
+	if deletedAt.Valid {
+		p.IsDeleted = true
+		p.Content = ""
+		p.RenderedContent = ""
+		p.Email = ""
+		p.DeletedAt = &deletedAt.Time
+		p.DeletedBy = deletedBy.String
+		p.DeletedReason = deletedReason.String
+	}
+	p.Number = new(big.Int)
+	p.Number.SetString(numberStr, 10)
+	return &p, nil
+}
+
+// getThreadPreview loads the opening post and the last tailCount replies for a thread,
+// along with how many replies in between were left out.
+func getThreadPreview(ctx context.Context, db *sql.DB, threadID, tailCount int) (*ThreadPreview, error) {
+	previews, err := queryThreadPreviews(ctx, db, `thread_id = $1`, threadID, tailCount)
+	if err != nil {
+		return nil, err
+	}
+	if preview, ok := previews[threadID]; ok {
+		return preview, nil
+	}
+	return &ThreadPreview{Tail: []*Post{}}, nil
+}
+
+// getBoardThreadPreviews loads getThreadPreview's preview for every thread on
+// a board in one query, keyed by thread ID. Threads without posts are left
+// out.
+func getBoardThreadPreviews(ctx context.Context, db *sql.DB, boardID, tailCount int) (map[int]*ThreadPreview, error) {
+	return queryThreadPreviews(ctx, db, `thread_id IN (SELECT id FROM threads WHERE board_id = $1)`, boardID, tailCount)
+}
+
+// queryThreadPreviews builds previews for the threads whose posts match
+// where, which takes arg as $1. Window functions number each thread's posts
+// from both ends, so the opening post and the tail come back in one pass.
+func queryThreadPreviews(ctx context.Context, db *sql.DB, where string, arg interface{}, tailCount int) (map[int]*ThreadPreview, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	if tailCount < 0 {
+		tailCount = 0
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, author, content, created, number, flair, deleted_at, deleted_by, deleted_reason, email, badge, board_number,
+			rendered_content, render_version, thread_id, total, live, first_rank
+		FROM (
+			SELECT posts.*,
+				ROW_NUMBER() OVER (PARTITION BY thread_id ORDER BY created ASC, id ASC) AS first_rank,
+				ROW_NUMBER() OVER (PARTITION BY thread_id ORDER BY created DESC, id DESC) AS last_rank,
+				COUNT(*) OVER (PARTITION BY thread_id) AS total,
+				SUM(CASE WHEN deleted_at IS NULL THEN 1 ELSE 0 END) OVER (PARTITION BY thread_id) AS live
+			FROM posts
+			WHERE `+where+`
+		) ranked
+		WHERE first_rank = 1 OR last_rank <= $2
+		ORDER BY thread_id, created ASC, id ASC`, arg, tailCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	previews := make(map[int]*ThreadPreview)
+	for rows.Next() {
+		var threadID, total, live, firstRank int
+		post, err := scanPost(rows, &threadID, &total, &live, &firstRank)
+		if err != nil {
+			return nil, err
+		}
+		preview, ok := previews[threadID]
+		if !ok {
+			preview = &ThreadPreview{Tail: []*Post{}, Total: total, LiveReplies: live}
+			previews[threadID] = preview
+		}
+		if firstRank == 1 {
+			preview.OP = post
+			if !post.IsDeleted {
+				preview.LiveReplies--
+			}
+		} else {
+			preview.Tail = append(preview.Tail, post)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, preview := range previews {
+		preview.Omitted = preview.Total - 1 - len(preview.Tail)
+		if preview.Omitted < 0 {
+			preview.Omitted = 0
+		}
+	}
+	return previews, nil
+}
+
+func createCardTree(ctx context.Context, db dbConn, scopeType string, scopeID int, title, description, createdBy string, isPrimary bool) (*CardTree, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	if scopeType != "board" && scopeType != "thread" && scopeType != "post" {
 		return nil, fmt.Errorf("invalid scope type")
 	}
 	now := time.Now()
 	if isPrimary {
-		if _, err := db.Exec(`UPDATE card_trees SET is_primary = FALSE WHERE scope_type = $1 AND scope_id = $2`, scopeType, scopeID); err != nil {
+		if _, err := db.ExecContext(ctx, `UPDATE card_trees SET is_primary = FALSE WHERE scope_type = $1 AND scope_id = $2`, scopeType, scopeID); err != nil {
 			return nil, err
 		}
 	}
 	var id int
 	if dbDriver == "pgx" {
-		err := db.QueryRow(`
+		err := db.QueryRowContext(ctx, `
 			INSERT INTO card_trees (scope_type, scope_id, title, description, created_by, created_at, updated_at, is_primary)
 			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 			RETURNING id`,
@@ -1163,7 +2608,7 @@ func createCardTree(db *sql.DB, scopeType string, scopeID int, title, descriptio
 			return nil, err
 		}
 	} else {
-		result, err := db.Exec(`
+		result, err := db.ExecContext(ctx, `
 			INSERT INTO card_trees (scope_type, scope_id, title, description, created_by, created_at, updated_at, is_primary)
 			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
 			scopeType, scopeID, title, description, createdBy, now, now, isPrimary)
@@ -1190,12 +2635,14 @@ func createCardTree(db *sql.DB, scopeType string, scopeID int, title, descriptio
 	}, nil
 }
 
-func getCardTreesByScope(db *sql.DB, scopeType string, scopeID int, loadNodes bool) ([]*CardTree, error) {
-	rows, err := db.Query(`
+func getCardTreesByScope(ctx context.Context, db *sql.DB, scopeType string, scopeID int, loadNodes bool) ([]*CardTree, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `
 		SELECT id, scope_type, scope_id, title, description, created_by, created_at, updated_at, is_primary
 		FROM card_trees
 		WHERE scope_type = $1 AND scope_id = $2
-		ORDER BY is_primary DESC, created_at DESC`, scopeType, scopeID)
+		ORDER BY is_primary DESC, created_at DESC, id DESC`, scopeType, scopeID)
 	if err != nil {
 		return nil, err
 	}
@@ -1209,24 +2656,61 @@ func getCardTreesByScope(db *sql.DB, scopeType string, scopeID int, loadNodes bo
 			return nil, err
 		}
 		t.Description = description.String
-		if loadNodes {
-			nodes, err := getCardTreeNodesByTreeID(db, t.ID)
+		trees = append(trees, &t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Load nodes after releasing the rows so the connection isn't held open
+	// across nested queries.
+	if loadNodes {
+		for _, t := range trees {
+			nodes, err := getCardTreeNodesByTreeID(ctx, db, t.ID)
 			if err != nil {
 				return nil, err
 			}
 			t.Nodes = nodes
 		}
-		trees = append(trees, &t)
 	}
 	return trees, nil
 }
 
-func getCardTreesByCreator(db *sql.DB, username string) ([]*CardTree, error) {
-	rows, err := db.Query(`
-		SELECT id, scope_type, scope_id, title, description, created_by, created_at, updated_at, is_primary
-		FROM card_trees
-		WHERE created_by = $1
-		ORDER BY updated_at DESC`, username)
+// liveCardTreeScope filters card_trees (aliased t) to trees whose board,
+// thread, or post still exists and hasn't been deleted.
+const liveCardTreeScope = `(
+			(t.scope_type = 'board' AND EXISTS (SELECT 1 FROM boards b WHERE b.id = t.scope_id))
+			OR (t.scope_type = 'thread' AND EXISTS (SELECT 1 FROM threads th WHERE th.id = t.scope_id))
+			OR (t.scope_type = 'post' AND EXISTS (SELECT 1 FROM posts p WHERE p.id = t.scope_id AND p.deleted_at IS NULL))
+		)`
+
+// cardTreeBoardID is the board a card tree (aliased t) hangs off, through
+// its thread or post when it isn't scoped to a board directly.
+const cardTreeBoardID = `(CASE t.scope_type
+			WHEN 'board' THEN t.scope_id
+			WHEN 'thread' THEN (SELECT th.board_id FROM threads th WHERE th.id = t.scope_id)
+			ELSE (SELECT th.board_id FROM posts p JOIN threads th ON th.id = p.thread_id WHERE p.id = t.scope_id)
+		END)`
+
+// getCardTreesByCreator lists the trees a user created, most recently updated
+// first, with their node counts. Trees on deleted boards, threads, or posts
+// are left out, as are trees on restricted boards viewer can't see. A limit
+// of 0 returns every tree.
+func getCardTreesByCreator(ctx context.Context, db *sql.DB, username, viewer string, limit, offset int) ([]*CardTree, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	visible, args := visibleBoardClause(cardTreeBoardID, viewer, 2)
+	query := `
+		SELECT t.id, t.scope_type, t.scope_id, t.title, t.description, t.created_by, t.created_at, t.updated_at, t.is_primary,
+			(SELECT COUNT(*) FROM card_tree_nodes n WHERE n.tree_id = t.id) AS node_count
+		FROM card_trees t
+		WHERE t.created_by = $1 AND ` + liveCardTreeScope + ` AND ` + visible + `
+		ORDER BY t.updated_at DESC, t.id DESC`
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", limit, offset)
+	}
+	rows, err := db.QueryContext(ctx, query, append([]interface{}{username}, args...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -1236,16 +2720,35 @@ func getCardTreesByCreator(db *sql.DB, username string) ([]*CardTree, error) {
 	for rows.Next() {
 		var t CardTree
 		var description sql.NullString
-		if err := rows.Scan(&t.ID, &t.ScopeType, &t.ScopeID, &t.Title, &description, &t.CreatedBy, &t.CreatedAt, &t.UpdatedAt, &t.IsPrimary); err != nil {
+		if err := rows.Scan(&t.ID, &t.ScopeType, &t.ScopeID, &t.Title, &description, &t.CreatedBy, &t.CreatedAt, &t.UpdatedAt, &t.IsPrimary, &t.NodeCount); err != nil {
 			return nil, err
 		}
 		t.Description = description.String
 		trees = append(trees, &t)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 	return trees, nil
 }
 
-func getCardTreesByScopeIDs(db *sql.DB, scopeType string, scopeIDs []int, loadNodes bool) (map[int][]*CardTree, error) {
+// countCardTreesByCreator counts the trees getCardTreesByCreator would list.
+func countCardTreesByCreator(ctx context.Context, db *sql.DB, username, viewer string) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	visible, args := visibleBoardClause(cardTreeBoardID, viewer, 2)
+	var count int
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM card_trees t
+		WHERE t.created_by = $1 AND `+liveCardTreeScope+` AND `+visible,
+		append([]interface{}{username}, args...)...).Scan(&count)
+	return count, err
+}
+
+func getCardTreesByScopeIDs(ctx context.Context, db *sql.DB, scopeType string, scopeIDs []int, loadNodes bool) (map[int][]*CardTree, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	treesByScope := make(map[int][]*CardTree)
 	if len(scopeIDs) == 0 {
 		return treesByScope, nil
@@ -1270,7 +2773,7 @@ func getCardTreesByScopeIDs(db *sql.DB, scopeType string, scopeIDs []int, loadNo
 		SELECT id, scope_type, scope_id, title, description, created_by, created_at, updated_at, is_primary
 		FROM card_trees
 		WHERE scope_type = %s AND scope_id IN (%s)
-		ORDER BY scope_id ASC, is_primary DESC, created_at DESC`,
+		ORDER BY scope_id ASC, is_primary DESC, created_at DESC, id DESC`,
 		func() string {
 			if dbDriver == "pgx" {
 				return "$1"
@@ -1279,7 +2782,7 @@ func getCardTreesByScopeIDs(db *sql.DB, scopeType string, scopeIDs []int, loadNo
 		}(),
 		strings.Join(placeholders, ","),
 	)
-	rows, err := db.Query(query, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -1293,7 +2796,7 @@ func getCardTreesByScopeIDs(db *sql.DB, scopeType string, scopeIDs []int, loadNo
 		}
 		t.Description = description.String
 		if loadNodes {
-			nodes, err := getCardTreeNodesByTreeID(db, t.ID)
+			nodes, err := getCardTreeNodesByTreeID(ctx, db, t.ID)
 			if err != nil {
 				return nil, err
 			}
@@ -1301,14 +2804,19 @@ func getCardTreesByScopeIDs(db *sql.DB, scopeType string, scopeIDs []int, loadNo
 		}
 		treesByScope[t.ScopeID] = append(treesByScope[t.ScopeID], &t)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
 	return treesByScope, nil
 }
 
-func getCardTreeByID(db *sql.DB, treeID int) (*CardTree, error) {
+func getCardTreeByID(ctx context.Context, db *sql.DB, treeID int) (*CardTree, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	var t CardTree
 	var description sql.NullString
-	err := db.QueryRow(`
+	err := db.QueryRowContext(ctx, `
 		SELECT id, scope_type, scope_id, title, description, created_by, created_at, updated_at, is_primary
 		FROM card_trees
 		WHERE id = $1`, treeID).
@@ -1320,7 +2828,7 @@ func getCardTreeByID(db *sql.DB, treeID int) (*CardTree, error) {
 		return nil, err
 	}
 	t.Description = description.String
-	nodes, err := getCardTreeNodesByTreeID(db, t.ID)
+	nodes, err := getCardTreeNodesByTreeID(ctx, db, t.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -1328,9 +2836,11 @@ func getCardTreeByID(db *sql.DB, treeID int) (*CardTree, error) {
 	return &t, nil
 }
 
-func getCardTreeNodeTreeID(db *sql.DB, nodeID int) (int, error) {
+func getCardTreeNodeTreeID(ctx context.Context, db dbConn, nodeID int) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	var treeID int
-	err := db.QueryRow(`SELECT tree_id FROM card_tree_nodes WHERE id = $1`, nodeID).Scan(&treeID)
+	err := db.QueryRowContext(ctx, `SELECT tree_id FROM card_tree_nodes WHERE id = $1`, nodeID).Scan(&treeID)
 	if err == sql.ErrNoRows {
 		return 0, fmt.Errorf("node not found")
 	}
@@ -1340,40 +2850,99 @@ func getCardTreeNodeTreeID(db *sql.DB, nodeID int) (int, error) {
 	return treeID, nil
 }
 
-func createCardTreeNode(db *sql.DB, treeID int, parentID *int, cardName string, position int, createdBy string) (*CardTreeNode, error) {
-	if parentID != nil {
-		parentTreeID, err := getCardTreeNodeTreeID(db, *parentID)
-		if err != nil {
-			return nil, err
+// unsetPosition asks createCardTreeNode to put a node after its last sibling,
+// and updateCardTreeNode to leave it where it is.
+const unsetPosition = -1
+
+// placeCardTreeNode renumbers the children of parentID to 0..n-1 in
+// (position, id) order with nodeID slotted in at position, or last when
+// position is past the end or unsetPosition. It returns nodeID's final
+// position. Pass nodeID 0 to just close gaps, e.g. after a delete. It takes
+// several statements, so callers run it in a transaction.
+func placeCardTreeNode(ctx context.Context, db dbConn, treeID int, parentID *int, nodeID, position int) (int, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, position FROM card_tree_nodes
+		WHERE tree_id = $1 AND COALESCE(parent_id, 0) = COALESCE($2, 0) AND id <> $3
+		ORDER BY position ASC, id ASC`, treeID, parentID, nodeID)
+	if err != nil {
+		return 0, err
+	}
+	var ids, positions []int
+	for rows.Next() {
+		var id, pos int
+		if err := rows.Scan(&id, &pos); err != nil {
+			rows.Close()
+			return 0, err
 		}
-		if parentTreeID != treeID {
-			return nil, fmt.Errorf("parent node does not belong to tree")
+		ids = append(ids, id)
+		positions = append(positions, pos)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if nodeID != 0 {
+		if position < 0 || position > len(ids) {
+			position = len(ids)
+		}
+		ids = append(ids[:position], append([]int{nodeID}, ids[position:]...)...)
+		positions = append(positions[:position], append([]int{-1}, positions[position:]...)...)
+	}
+	for i, id := range ids {
+		if positions[i] == i {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, `UPDATE card_tree_nodes SET position = $1 WHERE id = $2`, i, id); err != nil {
+			return 0, err
 		}
 	}
+	return position, nil
+}
+
+func createCardTreeNode(ctx context.Context, db dbConn, treeID int, parentID *int, cardName string, position int, createdBy string) (*CardTreeNode, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	now := time.Now()
 	var id int
-	if dbDriver == "pgx" {
-		err := db.QueryRow(`
-			INSERT INTO card_tree_nodes (tree_id, parent_id, card_name, position, created_by, created_at, updated_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7)
-			RETURNING id`,
-			treeID, parentID, cardName, position, createdBy, now, now).Scan(&id)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		result, err := db.Exec(`
-			INSERT INTO card_tree_nodes (tree_id, parent_id, card_name, position, created_by, created_at, updated_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-			treeID, parentID, cardName, position, createdBy, now, now)
-		if err != nil {
-			return nil, err
+	err := inTx(ctx, db, func(tx dbConn) error {
+		if parentID != nil {
+			parentTreeID, err := getCardTreeNodeTreeID(ctx, tx, *parentID)
+			if err != nil {
+				return err
+			}
+			if parentTreeID != treeID {
+				return fmt.Errorf("parent node does not belong to tree")
+			}
 		}
-		insertID, err := result.LastInsertId()
-		if err != nil {
-			return nil, err
+		if dbDriver == "pgx" {
+			err := tx.QueryRowContext(ctx, `
+				INSERT INTO card_tree_nodes (tree_id, parent_id, card_name, position, created_by, created_at, updated_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+				RETURNING id`,
+				treeID, parentID, cardName, 0, createdBy, now, now).Scan(&id)
+			if err != nil {
+				return err
+			}
+		} else {
+			result, err := tx.ExecContext(ctx, `
+				INSERT INTO card_tree_nodes (tree_id, parent_id, card_name, position, created_by, created_at, updated_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+				treeID, parentID, cardName, 0, createdBy, now, now)
+			if err != nil {
+				return err
+			}
+			insertID, err := result.LastInsertId()
+			if err != nil {
+				return err
+			}
+			id = int(insertID)
 		}
-		id = int(insertID)
+		var err error
+		position, err = placeCardTreeNode(ctx, tx, treeID, parentID, id, position)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 	return &CardTreeNode{
 		ID:        id,
@@ -1387,13 +2956,40 @@ func createCardTreeNode(db *sql.DB, treeID int, parentID *int, cardName string,
 	}, nil
 }
 
-func updateCardTreeNode(db *sql.DB, nodeID int, parentID *int, cardName string, position int) error {
-	treeID, err := getCardTreeNodeTreeID(db, nodeID)
+// updateCardTreeNode renames and moves a node. Its old siblings close the
+// gap, and it's slotted in among its new ones at position (see
+// placeCardTreeNode); unsetPosition keeps its place under the same parent
+// or appends it under a new one.
+func updateCardTreeNode(ctx context.Context, db *sql.DB, nodeID int, parentID *int, cardName string, position int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var treeID, current int
+	var oldParent sql.NullInt64
+	err = tx.QueryRowContext(ctx, `SELECT tree_id, parent_id, position FROM card_tree_nodes WHERE id = $1`, nodeID).
+		Scan(&treeID, &oldParent, &current)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("node not found")
+	}
 	if err != nil {
 		return err
 	}
+	var oldParentID *int
+	if oldParent.Valid {
+		id := int(oldParent.Int64)
+		oldParentID = &id
+	}
+	sameParent := (oldParentID == nil && parentID == nil) || (oldParentID != nil && parentID != nil && *oldParentID == *parentID)
+	if position < 0 && sameParent {
+		position = current
+	}
 	if parentID != nil {
-		parentTreeID, err := getCardTreeNodeTreeID(db, *parentID)
+		parentTreeID, err := getCardTreeNodeTreeID(ctx, tx, *parentID)
 		if err != nil {
 			return err
 		}
@@ -1402,23 +2998,64 @@ func updateCardTreeNode(db *sql.DB, nodeID int, parentID *int, cardName string,
 		}
 	}
 	now := time.Now()
-	_, err = db.Exec(`
+	if _, err := tx.ExecContext(ctx, `
 		UPDATE card_tree_nodes
-		SET parent_id = $1, card_name = $2, position = $3, updated_at = $4
-		WHERE id = $5`, parentID, cardName, position, now, nodeID)
-	return err
+		SET parent_id = $1, card_name = $2, updated_at = $3
+		WHERE id = $4`, parentID, cardName, now, nodeID); err != nil {
+		return err
+	}
+	if !sameParent {
+		if _, err := placeCardTreeNode(ctx, tx, treeID, oldParentID, 0, unsetPosition); err != nil {
+			return err
+		}
+	}
+	if _, err := placeCardTreeNode(ctx, tx, treeID, parentID, nodeID, position); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func deleteCardTreeNode(db *sql.DB, nodeID int) error {
-	_, err := db.Exec(`DELETE FROM card_tree_nodes WHERE id = $1`, nodeID)
-	return err
+// deleteCardTreeNode removes a node (and, by cascade, its subtree) and closes
+// the gap among its siblings.
+func deleteCardTreeNode(ctx context.Context, db *sql.DB, nodeID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var treeID int
+	var parent sql.NullInt64
+	err = tx.QueryRowContext(ctx, `SELECT tree_id, parent_id FROM card_tree_nodes WHERE id = $1`, nodeID).Scan(&treeID, &parent)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM card_tree_nodes WHERE id = $1`, nodeID); err != nil {
+		return err
+	}
+	var parentID *int
+	if parent.Valid {
+		id := int(parent.Int64)
+		parentID = &id
+	}
+	if _, err := placeCardTreeNode(ctx, tx, treeID, parentID, 0, unsetPosition); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func createCardTreeAnnotation(db *sql.DB, nodeID int, kind, body, label, tags string, sourcePostID *int, createdBy string) (*CardTreeAnnotation, error) {
+func createCardTreeAnnotation(ctx context.Context, db dbConn, nodeID int, kind, body, label, tags string, sourcePostID *int, createdBy string) (*CardTreeAnnotation, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	now := time.Now()
 	var id int
 	if dbDriver == "pgx" {
-		err := db.QueryRow(`
+		err := db.QueryRowContext(ctx, `
 			INSERT INTO card_tree_annotations (node_id, kind, body, label, tags, source_post_id, created_by, created_at)
 			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 			RETURNING id`,
@@ -1427,7 +3064,7 @@ func createCardTreeAnnotation(db *sql.DB, nodeID int, kind, body, label, tags st
 			return nil, err
 		}
 	} else {
-		result, err := db.Exec(`
+		result, err := db.ExecContext(ctx, `
 			INSERT INTO card_tree_annotations (node_id, kind, body, label, tags, source_post_id, created_by, created_at)
 			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
 			nodeID, kind, body, label, tags, sourcePostID, createdBy, now)
@@ -1453,18 +3090,23 @@ func createCardTreeAnnotation(db *sql.DB, nodeID int, kind, body, label, tags st
 	}, nil
 }
 
-func deleteCardTreeAnnotation(db *sql.DB, annotationID int) error {
-	_, err := db.Exec(`DELETE FROM card_tree_annotations WHERE id = $1`, annotationID)
+// deleteCardTreeAnnotation deletes an annotation if it's on nodeID.
+func deleteCardTreeAnnotation(ctx context.Context, db *sql.DB, nodeID, annotationID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	_, err := db.ExecContext(ctx, `DELETE FROM card_tree_annotations WHERE id = $1 AND node_id = $2`, annotationID, nodeID)
 	return err
 }
 
-func getCardTreeAnnotationsByTreeID(db *sql.DB, treeID int) (map[int][]*CardTreeAnnotation, error) {
-	rows, err := db.Query(`
+func getCardTreeAnnotationsByTreeID(ctx context.Context, db *sql.DB, treeID int) (map[int][]*CardTreeAnnotation, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `
 		SELECT a.id, a.node_id, a.kind, a.body, a.label, a.tags, a.source_post_id, a.created_by, a.created_at
 		FROM card_tree_annotations a
 		JOIN card_tree_nodes n ON a.node_id = n.id
 		WHERE n.tree_id = $1
-		ORDER BY a.created_at ASC`, treeID)
+		ORDER BY a.created_at ASC, a.id ASC`, treeID)
 	if err != nil {
 		return nil, err
 	}
@@ -1483,11 +3125,21 @@ func getCardTreeAnnotationsByTreeID(db *sql.DB, treeID int) (map[int][]*CardTree
 		}
 		annotations[a.NodeID] = append(annotations[a.NodeID], &a)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 	return annotations, nil
 }
 
-func getCardTreeNodesByTreeID(db *sql.DB, treeID int) ([]*CardTreeNode, error) {
-	rows, err := db.Query(`
+// treeIndentStep is the display indent, in pixels, per level of tree depth.
+const treeIndentStep = 16
+
+// getCardTreeNodesByTreeID returns a tree's nodes depth-first, children
+// ordered by Position, with Depth (distance from the root) and Indent set.
+func getCardTreeNodesByTreeID(ctx context.Context, db *sql.DB, treeID int) ([]*CardTreeNode, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `
 		SELECT id, tree_id, parent_id, card_name, position, created_by, created_at, updated_at
 		FROM card_tree_nodes
 		WHERE tree_id = $1
@@ -1517,6 +3169,9 @@ func getCardTreeNodesByTreeID(db *sql.DB, treeID int) ([]*CardTreeNode, error) {
 			children[*n.ParentID] = append(children[*n.ParentID], &n)
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
 	if len(nodes) == 0 {
 		return nodes, nil
@@ -1537,12 +3192,17 @@ func getCardTreeNodesByTreeID(db *sql.DB, treeID int) ([]*CardTreeNode, error) {
 		return roots[i].Position < roots[j].Position
 	})
 
-	var ordered []*CardTreeNode
+	ordered := make([]*CardTreeNode, 0, len(nodes))
+	visited := make(map[int]bool, len(nodes))
 	var walk func(list []*CardTreeNode, depth int)
 	walk = func(list []*CardTreeNode, depth int) {
 		for _, node := range list {
+			if visited[node.ID] {
+				continue
+			}
+			visited[node.ID] = true
 			node.Depth = depth
-			node.Indent = depth * 16
+			node.Indent = depth * treeIndentStep
 			ordered = append(ordered, node)
 			if kids, ok := children[node.ID]; ok {
 				walk(kids, depth+1)
@@ -1550,8 +3210,15 @@ func getCardTreeNodesByTreeID(db *sql.DB, treeID int) ([]*CardTreeNode, error) {
 		}
 	}
 	walk(roots, 0)
+	// Nodes whose parent is missing, or that sit in a parent cycle, aren't
+	// reachable from a root; show them as roots rather than dropping them.
+	for _, node := range nodes {
+		if !visited[node.ID] {
+			walk([]*CardTreeNode{node}, 0)
+		}
+	}
 
-	annotations, err := getCardTreeAnnotationsByTreeID(db, treeID)
+	annotations, err := getCardTreeAnnotationsByTreeID(ctx, db, treeID)
 	if err != nil {
 		return nil, err
 	}
@@ -1563,9 +3230,11 @@ func getCardTreeNodesByTreeID(db *sql.DB, treeID int) ([]*CardTreeNode, error) {
 	return ordered, nil
 }
 
-func getPostThreadID(db *sql.DB, postID int) (int, error) {
+func getPostThreadID(ctx context.Context, db *sql.DB, postID int) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	var threadID int
-	err := db.QueryRow(`SELECT thread_id FROM posts WHERE id = $1`, postID).Scan(&threadID)
+	err := db.QueryRowContext(ctx, `SELECT thread_id FROM posts WHERE id = $1`, postID).Scan(&threadID)
 	if err == sql.ErrNoRows {
 		return 0, fmt.Errorf("post not found")
 	}
@@ -1575,12 +3244,184 @@ func getPostThreadID(db *sql.DB, postID int) (int, error) {
 	return threadID, nil
 }
 
-func softDeletePost(db *sql.DB, postID int, deletedBy, reason string) error {
+// getPostAuthor returns the username that wrote a post.
+func getPostAuthor(ctx context.Context, db *sql.DB, postID int) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	var author sql.NullString
+	err := db.QueryRowContext(ctx, `SELECT author FROM posts WHERE id = $1`, postID).Scan(&author)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("post not found")
+	}
+	if err != nil {
+		return "", err
+	}
+	return author.String, nil
+}
+
+// linkPreviewLength caps the excerpt carried with a resolved cross-thread link.
+const linkPreviewLength = 140
+
+// resolvePostLinks sets Links on each live post to the cross-thread
+// references that point at an existing thread or post. References to
+// missing, deleted, or restricted targets are left as plain text.
+func resolvePostLinks(ctx context.Context, db *sql.DB, posts []*Post) error {
+	resolved := make(map[string]*PostLink)
+	for _, post := range posts {
+		if post == nil || post.IsDeleted {
+			continue
+		}
+		post.Links = nil
+		for _, ref := range parseCrossReferences(post.Content) {
+			link, seen := resolved[ref.Raw]
+			if !seen {
+				var err error
+				link, err = resolveCrossReference(ctx, db, ref)
+				if err != nil {
+					return err
+				}
+				resolved[ref.Raw] = link
+			}
+			if link != nil {
+				post.Links = append(post.Links, link)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveCrossReference looks up one reference, returning nil if its target
+// doesn't exist or isn't public.
+func resolveCrossReference(ctx context.Context, db *sql.DB, ref crossReference) (*PostLink, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	link := &PostLink{Ref: ref.Raw, ThreadID: ref.ThreadID, PostID: ref.PostID}
+	var boardName, visibility, content string
+	var author sql.NullString
+	var err error
+	if ref.PostID > 0 {
+		err = db.QueryRowContext(ctx, `
+			SELECT t.title, b.name, b.visibility, p.author, p.content
+			FROM posts p
+			JOIN threads t ON t.id = p.thread_id
+			JOIN boards b ON b.id = t.board_id
+			WHERE p.id = $1 AND p.thread_id = $2 AND p.deleted_at IS NULL`,
+			ref.PostID, ref.ThreadID).Scan(&link.Title, &boardName, &visibility, &author, &content)
+	} else {
+		err = db.QueryRowContext(ctx, `
+			SELECT t.title, b.name, b.visibility, t.author,
+				COALESCE((SELECT content FROM posts WHERE thread_id = t.id AND deleted_at IS NULL ORDER BY id LIMIT 1), '')
+			FROM threads t
+			JOIN boards b ON b.id = t.board_id
+			WHERE t.id = $1`,
+			ref.ThreadID).Scan(&link.Title, &boardName, &visibility, &author, &content)
+	}
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if normalizeBoardVisibility(visibility) == boardVisibilityRestricted {
+		return nil, nil
+	}
+	if ref.Board != "" && !strings.EqualFold(ref.Board, strings.Trim(boardName, "/")) {
+		return nil, nil
+	}
+	link.Author = author.String
+	link.Preview = makeExcerpt(content, linkPreviewLength)
+	link.Path = fmt.Sprintf("/view/thread/%d", ref.ThreadID)
+	if ref.PostID > 0 {
+		link.Path += fmt.Sprintf("#post-%d", ref.PostID)
+	}
+	link.URL = absURL(link.Path)
+	return link, nil
+}
+
+// postRemovedReportNote is the resolution note on reports closed because
+// their post was deleted.
+const postRemovedReportNote = "post removed"
+
+// softDeletePost hides a post and resolves any open reports against it, so
+// the moderation queue doesn't keep pointing at removed posts.
+func softDeletePost(ctx context.Context, db *sql.DB, postID int, deletedBy, reason string) error {
+	return softDeletePosts(ctx, db, []int{postID}, deletedBy, reason)
+}
+
+var errPostNotDeletable = errors.New("post not found or already deleted")
+
+// maxBulkDeletePosts caps how many posts one bulk delete may remove.
+const maxBulkDeletePosts = 500
+
+// modActionPostDelete is the mod_actions action for a post a moderator
+// removed.
+const modActionPostDelete = "post.delete"
+
+// softDeletePosts hides every post in postIDs for the same reason, resolves
+// their open reports, and logs each removal in mod_actions. It's all or
+// nothing: if any post is missing or already deleted, none are.
+func softDeletePosts(ctx context.Context, db *sql.DB, postIDs []int, deletedBy, reason string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	now := time.Now()
-	result, err := db.Exec(`
-		UPDATE posts
-		SET deleted_at = $1, deleted_by = $2, deleted_reason = $3
-		WHERE id = $4 AND deleted_at IS NULL`, now, deletedBy, reason, postID)
+	for _, postID := range postIDs {
+		result, err := tx.ExecContext(ctx, `
+			UPDATE posts
+			SET deleted_at = $1, deleted_by = $2, deleted_reason = $3
+			WHERE id = $4 AND deleted_at IS NULL`, now, deletedBy, reason, postID)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return fmt.Errorf("post %d: %w", postID, errPostNotDeletable)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE reports
+			SET resolved_at = $1, resolved_by = $2, resolution_note = $3
+			WHERE post_id = $4 AND resolved_at IS NULL`, now, deletedBy, postRemovedReportNote, postID); err != nil {
+			return err
+		}
+		var boardID, threadID int
+		if err := tx.QueryRowContext(ctx, `
+			SELECT t.board_id, t.id FROM posts p JOIN threads t ON t.id = p.thread_id
+			WHERE p.id = $1`, postID).Scan(&boardID, &threadID); err != nil {
+			return err
+		}
+		detail := fmt.Sprintf("post %d: %s", postID, reason)
+		if err := recordModAction(ctx, tx, deletedBy, modActionPostDelete, boardID, threadID, detail); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	postRenderCache.forget(postIDs...)
+	return nil
+}
+
+// setThreadAcceptedPost marks a reply as the thread's accepted answer, or
+// clears it when postID is nil.
+func setThreadAcceptedPost(ctx context.Context, db *sql.DB, threadID int, postID *int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	_, err := db.ExecContext(ctx, `UPDATE threads SET accepted_post_id = $1 WHERE id = $2`, postID, threadID)
+	return err
+}
+
+// setThreadLocked locks or unlocks a thread for replies.
+func setThreadLocked(ctx context.Context, db *sql.DB, threadID int, locked bool) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result, err := db.ExecContext(ctx, `UPDATE threads SET is_locked = $1 WHERE id = $2`, locked, threadID)
 	if err != nil {
 		return err
 	}
@@ -1589,16 +3430,238 @@ func softDeletePost(db *sql.DB, postID int, deletedBy, reason string) error {
 		return err
 	}
 	if rows == 0 {
-		return fmt.Errorf("post not found or already deleted")
+		return fmt.Errorf("thread not found")
 	}
 	return nil
 }
 
-func createReport(db *sql.DB, postID int, category, reason, reportedBy string) (*Report, error) {
+// setThreadSticky pins a thread to the top of its board, which also exempts
+// it from retention, or unpins it.
+func setThreadSticky(ctx context.Context, db *sql.DB, threadID int, sticky bool) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result, err := db.ExecContext(ctx, `UPDATE threads SET is_sticky = $1 WHERE id = $2`, sticky, threadID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("thread not found")
+	}
+	return nil
+}
+
+// setThreadSlowMode sets the minimum seconds between one user's posts in a
+// thread; zero turns slow mode off.
+func setThreadSlowMode(ctx context.Context, db *sql.DB, threadID, seconds int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result, err := db.ExecContext(ctx, `UPDATE threads SET slow_mode_seconds = $1 WHERE id = $2`, seconds, threadID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("thread not found")
+	}
+	return nil
+}
+
+// setPostBadge sets or, with an empty badge, clears a moderator badge on a post.
+func setPostBadge(ctx context.Context, db *sql.DB, postID int, badge string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result, err := db.ExecContext(ctx, `UPDATE posts SET badge = $1 WHERE id = $2`, badge, postID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("post not found")
+	}
+	return nil
+}
+
+// errVoteDeletedPost is returned by votePost for a post that has been
+// deleted.
+var errVoteDeletedPost = errors.New("post has been deleted")
+
+// votePost records a +1 or -1 vote by username on a post. Repeating the
+// current vote, or voting 0, removes it. It returns the user's resulting vote.
+func votePost(ctx context.Context, db *sql.DB, postID int, username string, value int) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	var deletedAt sql.NullTime
+	err := db.QueryRowContext(ctx, `SELECT deleted_at FROM posts WHERE id = $1`, postID).Scan(&deletedAt)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("post not found")
+	}
+	if err != nil {
+		return 0, err
+	}
+	if deletedAt.Valid {
+		return 0, errVoteDeletedPost
+	}
+	var current int
+	err = db.QueryRowContext(ctx, `SELECT value FROM post_votes WHERE post_id = $1 AND username = $2`, postID, username).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	if value == 0 || value == current {
+		if _, err := db.ExecContext(ctx, `DELETE FROM post_votes WHERE post_id = $1 AND username = $2`, postID, username); err != nil {
+			return 0, err
+		}
+		return 0, nil
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO post_votes (post_id, username, value, created)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (post_id, username) DO UPDATE SET value = excluded.value, created = excluded.created`,
+		postID, username, value, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+// postIDPlaceholders returns an IN list of placeholders for postIDs, numbered
+// after offset leading arguments.
+func postIDPlaceholders(postIDs []int, offset int) (string, []interface{}) {
+	placeholders := make([]string, len(postIDs))
+	args := make([]interface{}, 0, len(postIDs))
+	for i, id := range postIDs {
+		if dbDriver == "pgx" {
+			placeholders[i] = fmt.Sprintf("$%d", i+offset+1)
+		} else {
+			placeholders[i] = "?"
+		}
+		args = append(args, id)
+	}
+	return strings.Join(placeholders, ","), args
+}
+
+// getPostScores sums the votes on each post; posts without votes are omitted.
+func getPostScores(ctx context.Context, db *sql.DB, postIDs []int) (map[int]int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	scores := make(map[int]int)
+	if len(postIDs) == 0 {
+		return scores, nil
+	}
+	placeholders, args := postIDPlaceholders(postIDs, 0)
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT post_id, SUM(value)
+		FROM post_votes
+		WHERE post_id IN (%s)
+		GROUP BY post_id`, placeholders), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var postID, score int
+		if err := rows.Scan(&postID, &score); err != nil {
+			return nil, err
+		}
+		scores[postID] = score
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// getUserPostVotes returns username's votes on the given posts.
+func getUserPostVotes(ctx context.Context, db *sql.DB, username string, postIDs []int) (map[int]int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	votes := make(map[int]int)
+	if username == "" || len(postIDs) == 0 {
+		return votes, nil
+	}
+	placeholders, idArgs := postIDPlaceholders(postIDs, 1)
+	userPlaceholder := "?"
+	if dbDriver == "pgx" {
+		userPlaceholder = "$1"
+	}
+	args := append([]interface{}{username}, idArgs...)
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT post_id, value
+		FROM post_votes
+		WHERE username = %s AND post_id IN (%s)`, userPlaceholder, placeholders), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var postID, value int
+		if err := rows.Scan(&postID, &value); err != nil {
+			return nil, err
+		}
+		votes[postID] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return votes, nil
+}
+
+// maxReportsPerHour caps how many new reports one user can file per hour.
+const maxReportsPerHour = 10
+
+// errReportLimit is returned by createReport when the reporter has hit
+// maxReportsPerHour.
+var errReportLimit = errors.New("report limit reached")
+
+// createReport files a report against a post. If the reporter already has an
+// open report on the post, that report's category and reason are updated
+// instead of filing a second one.
+func createReport(ctx context.Context, db *sql.DB, postID int, category, reason, reportedBy string) (*Report, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	now := time.Now()
 	var id int
+	var created time.Time
+	err := db.QueryRowContext(ctx, `
+		SELECT id, created FROM reports
+		WHERE post_id = $1 AND reported_by = $2 AND resolved_at IS NULL
+		ORDER BY id DESC
+		LIMIT 1`, postID, reportedBy).Scan(&id, &created)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == nil {
+		if _, err := db.ExecContext(ctx, `UPDATE reports SET category = $1, reason = $2 WHERE id = $3`, category, reason, id); err != nil {
+			return nil, err
+		}
+		return &Report{
+			ID:         id,
+			PostID:     postID,
+			Category:   category,
+			Reason:     reason,
+			ReportedBy: reportedBy,
+			Created:    created,
+		}, nil
+	}
+
+	var recent int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM reports WHERE reported_by = $1 AND created > $2`, reportedBy, now.Add(-time.Hour)).Scan(&recent); err != nil {
+		return nil, err
+	}
+	if recent >= maxReportsPerHour {
+		return nil, errReportLimit
+	}
 	if dbDriver == "pgx" {
-		err := db.QueryRow(`
+		err := db.QueryRowContext(ctx, `
 			INSERT INTO reports (post_id, category, reason, reported_by, created)
 			VALUES ($1, $2, $3, $4, $5)
 			RETURNING id`,
@@ -1607,7 +3670,7 @@ func createReport(db *sql.DB, postID int, category, reason, reportedBy string) (
 			return nil, err
 		}
 	} else {
-		result, err := db.Exec(`
+		result, err := db.ExecContext(ctx, `
 			INSERT INTO reports (post_id, category, reason, reported_by, created)
 			VALUES ($1, $2, $3, $4, $5)`,
 			postID, category, reason, reportedBy, now)
@@ -1630,8 +3693,10 @@ func createReport(db *sql.DB, postID int, category, reason, reportedBy string) (
 	}, nil
 }
 
-func getOpenReports(db *sql.DB) ([]*ModReport, error) {
-	rows, err := db.Query(`
+func getOpenReports(ctx context.Context, db *sql.DB) ([]*ModReport, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `
 		SELECT r.id, r.post_id, r.category, r.reason, r.reported_by, r.created,
 			r.resolved_at, r.resolved_by, r.resolution_note,
 			p.author, p.content, p.created, p.deleted_at, p.deleted_reason,
@@ -1642,7 +3707,7 @@ func getOpenReports(db *sql.DB) ([]*ModReport, error) {
 		JOIN threads t ON p.thread_id = t.id
 		JOIN boards b ON t.board_id = b.id
 		WHERE r.resolved_at IS NULL
-		ORDER BY r.created DESC`)
+		ORDER BY r.created DESC, r.id DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -1693,12 +3758,25 @@ func getOpenReports(db *sql.DB) ([]*ModReport, error) {
 		}
 		reports = append(reports, &r)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 	return reports, nil
 }
 
-func resolveReport(db *sql.DB, reportID int, resolvedBy, note string) error {
+// resolveReport closes a report. With lockThread set it also locks the thread
+// the reported post is in, in the same transaction.
+func resolveReport(ctx context.Context, db *sql.DB, reportID int, resolvedBy, note string, lockThread bool) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	now := time.Now()
-	result, err := db.Exec(`
+	result, err := tx.ExecContext(ctx, `
 		UPDATE reports
 		SET resolved_at = $1, resolved_by = $2, resolution_note = $3
 		WHERE id = $4 AND resolved_at IS NULL`, now, resolvedBy, note, reportID)
@@ -1712,19 +3790,77 @@ func resolveReport(db *sql.DB, reportID int, resolvedBy, note string) error {
 	if rows == 0 {
 		return fmt.Errorf("report not found or already resolved")
 	}
-	return nil
+	if lockThread {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE threads
+			SET is_locked = $1
+			WHERE id = (SELECT p.thread_id FROM reports r JOIN posts p ON p.id = r.post_id WHERE r.id = $2)`, true, reportID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-// deleteBoardByID deletes a board and its associated threads and posts from the database.
-func deleteBoardByID(db *sql.DB, boardID int) error {
-	if _, err := db.Exec(`DELETE FROM posts WHERE thread_id IN (SELECT id FROM threads WHERE board_id = $1)`, boardID); err != nil {
+// deleteBoardByID deletes a board with its threads, their posts, votes, and
+// reports, and every card tree scoped to the board, its threads, or its
+// posts, all in one transaction. Warnings and annotations elsewhere that
+// pointed at its posts are kept without the link, as purgeThread does.
+func deleteBoardByID(ctx context.Context, db *sql.DB, boardID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
 		return err
 	}
-	if _, err := db.Exec(`DELETE FROM threads WHERE board_id = $1`, boardID); err != nil {
+	defer tx.Rollback()
+
+	const boardThreads = `SELECT id FROM threads WHERE board_id = $1`
+	const boardPosts = `SELECT id FROM posts WHERE thread_id IN (` + boardThreads + `)`
+	const boardTrees = `SELECT id FROM card_trees
+		WHERE (scope_type = 'board' AND scope_id = $1)
+			OR (scope_type = 'thread' AND scope_id IN (` + boardThreads + `))
+			OR (scope_type = 'post' AND scope_id IN (` + boardPosts + `))`
+
+	rows, err := tx.QueryContext(ctx, boardPosts, boardID)
+	if err != nil {
 		return err
 	}
-	_, err := db.Exec(`DELETE FROM boards WHERE id = $1`, boardID)
-	return err
+	var postIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		postIDs = append(postIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, stmt := range []string{
+		`DELETE FROM post_votes WHERE post_id IN (` + boardPosts + `)`,
+		`DELETE FROM reports WHERE post_id IN (` + boardPosts + `)`,
+		`UPDATE user_warnings SET post_id = NULL WHERE post_id IN (` + boardPosts + `)`,
+		`DELETE FROM card_tree_annotations WHERE node_id IN (SELECT id FROM card_tree_nodes WHERE tree_id IN (` + boardTrees + `))`,
+		`DELETE FROM card_tree_nodes WHERE tree_id IN (` + boardTrees + `)`,
+		`DELETE FROM card_trees WHERE id IN (` + boardTrees + `)`,
+		`UPDATE card_tree_annotations SET source_post_id = NULL WHERE source_post_id IN (` + boardPosts + `)`,
+		`DELETE FROM posts WHERE thread_id IN (` + boardThreads + `)`,
+		`DELETE FROM threads WHERE board_id = $1`,
+		`DELETE FROM board_members WHERE board_id = $1`,
+		`DELETE FROM boards WHERE id = $1`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, boardID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	postRenderCache.forget(postIDs...)
+	return nil
 }
 
 func hashPassword(password string) (string, error) {
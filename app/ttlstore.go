@@ -0,0 +1,171 @@
+package app
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// ------------------- Expiring Store -------------------
+
+// ttlStoreShards spreads keys over several locks so busy stores don't
+// serialize every request on one mutex.
+const ttlStoreShards = 16
+
+// ttlStoreGCInterval is how often Run sweeps expired entries.
+const ttlStoreGCInterval = time.Minute
+
+type ttlEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+type ttlShard[V any] struct {
+	mu      sync.Mutex
+	entries map[string]ttlEntry[V]
+}
+
+// ttlStore is a concurrency-safe map whose entries expire. Expired entries
+// are never returned and are removed by sweep, which storeGCWorker calls for
+// every store.
+type ttlStore[V any] struct {
+	shards [ttlStoreShards]ttlShard[V]
+}
+
+// sweeper is a store storeGCWorker can clean up.
+type sweeper interface {
+	sweep(now time.Time) int
+}
+
+var ttlStores = struct {
+	sync.Mutex
+	all []sweeper
+}{}
+
+func newTTLStore[V any]() *ttlStore[V] {
+	s := &ttlStore[V]{}
+	for i := range s.shards {
+		s.shards[i].entries = make(map[string]ttlEntry[V])
+	}
+	ttlStores.Lock()
+	ttlStores.all = append(ttlStores.all, s)
+	ttlStores.Unlock()
+	return s
+}
+
+func (s *ttlStore[V]) shard(key string) *ttlShard[V] {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &s.shards[h.Sum32()%ttlStoreShards]
+}
+
+// Get returns the value stored under key, if it hasn't expired.
+func (s *ttlStore[V]) Get(key string) (V, bool) {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	entry, ok := sh.entries[key]
+	if !ok || !time.Now().Before(entry.expires) {
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// Set stores value under key for ttl.
+func (s *ttlStore[V]) Set(key string, value V, ttl time.Duration) {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.entries[key] = ttlEntry[V]{value: value, expires: time.Now().Add(ttl)}
+}
+
+// Update atomically replaces the value under key with fn's result. fn gets
+// the current value and whether one exists; keep reports whether the
+// existing expiry should be kept rather than reset to ttl from now.
+func (s *ttlStore[V]) Update(key string, ttl time.Duration, fn func(current V, ok bool) (next V, keep bool)) V {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	now := time.Now()
+	entry, ok := sh.entries[key]
+	if ok && !now.Before(entry.expires) {
+		entry, ok = ttlEntry[V]{}, false
+	}
+	next, keep := fn(entry.value, ok)
+	expires := now.Add(ttl)
+	if ok && keep {
+		expires = entry.expires
+	}
+	sh.entries[key] = ttlEntry[V]{value: next, expires: expires}
+	return next
+}
+
+// Delete removes key.
+func (s *ttlStore[V]) Delete(key string) {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	delete(sh.entries, key)
+}
+
+// Clear removes every entry.
+func (s *ttlStore[V]) Clear() {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		sh.entries = make(map[string]ttlEntry[V])
+		sh.mu.Unlock()
+	}
+}
+
+// Len counts stored entries, including expired ones not yet swept.
+func (s *ttlStore[V]) Len() int {
+	n := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		n += len(sh.entries)
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// sweep drops entries that expired by now and returns how many it removed.
+func (s *ttlStore[V]) sweep(now time.Time) int {
+	removed := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for key, entry := range sh.entries {
+			if !now.Before(entry.expires) {
+				delete(sh.entries, key)
+				removed++
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return removed
+}
+
+// sweepStores sweeps every store created so far.
+func sweepStores(now time.Time) int {
+	ttlStores.Lock()
+	stores := append([]sweeper(nil), ttlStores.all...)
+	ttlStores.Unlock()
+	removed := 0
+	for _, s := range stores {
+		removed += s.sweep(now)
+	}
+	return removed
+}
+
+// storeGCWorker sweeps expired entries from every store each interval.
+func storeGCWorker(interval time.Duration) Worker {
+	return every("store-gc", interval, func(ctx context.Context, now time.Time) {
+		if removed := sweepStores(now); removed > 0 {
+			log.Debugf("Expired %d cached entries", removed)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// deletedUsername replaces the author on content left behind by a deleted
+// account. It can't be registered.
+const deletedUsername = "[deleted]"
+
+// listUsers returns every account with its post and warning counts, for
+// moderators.
+func listUsers(ctx context.Context, db *sql.DB) ([]*UserSummary, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `
+		SELECT u.id, u.username, u.created, u.disabled_at,
+			(SELECT COUNT(*) FROM posts p WHERE p.author = u.username),
+			(SELECT COUNT(*) FROM user_warnings w WHERE w.username = u.username)
+		FROM users u
+		ORDER BY u.username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*UserSummary
+	for rows.Next() {
+		var u UserSummary
+		var disabledAt sql.NullTime
+		if err := rows.Scan(&u.ID, &u.Username, &u.Created, &disabledAt, &u.PostCount, &u.WarningCount); err != nil {
+			return nil, err
+		}
+		if disabledAt.Valid {
+			u.DisabledAt = &disabledAt.Time
+		}
+		users = append(users, &u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// setUserDisabled disables or re-enables logins for an account. Disabled
+// accounts' sessions and tokens stop working too.
+func setUserDisabled(ctx context.Context, db *sql.DB, username string, disabled bool) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	var disabledAt interface{}
+	if disabled {
+		disabledAt = time.Now()
+	}
+	result, err := db.ExecContext(ctx, `UPDATE users SET disabled_at = $1 WHERE username = $2`, disabledAt, username)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+// resetUserPassword replaces an account's password.
+func resetUserPassword(ctx context.Context, db *sql.DB, username, password string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	result, err := db.ExecContext(ctx, `UPDATE users SET password_hash = $1 WHERE username = $2`, passwordHash, username)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+// deleteUser removes an account, whether a moderator or the user asked, but
+// keeps what it wrote: threads, posts, trees, reports, and uploads are
+// reassigned to deletedUsername, and post emails and addresses are cleared.
+// Votes, board memberships, and warnings go with the account.
+func deleteUser(ctx context.Context, db *sql.DB, username string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM users WHERE username = $1`, username)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE posts SET ip = NULL, ip_key = NULL WHERE author = $1 OR posted_by = $1`, username); err != nil {
+		return err
+	}
+	stmts := []string{
+		`UPDATE threads SET author = $1 WHERE author = $2`,
+		`UPDATE posts SET author = $1, email = NULL WHERE author = $2`,
+		`UPDATE posts SET posted_by = $1 WHERE posted_by = $2`,
+		`UPDATE card_trees SET created_by = $1 WHERE created_by = $2`,
+		`UPDATE card_tree_nodes SET created_by = $1 WHERE created_by = $2`,
+		`UPDATE card_tree_annotations SET created_by = $1 WHERE created_by = $2`,
+		`UPDATE reports SET reported_by = $1 WHERE reported_by = $2`,
+		`UPDATE attachments SET uploaded_by = $1 WHERE uploaded_by = $2`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt, deletedUsername, username); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM post_votes WHERE username = $1`, username); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM board_members WHERE username = $1`, username); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_warnings WHERE username = $1`, username); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM login_history WHERE username = $1`, username); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
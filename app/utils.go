@@ -1,11 +1,21 @@
 package app
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
+
+	"github.com/gorilla/mux"
 )
 
 const (
@@ -18,9 +28,16 @@ var (
 	errTagLength = errors.New("tag length exceeds limit")
 )
 
-// respondJSON sends JSON responses (for our REST endpoints).
-func respondJSON(w http.ResponseWriter, data interface{}) {
-	payload, err := json.MarshalIndent(data, "", "  ")
+// respondJSON sends JSON responses (for our REST endpoints). Output is
+// compact unless the request asks for pretty-printing (see wantsPrettyJSON).
+func respondJSON(w http.ResponseWriter, r *http.Request, data interface{}) {
+	var payload []byte
+	var err error
+	if wantsPrettyJSON(r) {
+		payload, err = json.MarshalIndent(data, "", "  ")
+	} else {
+		payload, err = json.Marshal(data)
+	}
 	if err != nil {
 		log.Errorf("Failed to encode JSON response: %v", err)
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
@@ -33,6 +50,312 @@ func respondJSON(w http.ResponseWriter, data interface{}) {
 	}
 }
 
+// parseFieldsParam reads a comma-separated ?fields= list. Nil means the
+// caller didn't ask for a projection.
+func parseFieldsParam(r *http.Request) []string {
+	var fields []string
+	for _, field := range strings.Split(r.URL.Query().Get("fields"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// projectFields keeps only the named top-level JSON keys of each item, for
+// ?fields= selectors on list endpoints. Names that don't match a key are
+// ignored.
+func projectFields[T any](items []T, fields []string) ([]map[string]json.RawMessage, error) {
+	projected := make([]map[string]json.RawMessage, 0, len(items))
+	for _, item := range items {
+		raw, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &all); err != nil {
+			return nil, err
+		}
+		kept := make(map[string]json.RawMessage, len(fields))
+		for _, field := range fields {
+			if value, ok := all[field]; ok {
+				kept[field] = value
+			}
+		}
+		projected = append(projected, kept)
+	}
+	return projected, nil
+}
+
+// respondJSONStream writes items as a JSON array one element at a time
+// instead of building the whole payload first, for large list endpoints.
+// Only the first element is encoded before anything goes out; once bytes
+// have been written the status can't change, so later failures are logged
+// and the response is cut short.
+func respondJSONStream[T any](w http.ResponseWriter, r *http.Request, items []T) {
+	pretty := wantsPrettyJSON(r)
+	encode := func(item T) ([]byte, error) {
+		if pretty {
+			return json.MarshalIndent(item, "  ", "  ")
+		}
+		return json.Marshal(item)
+	}
+	if len(items) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := io.WriteString(w, "[]\n"); err != nil {
+			log.Errorf("Failed to write JSON response: %v", err)
+		}
+		return
+	}
+	first, err := encode(items[0])
+	if err != nil {
+		log.Errorf("Failed to encode JSON response: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	prefix, sep, suffix := "[", ",", "]\n"
+	if pretty {
+		prefix, sep, suffix = "[\n  ", ",\n  ", "\n]\n"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := io.WriteString(w, prefix); err != nil {
+		log.Errorf("Failed to stream JSON response: %v", err)
+		return
+	}
+	if _, err := w.Write(first); err != nil {
+		log.Errorf("Failed to stream JSON response: %v", err)
+		return
+	}
+	for _, item := range items[1:] {
+		payload, err := encode(item)
+		if err != nil {
+			log.Errorf("Failed to stream JSON response: %v", err)
+			return
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			log.Errorf("Failed to stream JSON response: %v", err)
+			return
+		}
+		if _, err := w.Write(payload); err != nil {
+			log.Errorf("Failed to stream JSON response: %v", err)
+			return
+		}
+	}
+	if _, err := io.WriteString(w, suffix); err != nil {
+		log.Errorf("Failed to stream JSON response: %v", err)
+	}
+}
+
+// wantsPrettyJSON reports whether a JSON response should be indented for
+// humans: ?pretty=true or false wins, then an Accept header with an indent
+// parameter (application/json; indent=2), then the JANK_JSON_PRETTY default.
+func wantsPrettyJSON(r *http.Request) bool {
+	if r == nil {
+		return prettyJSON
+	}
+	if raw, ok := r.URL.Query()["pretty"]; ok {
+		if raw[0] == "" {
+			return true
+		}
+		if pretty, err := strconv.ParseBool(raw[0]); err == nil {
+			return pretty
+		}
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == "application/json" && params["indent"] != "" {
+			return true
+		}
+	}
+	return prettyJSON
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// respondJSONError writes a JSON error body with the given status code.
+func respondJSONError(w http.ResponseWriter, status int, message string) {
+	payload, err := json.Marshal(apiError{Error: message})
+	if err != nil {
+		http.Error(w, message, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(append(payload, '\n')); err != nil {
+		log.Errorf("Failed to write JSON error: %v", err)
+	}
+}
+
+// respondStoreError reports a failed store call, using 503 when the database timed out.
+func respondStoreError(w http.ResponseWriter, err error, message string) {
+	if isQueryTimeout(err) {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Database busy, try again shortly", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, message, http.StatusInternalServerError)
+}
+
+// parseThreadSort validates a ?sort= value for thread listings; empty means
+// the default bump order.
+func parseThreadSort(raw string) (string, bool) {
+	key := strings.ToLower(strings.TrimSpace(raw))
+	if key == "" {
+		return defaultThreadSort, true
+	}
+	if _, ok := threadSortOrders[key]; !ok {
+		return "", false
+	}
+	return key, true
+}
+
+// maxSlugLength caps URL slugs so long thread titles don't produce huge URLs.
+const maxSlugLength = 60
+
+// urlSlug is slugify capped at maxSlugLength, for board and thread URLs.
+func urlSlug(text string) string {
+	slug := slugify(text)
+	if len(slug) > maxSlugLength {
+		slug = strings.TrimRight(slug[:maxSlugLength], "-")
+	}
+	return slug
+}
+
+// boardURL is a board's canonical path, /view/board/{id}-{slug}. The slug is
+// cosmetic; boards whose names have no slug characters use the bare ID.
+func boardURL(id int, name string) string {
+	if slug := urlSlug(name); slug != "" {
+		return fmt.Sprintf("/view/board/%d-%s", id, slug)
+	}
+	return fmt.Sprintf("/view/board/%d", id)
+}
+
+// threadURL is a thread's canonical path, /view/thread/{id}-{slug}.
+func threadURL(id int, title string) string {
+	if slug := urlSlug(title); slug != "" {
+		return fmt.Sprintf("/view/thread/%d-%s", id, slug)
+	}
+	return fmt.Sprintf("/view/thread/%d", id)
+}
+
+// redirectToCanonicalSlug sends a 301 to canonical when the request came in
+// on a slugged route whose slug is out of date. Bare-ID paths are left alone.
+func redirectToCanonicalSlug(w http.ResponseWriter, r *http.Request, canonical string) bool {
+	if _, ok := mux.Vars(r)["slug"]; !ok || path.Base(r.URL.Path) == path.Base(canonical) {
+		return false
+	}
+	if r.URL.RawQuery != "" {
+		canonical += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, canonical, http.StatusMovedPermanently)
+	return true
+}
+
+var errInvalidPage = errors.New("invalid page parameters")
+
+// parsePageParams reads ?limit= and ?offset=, capping limit at maxLimit and
+// at the site-wide pageConfig.Max. Paged API listings describe the page in
+// response headers (see setPaginationHeaders) rather than the body.
+func parsePageParams(r *http.Request, defaultLimit, maxLimit int) (int, int, error) {
+	maxLimit = min(maxLimit, pageConfig.Max)
+	query := r.URL.Query()
+	limit := min(defaultLimit, maxLimit)
+	if raw := query.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			return 0, 0, errInvalidPage
+		}
+		limit = parsed
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	offset := 0
+	if raw := query.Get("offset"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			return 0, 0, errInvalidPage
+		}
+		offset = parsed
+	}
+	return limit, offset, nil
+}
+
+// pageBounds returns the slice bounds of a limit/offset page over total items.
+func pageBounds(total, limit, offset int) (int, int) {
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return offset, end
+}
+
+// setPaginationHeaders describes a limit/offset page of a list endpoint with
+// X-Total-Count and RFC 5988 Link headers pointing at the neighbouring pages.
+func setPaginationHeaders(w http.ResponseWriter, r *http.Request, total, limit, offset int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if offset+limit < total {
+		w.Header().Add("Link", pageLink(r, limit, offset+limit, "next"))
+	}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		w.Header().Add("Link", pageLink(r, limit, prev, "prev"))
+	}
+}
+
+// pageLink formats a Link header entry for the request's URL at another offset.
+func pageLink(r *http.Request, limit, offset int, rel string) string {
+	u := *r.URL
+	query := u.Query()
+	query.Set("limit", strconv.Itoa(limit))
+	query.Set("offset", strconv.Itoa(offset))
+	u.RawQuery = query.Encode()
+	return "<" + u.RequestURI() + ">; rel=\"" + rel + "\""
+}
+
+// threadCursor marks a position in a bump-ordered thread listing.
+type threadCursor struct {
+	LastBump time.Time
+	ID       int
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// encodeThreadCursor returns an opaque cursor pointing just after thread.
+func encodeThreadCursor(thread *Thread) string {
+	raw := thread.LastBump.Format(time.RFC3339Nano) + "|" + strconv.Itoa(thread.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeThreadCursor(cursor string) (*threadCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	bump, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, errInvalidCursor
+	}
+	lastBump, err := time.Parse(time.RFC3339Nano, bump)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	threadID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	return &threadCursor{LastBump: lastBump, ID: threadID}, nil
+}
+
 func validateTags(tags []string) ([]string, error) {
 	normalized := normalizeTags(tags)
 	if len(normalized) > maxThreadTags {
@@ -97,3 +420,203 @@ func makeExcerpt(content string, limit int) string {
 	}
 	return string([]rune(compact)[:limit-3]) + "..."
 }
+
+// bumpCooldownRemaining is how many whole seconds are left, at now, of the
+// bumpCooldown that started with a thread's last bump.
+func bumpCooldownRemaining(lastBump, now time.Time) int {
+	sinceBump := now.Sub(lastBump)
+	if sinceBump < 0 || sinceBump >= bumpCooldown {
+		return 0
+	}
+	return int((bumpCooldown - sinceBump).Seconds())
+}
+
+// boardNecroThreshold returns how long a thread on board can go without a
+// bump before the thread view warns about reviving it. Zero never warns.
+func boardNecroThreshold(board *Board) time.Duration {
+	if board != nil && board.NecroDays != nil {
+		return time.Duration(*board.NecroDays) * 24 * time.Hour
+	}
+	return necroThreshold
+}
+
+// parseNecroDays reads a board's necro_days form field: blank for the site
+// default, otherwise a whole number of days (0 turns the warning off).
+func parseNecroDays(raw string) (*int, error) {
+	return parseBoardDays("necro days", raw)
+}
+
+// parseRetentionDays reads a board's retention_days form field: blank for
+// the site default, otherwise a whole number of days (0 keeps threads
+// forever).
+func parseRetentionDays(raw string) (*int, error) {
+	return parseBoardDays("retention days", raw)
+}
+
+// parseBoardDays reads an optional per-board day count.
+func parseBoardDays(field, raw string) (*int, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	days, err := strconv.Atoi(raw)
+	if err != nil || days < 0 || days > 36500 {
+		return nil, fmt.Errorf("invalid %s %q", field, raw)
+	}
+	return &days, nil
+}
+
+// maxDerivedTitleLength caps titles taken from an opening post's text.
+const maxDerivedTitleLength = 80
+
+var errThreadTitleRequired = errors.New("thread title is required")
+
+// resolveThreadTitle returns the title a new thread on board is saved with.
+// On boards that don't require titles, an empty title is taken from the
+// opening post's plain text instead.
+func resolveThreadTitle(board *Board, title, content string) (string, error) {
+	if title = strings.TrimSpace(title); title != "" {
+		return title, nil
+	}
+	if board.RequireTitle {
+		return "", errThreadTitleRequired
+	}
+	if derived := makeExcerpt(stripMarkdown(content), maxDerivedTitleLength); derived != "" {
+		return derived, nil
+	}
+	return "", errThreadTitleRequired
+}
+
+var postReferencePattern = regexp.MustCompile(`>>(\d+)(/\d+)?`)
+
+// parsePostReferences returns the distinct post IDs quoted with >>ID.
+// Cross-thread >>threadID/postID references are skipped.
+func parsePostReferences(content string) []int {
+	var refs []int
+	seen := make(map[int]bool)
+	for _, match := range postReferencePattern.FindAllStringSubmatch(content, -1) {
+		if match[2] != "" {
+			continue
+		}
+		id, err := strconv.Atoi(match[1])
+		if err != nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		refs = append(refs, id)
+	}
+	return refs
+}
+
+// crossReferencePattern matches links to other threads: >>>/board/threadID
+// or >>threadID/postID.
+var crossReferencePattern = regexp.MustCompile(`>>>/([A-Za-z0-9_-]+)/(\d+)|>>(\d+)/(\d+)`)
+
+// crossReference is a parsed cross-thread link. Board is set for the
+// >>>/board/threadID form and PostID for the >>threadID/postID form.
+type crossReference struct {
+	Raw      string
+	Board    string
+	ThreadID int
+	PostID   int
+}
+
+// parseCrossReferences returns the distinct cross-thread references in content.
+func parseCrossReferences(content string) []crossReference {
+	var refs []crossReference
+	seen := make(map[string]bool)
+	for _, match := range crossReferencePattern.FindAllStringSubmatch(content, -1) {
+		if seen[match[0]] {
+			continue
+		}
+		seen[match[0]] = true
+		ref := crossReference{Raw: match[0]}
+		if match[1] != "" {
+			ref.Board = match[1]
+			ref.ThreadID, _ = strconv.Atoi(match[2])
+		} else {
+			ref.ThreadID, _ = strconv.Atoi(match[3])
+			ref.PostID, _ = strconv.Atoi(match[4])
+		}
+		if ref.ThreadID == 0 {
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// markPostReplies counts how often each post is quoted within the thread and
+// flags the viewer's own posts and the posts that quote them.
+func markPostReplies(posts []*Post, viewer string) {
+	byID := make(map[int]*Post, len(posts))
+	for _, post := range posts {
+		byID[post.ID] = post
+		post.IsYou = viewer != "" && !post.IsDeleted && post.Author == viewer
+	}
+	for _, post := range posts {
+		if post.IsDeleted {
+			continue
+		}
+		for _, ref := range parsePostReferences(post.Content) {
+			target, ok := byID[ref]
+			if !ok || ref == post.ID {
+				continue
+			}
+			target.QuoteCount++
+			if target.IsYou && !post.IsYou {
+				post.RepliesToYou = true
+			}
+		}
+	}
+}
+
+// collapsePreviewLength is how much of a collapsed post shows before "show
+// more".
+const collapsePreviewLength = 400
+
+// markCollapsiblePosts flags replies longer than collapsePostLength characters
+// and gives them a plain-text preview, so the thread view can fold them. The
+// opening post is always shown in full.
+func markCollapsiblePosts(posts []*Post) {
+	for i, post := range posts {
+		post.Collapsible, post.Preview = false, ""
+		if i == 0 || post.IsDeleted || collapsePostLength <= 0 {
+			continue
+		}
+		if utf8.RuneCountInString(post.Content) <= collapsePostLength {
+			continue
+		}
+		post.Preview = makeExcerpt(stripMarkdown(post.Content), collapsePreviewLength)
+		post.Collapsible = post.Preview != ""
+	}
+}
+
+// parseBoardMembers splits a comma- or newline-separated list of usernames,
+// dropping blanks and duplicates.
+func parseBoardMembers(raw string) []string {
+	parts := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == ';' || r == '\n' || r == '\r' || r == '\t' || r == ' '
+	})
+	seen := make(map[string]struct{})
+	members := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if _, ok := seen[part]; ok {
+			continue
+		}
+		seen[part] = struct{}{}
+		members = append(members, part)
+	}
+	return members
+}
+
+// visibleBoards drops the boards in hidden.
+func visibleBoards(boards []*Board, hidden map[int]bool) []*Board {
+	visible := make([]*Board, 0, len(boards))
+	for _, board := range boards {
+		if !hidden[board.ID] {
+			visible = append(visible, board)
+		}
+	}
+	return visible
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// createUserWarning records a moderator's warning to username, optionally
+// tied to the post that prompted it.
+func createUserWarning(ctx context.Context, db *sql.DB, username string, postID *int, reason, issuedBy string) (*UserWarning, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	now := time.Now()
+	var id int
+	if dbDriver == "pgx" {
+		err := db.QueryRowContext(ctx, `
+			INSERT INTO user_warnings (username, post_id, reason, issued_by, created)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id`,
+			username, postID, reason, issuedBy, now).Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		result, err := db.ExecContext(ctx, `
+			INSERT INTO user_warnings (username, post_id, reason, issued_by, created)
+			VALUES ($1, $2, $3, $4, $5)`,
+			username, postID, reason, issuedBy, now)
+		if err != nil {
+			return nil, err
+		}
+		insertID, err := result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		id = int(insertID)
+	}
+	return &UserWarning{
+		ID:       id,
+		Username: username,
+		PostID:   postID,
+		Reason:   reason,
+		IssuedBy: issuedBy,
+		Created:  now,
+	}, nil
+}
+
+// getUnacknowledgedWarnings lists the warnings username hasn't dismissed yet,
+// oldest first.
+func getUnacknowledgedWarnings(ctx context.Context, db *sql.DB, username string) ([]*UserWarning, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `
+		SELECT w.id, w.username, w.post_id, p.thread_id, w.reason, w.issued_by, w.created
+		FROM user_warnings w
+		LEFT JOIN posts p ON p.id = w.post_id
+		WHERE w.username = $1 AND w.acknowledged_at IS NULL
+		ORDER BY w.created, w.id`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var warnings []*UserWarning
+	for rows.Next() {
+		var w UserWarning
+		var postID, threadID sql.NullInt64
+		if err := rows.Scan(&w.ID, &w.Username, &postID, &threadID, &w.Reason, &w.IssuedBy, &w.Created); err != nil {
+			return nil, err
+		}
+		if postID.Valid {
+			id := int(postID.Int64)
+			w.PostID = &id
+		}
+		w.ThreadID = int(threadID.Int64)
+		warnings = append(warnings, &w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return warnings, nil
+}
+
+// countUserWarnings counts every warning username has received, acknowledged
+// or not, so moderators can see when to escalate.
+func countUserWarnings(ctx context.Context, db *sql.DB, username string) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	var count int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM user_warnings WHERE username = $1`, username).Scan(&count)
+	return count, err
+}
+
+// acknowledgeWarning dismisses one of username's warnings.
+func acknowledgeWarning(ctx context.Context, db *sql.DB, warningID int, username string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result, err := db.ExecContext(ctx, `
+		UPDATE user_warnings
+		SET acknowledged_at = $1
+		WHERE id = $2 AND username = $3 AND acknowledged_at IS NULL`, time.Now(), warningID, username)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("warning not found")
+	}
+	return nil
+}
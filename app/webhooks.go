@@ -0,0 +1,182 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Webhook event names.
+const (
+	webhookReportCreated = "report.created"
+	webhookPostDeleted   = "post.deleted"
+	webhookThreadCreated = "thread.created"
+)
+
+const (
+	// webhookAttempts is how many times a delivery is tried before it's
+	// dropped.
+	webhookAttempts = 4
+	// webhookTimeout bounds each delivery attempt.
+	webhookTimeout = 10 * time.Second
+)
+
+var (
+	// webhookURLs receive every event; Run sets them from JANK_WEBHOOK_URLS.
+	webhookURLs []string
+	// webhookSecret signs each body (X-Jank-Signature); empty sends unsigned.
+	webhookSecret string
+	// webhookBackoff is the wait before the first retry; it doubles after
+	// each failed attempt.
+	webhookBackoff = 2 * time.Second
+	webhookClient  = &http.Client{Timeout: webhookTimeout}
+
+	// webhookDeliveries counts deliveries still in flight, so shutdown can
+	// wait for them.
+	webhookDeliveries sync.WaitGroup
+	webhookPending    atomic.Int64
+	// webhookDraining stops failed deliveries from retrying once shutdown
+	// has begun.
+	webhookDraining atomic.Bool
+)
+
+// webhookEvent is the JSON body POSTed to each endpoint.
+type webhookEvent struct {
+	Event string      `json:"event"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+type webhookThread struct {
+	ID      int    `json:"id"`
+	BoardID int    `json:"board_id"`
+	Title   string `json:"title"`
+	Author  string `json:"author,omitempty"`
+	URL     string `json:"url"`
+}
+
+type webhookPostDeletion struct {
+	PostID    int    `json:"post_id"`
+	DeletedBy string `json:"deleted_by"`
+	Reason    string `json:"reason"`
+}
+
+// parseWebhookURLs splits a comma-separated endpoint list.
+func parseWebhookURLs(raw string) []string {
+	var urls []string
+	for _, entry := range strings.Split(raw, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			urls = append(urls, entry)
+		}
+	}
+	return urls
+}
+
+// signWebhook returns the X-Jank-Signature value for body: "sha256=" and
+// the hex HMAC-SHA256 of the raw body under secret.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// emitWebhook sends event to every configured endpoint in the background, so
+// slow or failing receivers never hold up the request that caused it.
+func emitWebhook(event string, data interface{}) {
+	urls, secret, backoff := webhookURLs, webhookSecret, webhookBackoff
+	if len(urls) == 0 {
+		return
+	}
+	body, err := json.Marshal(webhookEvent{Event: event, Time: time.Now().UTC(), Data: data})
+	if err != nil {
+		log.Errorf("Failed to encode %s webhook: %v", event, err)
+		return
+	}
+	for _, url := range urls {
+		webhookDeliveries.Add(1)
+		webhookPending.Add(1)
+		go func(url string) {
+			defer webhookDeliveries.Done()
+			defer webhookPending.Add(-1)
+			deliverWebhook(url, secret, event, body, backoff)
+		}(url)
+	}
+}
+
+// deliverWebhook POSTs body to url, retrying with exponential backoff until
+// it gets a 2xx or runs out of attempts.
+func deliverWebhook(url, secret, event string, body []byte, backoff time.Duration) {
+	for attempt := 1; ; attempt++ {
+		err := postWebhook(url, secret, event, body)
+		if err == nil {
+			return
+		}
+		if attempt == webhookAttempts || webhookDraining.Load() {
+			log.Errorf("Giving up on %s webhook to %s after %d attempts: %v", event, url, attempt, err)
+			return
+		}
+		log.Warnf("Webhook %s to %s failed (attempt %d): %v", event, url, attempt, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// drainWebhooks stops retries and waits for in-flight deliveries until ctx
+// is done, returning how many were still pending.
+func drainWebhooks(ctx context.Context) int64 {
+	webhookDraining.Store(true)
+	done := make(chan struct{})
+	go func() {
+		webhookDeliveries.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return 0
+	case <-ctx.Done():
+		return webhookPending.Load()
+	}
+}
+
+func postWebhook(url, secret, event string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "jank-webhooks")
+	req.Header.Set("X-Jank-Event", event)
+	if secret != "" {
+		req.Header.Set("X-Jank-Signature", signWebhook(secret, body))
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver returned %s", resp.Status)
+	}
+	return nil
+}
+
+func emitThreadCreated(thread *Thread, boardID int) {
+	emitWebhook(webhookThreadCreated, webhookThread{
+		ID:      thread.ID,
+		BoardID: boardID,
+		Title:   thread.Title,
+		Author:  thread.Author,
+		URL:     baseURL + threadURL(thread.ID, thread.Title),
+	})
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ------------------- Background Workers -------------------
+
+// Worker is a background job Run starts alongside the server. Run should
+// return soon after ctx is done.
+type Worker interface {
+	Name() string
+	Run(ctx context.Context)
+}
+
+// periodicWorker calls tick every interval.
+type periodicWorker struct {
+	name     string
+	interval time.Duration
+	tick     func(ctx context.Context, now time.Time)
+}
+
+// every returns a Worker that calls tick every interval until it's stopped.
+func every(name string, interval time.Duration, tick func(ctx context.Context, now time.Time)) Worker {
+	return &periodicWorker{name: name, interval: interval, tick: tick}
+}
+
+func (w *periodicWorker) Name() string { return w.name }
+
+func (w *periodicWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			w.tick(ctx, now)
+		}
+	}
+}
+
+// workerManager runs registered workers, each on its own goroutine, and
+// stops them together at shutdown.
+type workerManager struct {
+	mu      sync.Mutex
+	workers []Worker
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func newWorkerManager() *workerManager {
+	return &workerManager{}
+}
+
+// Register adds w to the workers Start launches.
+func (m *workerManager) Register(w Worker) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.workers = append(m.workers, w)
+}
+
+// Start launches every registered worker. They run until ctx is done or
+// Stop is called. A worker that panics is logged and not restarted, so one
+// bad job can't take the server down.
+func (m *workerManager) Start(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ctx, m.cancel = context.WithCancel(ctx)
+	for _, w := range m.workers {
+		m.wg.Add(1)
+		go func(w Worker) {
+			defer m.wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					log.Errorf("Worker %s panicked: %v", w.Name(), r)
+				}
+			}()
+			log.Debugf("Worker %s started", w.Name())
+			w.Run(ctx)
+			log.Debugf("Worker %s stopped", w.Name())
+		}(w)
+	}
+}
+
+// Stop cancels the workers and waits up to grace for them to return. It
+// reports whether they all did.
+func (m *workerManager) Stop(grace time.Duration) bool {
+	m.mu.Lock()
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(grace):
+		return false
+	}
+}